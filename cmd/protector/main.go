@@ -3,12 +3,15 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
+	"fmt"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"os/signal"
+	"reflect"
 	"strings"
 	"syscall"
 	"time"
@@ -17,8 +20,11 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
+	"github.com/skywalker-88/stormgate/internal/access"
 	"github.com/skywalker-88/stormgate/internal/httpserver"
+	"github.com/skywalker-88/stormgate/internal/identity"
 	Lm "github.com/skywalker-88/stormgate/internal/middleware"
+	"github.com/skywalker-88/stormgate/internal/plans"
 	"github.com/skywalker-88/stormgate/internal/rl"
 	"github.com/skywalker-88/stormgate/pkg/config"
 )
@@ -68,7 +74,36 @@ func MakeReverseProxy(target string) (*httputil.ReverseProxy, error) {
 	return rp, nil
 }
 
+// checkConfig validates the policy file for CI and returns the process exit code.
+func checkConfig(path string) int {
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		var verr config.ValidationError
+		if errors.As(err, &verr) {
+			for _, e := range verr {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", path, e.Error())
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		}
+		return 1
+	}
+	fmt.Printf("%s: OK\n", path)
+	return 0
+}
+
 func main() {
+	cfgFlag := flag.String("config", config.Path(), "policy file (default: $STORMGATE_CONFIG or configs/policies.yaml)")
+	checkOnly := flag.Bool("check-config", false, "validate the policy file and exit (non-zero on problems)")
+	flag.Parse()
+	if *checkOnly {
+		os.Exit(checkConfig(*cfgFlag))
+	}
+
 	// ------- Logging setup -------
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339})
 	switch strings.ToLower(config.MustEnv("LOG_LEVEL", "info")) {
@@ -83,26 +118,45 @@ func main() {
 	}
 
 	// ---- Load config (with env fallbacks) ----
-	cfgPath := os.Getenv("STORMGATE_CONFIG")
-	if cfgPath == "" {
-		cfgPath = "configs/policies.yaml"
-	}
-	// NOTE: using Load() with internal fallback, as in your current repo
-	cfg, err := config.Load()
+	cfgPath := *cfgFlag
+	cfg, err := config.LoadFile(cfgPath)
 	if err != nil {
 		log.Fatal().Err(err).Str("config", cfgPath).Msg("load config")
 	}
-
-	// Redis client
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     config.MustEnv("REDIS_ADDR", "redis:6379"),
-		Password: "",
-		DB:       0,
-	})
+	if err := cfg.Validate(); err != nil {
+		var verr config.ValidationError
+		if errors.As(err, &verr) {
+			for _, e := range verr {
+				log.Error().Str("path", e.Path).Msg(e.Msg)
+			}
+		}
+		log.Fatal().Err(err).Str("config", cfgPath).Msg("invalid config; refusing to start")
+	}
+	live := config.NewHolder(cfg)
 
 	// limiter + mitigator
-	limiter := rl.New(rdb)
-	mit := rl.NewRedisMitigator(rdb) // NEW
+	var (
+		rdb     redis.UniversalClient // nil with the memory backend
+		limiter rl.Backend
+		mit     rl.Mitigator
+	)
+	switch cfg.Limiter.Backend {
+	case "memory":
+		// Single instance, no Redis: per-process buckets and mitigation state.
+		limiter = rl.Scaled(rl.NewMemory(), func() int { return live.Get().Limiter.Replicas })
+		mit = rl.NewMemoryMitigator()
+	default:
+		// Redis client (redis section of policies.yaml, overridden by REDIS_* env)
+		rdb, err = newRedisClient(cfg.Redis)
+		if err != nil {
+			log.Fatal().Err(err).Msg("redis client")
+		}
+		// limiter + mitigator share one circuit breaker: when Redis is down both
+		// fail fast and each route's on_store_error policy decides.
+		breaker := rl.NewBreaker(cfg.Redis.Breaker.FailureThreshold, ms(cfg.Redis.Breaker.OpenMs))
+		limiter = rl.New(rdb).WithBreaker(breaker)
+		mit = rl.NewRedisMitigator(rdb).WithBreaker(breaker)
+	}
 
 	// start a small background job to keep gauges current  // NEW
 	go func() {
@@ -116,8 +170,13 @@ func main() {
 		}
 	}()
 
+	// allow / deny lists (policy file, list files, Redis sets)
+	lists := access.NewLists(live, rdb)
+	// client -> plan mapping (policy file, mapping file, Redis hash)
+	planner := plans.NewResolver(live, rdb)
+
 	// middleware rate limiter (now takes mitigator)        // CHANGED
-	rlmw := Lm.NewRateLimiter(limiter, cfg, mit)
+	rlmw := Lm.NewRateLimiter(limiter, live, mit, lists)
 
 	// Build reverse proxy target (backend may not exist yet — we’ll return 502)
 	backend := config.MustEnv("BACKEND_URL", "http://demo-backend:8081")
@@ -128,26 +187,64 @@ func main() {
 
 	// Build router
 	router, cleanup := httpserver.NewRouter(
-		httpserver.RouterDeps{Cfg: cfg, RL: rlmw, Mitigator: mit}, // pass Mitigator (optional)
+		httpserver.RouterDeps{Cfg: live, RL: rlmw, Mitigator: mit, Plans: planner}, // pass Mitigator (optional)
 		proxy,
 	)
 
+	// ---- Hot reload (file watch + SIGHUP) ----
+	// A reload that fails to parse or validate is logged and dropped; the previous policy stays live.
+	reload := func(trigger string) {
+		next, err := config.LoadFile(cfgPath)
+		if err == nil {
+			err = next.Validate()
+		}
+		if err != nil {
+			log.Error().Err(err).Str("config", cfgPath).Str("trigger", trigger).Msg("config reload rejected; keeping previous policy")
+			return
+		}
+		if cur := live.Get(); next.Server != cur.Server || !reflect.DeepEqual(next.Redis, cur.Redis) ||
+			next.Limiter.Backend != cur.Limiter.Backend {
+			log.Warn().Str("config", cfgPath).Msg("server/redis/limiter.backend settings changed; they apply on restart only")
+		}
+		live.Swap(next)
+		log.Info().Str("config", cfgPath).Str("trigger", trigger).Msg("config reloaded")
+	}
+	stopWatch, err := config.Watch(cfgPath, func() { reload("file") }, func(err error) {
+		log.Warn().Err(err).Str("config", cfgPath).Msg("config watch stopped; SIGHUP still reloads")
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("config", cfgPath).Msg("config watch unavailable; SIGHUP still reloads")
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reload("sighup")
+		}
+	}()
+
 	// Startup logs
-	addr := config.MustEnv("STORMGATE_HTTP_ADDR", ":8080")
+	addr := cfg.Server.Addr
 	log.Info().
 		Str("addr", addr).
+		Str("limiter_backend", cfg.Limiter.Backend).
+		Str("redis_mode", cfg.Redis.Mode).
+		Str("redis", redisTarget(cfg.Redis)).
+		Bool("redis_tls", cfg.Redis.TLS.Enabled).
 		Str("backend", backend).
 		Str("config", cfgPath).
 		Str("log_level", zerolog.GlobalLevel().String()).
 		Msg("StormGate starting")
 
 	// Non-fatal Redis ping
-	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
-	defer cancel()
-	if err := rdb.Ping(ctx).Err(); err != nil {
-		log.Warn().Err(err).Msg("redis not reachable yet")
-	} else {
-		log.Info().Msg("redis reachable")
+	if rdb != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		if err := rdb.Ping(ctx).Err(); err != nil {
+			log.Warn().Err(err).Msg("redis not reachable yet")
+		} else {
+			log.Info().Msg("redis reachable")
+		}
+		cancel()
 	}
 
 	// http.Server with sane timeouts
@@ -158,10 +255,30 @@ func main() {
 		WriteTimeout:      15 * time.Second,
 		IdleTimeout:       60 * time.Second,
 	}
+	if t := cfg.Server.TLS; t.CertFile != "" {
+		if srv.TLSConfig, err = serverTLSConfig(t); err != nil {
+			log.Fatal().Err(err).Msg("server tls")
+		}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal().Err(err).Str("addr", addr).Msg("listen")
+	}
+	if cfg.Server.ProxyProtocol {
+		ln = httpserver.ProxyProtoListener(ln, func(peer string) bool {
+			return identity.IsTrustedProxy(live.Get(), peer)
+		})
+	}
 
 	go func() {
-		log.Info().Str("addr", srv.Addr).Msg("http server listening")
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Info().Str("addr", srv.Addr).Bool("tls", srv.TLSConfig != nil).
+			Bool("proxy_protocol", cfg.Server.ProxyProtocol).Msg("http server listening")
+		serve := func() error { return srv.Serve(ln) }
+		if srv.TLSConfig != nil {
+			serve = func() error { return srv.ServeTLS(ln, "", "") }
+		}
+		if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatal().Err(err).Msg("server stopped unexpectedly")
 		}
 	}()
@@ -173,6 +290,10 @@ func main() {
 	log.Info().Str("signal", sig.String()).Msg("shutdown requested; draining")
 
 	httpserver.SetDraining(true)
+	signal.Stop(hup)
+	if stopWatch != nil {
+		stopWatch()
+	}
 
 	shCtx, shCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shCancel()
@@ -186,10 +307,14 @@ func main() {
 	if cleanup != nil {
 		cleanup()
 	}
-	if err := rdb.Close(); err != nil {
-		log.Warn().Err(err).Msg("redis close")
-	} else {
-		log.Info().Msg("redis closed")
+	lists.Close()
+	planner.Close()
+	if rdb != nil {
+		if err := rdb.Close(); err != nil {
+			log.Warn().Err(err).Msg("redis close")
+		} else {
+			log.Info().Msg("redis closed")
+		}
 	}
 
 	log.Info().Msg("stormgate exited")
@@ -0,0 +1,236 @@
+// Package access keeps the allow and deny lists (access.allow / access.deny)
+// compiled for lookups on every request. Entries come from the policy file,
+// from list files and from Redis sets; the last two are re-read in the
+// background, so a lookup is one atomic load plus a walk of the radix tree.
+package access
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/netip"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+
+	"github.com/skywalker-88/stormgate/internal/identity"
+	"github.com/skywalker-88/stormgate/pkg/config"
+	"github.com/skywalker-88/stormgate/pkg/metrics"
+)
+
+// list is one compiled access list.
+type list struct {
+	nets  prefixTree          // IPs and CIDRs, matched against the client IP
+	ids   map[string]struct{} // client IDs
+	globs []*regexp.Regexp    // client IDs with '*' / '?'
+}
+
+func (l *list) add(entry string) {
+	switch {
+	case entry == "":
+	case strings.ContainsAny(entry, "*?"):
+		re := "^" + strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(regexp.QuoteMeta(entry)) + "$"
+		l.globs = append(l.globs, regexp.MustCompile(re))
+	default:
+		if p, err := config.ParsePrefix(entry); err == nil {
+			l.nets.insert(p)
+			return
+		}
+		l.ids[entry] = struct{}{}
+	}
+}
+
+func (l *list) size() int { return l.nets.size + len(l.ids) + len(l.globs) }
+
+func (l *list) match(id identity.Identity) bool {
+	if _, ok := l.ids[id.ID]; ok {
+		return true
+	}
+	if a, err := netip.ParseAddr(id.IP); err == nil && l.nets.contains(a) {
+		return true
+	}
+	for _, g := range l.globs {
+		if g.MatchString(id.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+type compiled struct {
+	allow, deny *list
+}
+
+// Lists serves the compiled lists of the live config.
+type Lists struct {
+	cfg *config.Holder
+	rdb redis.UniversalClient // nil: redis_set sources are skipped
+
+	cur   atomic.Pointer[compiled]
+	files map[string]file     // refresh goroutine only
+	sets  map[string][]string // last members read per Redis set; refresh goroutine only
+	kick  chan struct{}
+	stop  chan struct{}
+}
+
+type file struct {
+	mod     time.Time
+	size    int64
+	entries []string
+}
+
+// NewLists compiles the lists once before returning and then keeps them
+// fresh until Close.
+func NewLists(cfg *config.Holder, rdb redis.UniversalClient) *Lists {
+	l := &Lists{
+		cfg:   cfg,
+		rdb:   rdb,
+		files: map[string]file{},
+		sets:  map[string][]string{},
+		kick:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+	}
+	l.refresh()
+	cfg.OnChange(func(*config.Config) {
+		select {
+		case l.kick <- struct{}{}:
+		default:
+		}
+	})
+	go l.loop()
+	return l
+}
+
+func (l *Lists) Close() { close(l.stop) }
+
+// Allowed reports whether id is on the allow list.
+func (l *Lists) Allowed(id identity.Identity) bool {
+	if l == nil {
+		return false
+	}
+	return l.cur.Load().allow.match(id)
+}
+
+// Denied reports whether id is on the deny list and not allowed.
+func (l *Lists) Denied(id identity.Identity) bool {
+	if l == nil {
+		return false
+	}
+	c := l.cur.Load()
+	return c.deny.match(id) && !c.allow.match(id)
+}
+
+func (l *Lists) loop() {
+	for {
+		every := time.Duration(l.cfg.Get().Access.RefreshSeconds) * time.Second
+		if every <= 0 {
+			every = 10 * time.Second
+		}
+		t := time.NewTimer(every)
+		select {
+		case <-l.stop:
+			t.Stop()
+			return
+		case <-l.kick:
+			t.Stop()
+		case <-t.C:
+		}
+		l.refresh()
+	}
+}
+
+func (l *Lists) refresh() {
+	c := l.cfg.Get()
+	allow := l.build("allow", c.Access.Allow, c.Mitigation.Allowlist.Clients)
+	deny := l.build("deny", c.Access.Deny, nil)
+	l.cur.Store(&compiled{allow: allow, deny: deny})
+
+	// forget sources dropped from the config
+	for path := range l.files {
+		if !slices.Contains(c.Access.Allow.Files, path) && !slices.Contains(c.Access.Deny.Files, path) {
+			delete(l.files, path)
+		}
+	}
+	for key := range l.sets {
+		if key != c.Access.Allow.RedisSet && key != c.Access.Deny.RedisSet {
+			delete(l.sets, key)
+		}
+	}
+}
+
+func (l *Lists) build(name string, src config.AccessList, extra []string) *list {
+	out := &list{ids: map[string]struct{}{}}
+	for _, e := range src.Entries {
+		out.add(strings.TrimSpace(e))
+	}
+	for _, e := range extra {
+		out.add(strings.TrimSpace(e))
+	}
+	for _, path := range src.Files {
+		for _, e := range l.readFile(path) {
+			out.add(e)
+		}
+	}
+	if src.RedisSet != "" {
+		for _, e := range l.readSet(src.RedisSet) {
+			out.add(strings.TrimSpace(e))
+		}
+	}
+	metrics.AccessListEntries.WithLabelValues(name).Set(float64(out.size()))
+	return out
+}
+
+// readFile returns the entries of path, parsing it again only when it
+// changed; an unreadable file keeps its last entries.
+func (l *Lists) readFile(path string) []string {
+	prev, seen := l.files[path]
+	st, err := os.Stat(path)
+	if err != nil {
+		log.Warn().Err(err).Str("file", path).Msg("access list file unreadable; keeping last entries")
+		return prev.entries
+	}
+	if seen && st.ModTime().Equal(prev.mod) && st.Size() == prev.size {
+		return prev.entries
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		log.Warn().Err(err).Str("file", path).Msg("access list file unreadable; keeping last entries")
+		return prev.entries
+	}
+	f := file{mod: st.ModTime(), size: st.Size()}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			f.entries = append(f.entries, line)
+		}
+	}
+	l.files[path] = f
+	if seen {
+		log.Info().Str("file", path).Int("entries", len(f.entries)).Msg("access list file reloaded")
+	}
+	return f.entries
+}
+
+// readSet returns the members of a Redis set; on errors the last members
+// read are kept.
+func (l *Lists) readSet(key string) []string {
+	if l.rdb == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	members, err := l.rdb.SMembers(ctx, key).Result()
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("access list set unreadable; keeping last entries")
+		return l.sets[key]
+	}
+	l.sets[key] = members
+	return members
+}
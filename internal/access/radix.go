@@ -0,0 +1,115 @@
+package access
+
+import (
+	"math/bits"
+	"net/netip"
+)
+
+// prefixTree is a path-compressed binary radix tree of networks. IPv4 is
+// stored as IPv4-mapped IPv6, so one tree holds both families and a lookup
+// walks at most 128 bits whatever the number of entries.
+type prefixTree struct {
+	root *node
+	size int
+}
+
+type node struct {
+	key   [16]byte // masked to bits
+	bits  int
+	entry bool // a network was inserted here (else only a branch point)
+	child [2]*node
+}
+
+func treeKey(a netip.Addr, n int) ([16]byte, int) {
+	a = a.Unmap().WithZone("")
+	if a.Is4() {
+		return netip.AddrFrom4(a.As4()).As16(), n + 96
+	}
+	return a.As16(), n
+}
+
+func (t *prefixTree) insert(p netip.Prefix) {
+	k, b := treeKey(p.Addr(), p.Bits())
+	mask(&k, b)
+	at := &t.root
+	for {
+		n := *at
+		if n == nil {
+			*at = &node{key: k, bits: b, entry: true}
+			t.size++
+			return
+		}
+		c := commonBits(&n.key, &k, min(n.bits, b))
+		switch {
+		case c == n.bits && c == b:
+			if !n.entry {
+				n.entry = true
+				t.size++
+			}
+			return
+		case c == n.bits: // n covers p: descend
+			at = &n.child[bitAt(&k, c)]
+			continue
+		case c == b: // p covers n
+			nn := &node{key: k, bits: b, entry: true}
+			nn.child[bitAt(&n.key, c)] = n
+			*at = nn
+		default: // they diverge at bit c
+			split := &node{key: k, bits: c}
+			mask(&split.key, c)
+			split.child[bitAt(&n.key, c)] = n
+			split.child[bitAt(&k, c)] = &node{key: k, bits: b, entry: true}
+			*at = split
+		}
+		t.size++
+		return
+	}
+}
+
+// contains reports whether any inserted network holds a.
+func (t *prefixTree) contains(a netip.Addr) bool {
+	k, _ := treeKey(a, 0)
+	for n := t.root; n != nil; {
+		if commonBits(&n.key, &k, n.bits) < n.bits {
+			return false
+		}
+		if n.entry {
+			return true
+		}
+		if n.bits == 128 {
+			return false
+		}
+		n = n.child[bitAt(&k, n.bits)]
+	}
+	return false
+}
+
+func bitAt(k *[16]byte, i int) int {
+	return int(k[i/8]>>(7-i%8)) & 1
+}
+
+func commonBits(a, b *[16]byte, limit int) int {
+	n := 0
+	for i := 0; i < 16 && n < limit; i++ {
+		x := a[i] ^ b[i]
+		if x == 0 {
+			n += 8
+			continue
+		}
+		n += bits.LeadingZeros8(x)
+		break
+	}
+	return min(n, limit)
+}
+
+func mask(k *[16]byte, b int) {
+	for i := range k {
+		switch {
+		case b >= 8*(i+1):
+		case b <= 8*i:
+			k[i] = 0
+		default:
+			k[i] &= ^byte(0xff >> (b - 8*i))
+		}
+	}
+}
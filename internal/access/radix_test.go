@@ -0,0 +1,127 @@
+package access
+
+import (
+	"math/rand"
+	"net/netip"
+	"testing"
+
+	"github.com/skywalker-88/stormgate/internal/identity"
+)
+
+func TestPrefixTree(t *testing.T) {
+	var tr prefixTree
+	for _, p := range []string{
+		"10.0.0.0/8", "10.1.0.0/16", // nested: the wider one keeps covering
+		"192.168.1.0/24", "192.168.2.0/24", // siblings split at bit 22
+		"203.0.113.7/32",
+		"2001:db8::/32", "2001:db8:1::/48",
+		"fe80::1/128",
+	} {
+		tr.insert(netip.MustParsePrefix(p))
+	}
+	tr.insert(netip.MustParsePrefix("10.1.0.0/16")) // duplicate
+	if tr.size != 8 {
+		t.Errorf("size = %d, want 8 (duplicates count once)", tr.size)
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.200.3.4", true},
+		{"10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"192.168.1.77", true},
+		{"192.168.2.1", true},
+		{"192.168.3.1", false},
+		{"192.168.0.255", false},
+		{"203.0.113.7", true},
+		{"203.0.113.8", false},
+		{"::ffff:10.0.0.1", true}, // mapped v4
+		{"2001:db8:ffff::1", true},
+		{"2001:db9::1", false},
+		{"fe80::1", true},
+		{"fe80::1%eth0", true}, // zone ignored
+		{"fe80::2", false},
+		{"::a00:1", false}, // 10.0.0.1's bits, but not v4-mapped
+	}
+	for _, tt := range tests {
+		if got := tr.contains(netip.MustParseAddr(tt.ip)); got != tt.want {
+			t.Errorf("contains(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	var empty prefixTree
+	if empty.contains(netip.MustParseAddr("10.0.0.1")) {
+		t.Error("empty tree contains 10.0.0.1")
+	}
+}
+
+// The tree agrees with a linear scan for random networks and addresses,
+// inserted in any order.
+func TestPrefixTreeRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randAddr := func() netip.Addr {
+		if rng.Intn(2) == 0 {
+			// small v4 space so lookups hit often
+			return netip.AddrFrom4([4]byte{10, byte(rng.Intn(4)), byte(rng.Intn(256)), byte(rng.Intn(256))})
+		}
+		var b [16]byte
+		b[0], b[1], b[2] = 0x20, 0x01, byte(rng.Intn(4))
+		rng.Read(b[3:])
+		return netip.AddrFrom16(b)
+	}
+	for round := 0; round < 50; round++ {
+		var tr prefixTree
+		var nets []netip.Prefix
+		for i := 0; i < 40; i++ {
+			a := randAddr()
+			bits := 8 + rng.Intn(25)
+			if a.Is6() {
+				bits = 16 + rng.Intn(113)
+			}
+			p := netip.PrefixFrom(a, bits).Masked()
+			nets = append(nets, p)
+			tr.insert(p)
+		}
+		for i := 0; i < 500; i++ {
+			a := randAddr()
+			want := false
+			for _, p := range nets {
+				want = want || p.Contains(a)
+			}
+			if got := tr.contains(a); got != want {
+				t.Fatalf("round %d: contains(%s) = %v, want %v", round, a, got, want)
+			}
+		}
+	}
+}
+
+func TestListMatch(t *testing.T) {
+	l := &list{ids: map[string]struct{}{}}
+	for _, e := range []string{"10.0.0.0/8", "198.51.100.9", "partner-key", "bot-*", "user-?", ""} {
+		l.add(e)
+	}
+	if l.size() != 5 {
+		t.Errorf("size = %d, want 5", l.size())
+	}
+	tests := []struct {
+		id, ip string
+		want   bool
+	}{
+		{"anon", "10.9.8.7", true},
+		{"anon", "198.51.100.9", true},
+		{"anon", "198.51.100.10", false},
+		{"partner-key", "203.0.113.1", true},
+		{"partner-key-2", "203.0.113.1", false},
+		{"bot-crawler", "203.0.113.1", true},
+		{"user-7", "203.0.113.1", true},
+		{"user-77", "203.0.113.1", false},
+		{"x", "not-an-ip", false},
+	}
+	for _, tt := range tests {
+		if got := l.match(identity.Identity{ID: tt.id, IP: tt.ip}); got != tt.want {
+			t.Errorf("match(%s, %s) = %v, want %v", tt.id, tt.ip, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package adaptive
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/skywalker-88/stormgate/internal/rl"
+	"github.com/skywalker-88/stormgate/pkg/config"
+	"github.com/skywalker-88/stormgate/pkg/metrics"
+)
+
+// ringSize bounds the samples kept per route; under heavy traffic the
+// window is effectively the last ringSize responses.
+const ringSize = 4096
+
+type sample struct {
+	atMs   int64
+	tookMs float64
+	failed bool // 5xx
+}
+
+type routeState struct {
+	ring   []sample
+	next   int
+	lastMs int64   // newest sample
+	factor float64 // share of the route limit granted (1 = untouched)
+}
+
+// Controller watches the latency and 5xx rate of proxied requests per route
+// and, while the backend is unhealthy, publishes a route-wide override
+// (client rl.AllClients) whose factor scales every client's own route
+// limit (so plans keep their relative sizes).
+// The factor follows AIMD: multiplied by adaptive.decrease on a bad
+// interval, raised by adaptive.increase on a healthy one, cleared at 1.
+//
+// Each replica judges the traffic it proxied itself; with several replicas
+// the last writer's override wins, which is fine as they all see the same
+// backend.
+type Controller struct {
+	mit  rl.Mitigator
+	cfg  *config.Holder
+	mu   sync.Mutex
+	rts  map[string]*routeState
+	stop chan struct{}
+}
+
+func NewController(mit rl.Mitigator, cfg *config.Holder) *Controller {
+	c := &Controller{mit: mit, cfg: cfg, rts: map[string]*routeState{}, stop: make(chan struct{})}
+	go c.loop()
+	return c
+}
+
+// Close stops the evaluation loop.
+func (c *Controller) Close() { close(c.stop) }
+
+// Observe records one proxied response of route.
+func (c *Controller) Observe(route string, took time.Duration, code int) {
+	if route == "" || !c.cfg.Get().Adaptive.Enabled {
+		return
+	}
+	now := time.Now().UnixMilli()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.rts[route]
+	if st == nil {
+		st = &routeState{factor: 1}
+		c.rts[route] = st
+	}
+	s := sample{atMs: now, tookMs: float64(took) / float64(time.Millisecond), failed: code >= 500}
+	if len(st.ring) < ringSize {
+		st.ring = append(st.ring, s)
+	} else {
+		st.ring[st.next] = s
+		st.next = (st.next + 1) % ringSize
+	}
+	st.lastMs = now
+}
+
+func (c *Controller) loop() {
+	for {
+		a := withDefaults(c.cfg.Get().Adaptive)
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(time.Duration(a.IntervalSeconds) * time.Second):
+			c.tick()
+		}
+	}
+}
+
+func withDefaults(a config.Adaptive) config.Adaptive {
+	if a.IntervalSeconds <= 0 {
+		a.IntervalSeconds = 1
+	}
+	if a.WindowSeconds <= 0 {
+		a.WindowSeconds = 10
+	}
+	if a.MinSamples <= 0 {
+		a.MinSamples = 20
+	}
+	if a.Decrease <= 0 {
+		a.Decrease = 0.7
+	}
+	if a.Increase <= 0 {
+		a.Increase = 0.05
+	}
+	if a.MinFactor <= 0 {
+		a.MinFactor = 0.1
+	}
+	return a
+}
+
+// step is one route's outcome of an evaluation.
+type step struct {
+	route     string
+	factor    float64
+	tightened bool
+	cleared   bool
+}
+
+func (c *Controller) tick() {
+	pol := c.cfg.Get()
+	a := withDefaults(pol.Adaptive)
+	now := time.Now().UnixMilli()
+	since := now - int64(a.WindowSeconds)*1000
+
+	var steps []step
+	c.mu.Lock()
+	for route, st := range c.rts {
+		if !pol.Adaptive.Enabled {
+			// Switched off on reload: lift whatever is still in place.
+			if st.factor < 1 {
+				steps = append(steps, step{route: route, factor: 1, cleared: true})
+			}
+			c.forget(route)
+			continue
+		}
+
+		var took []float64
+		failed := 0
+		for _, s := range st.ring {
+			if s.atMs > since {
+				took = append(took, s.tookMs)
+				if s.failed {
+					failed++
+				}
+			}
+		}
+		if len(took) == 0 && st.factor >= 1 && st.lastMs <= since {
+			c.forget(route) // idle and healthy
+			continue
+		}
+
+		unhealthy := false
+		if len(took) > 0 {
+			slices.Sort(took)
+			p95 := took[(len(took)*95-1)/100]
+			ratio := float64(failed) / float64(len(took))
+			metrics.UpstreamLatencyP95.WithLabelValues(route).Set(p95 / 1000)
+			metrics.UpstreamErrorRatio.WithLabelValues(route).Set(ratio)
+			if len(took) >= a.MinSamples {
+				unhealthy = (a.LatencyP95Ms > 0 && p95 > float64(a.LatencyP95Ms)) ||
+					(a.ErrorRate > 0 && ratio > a.ErrorRate)
+			}
+		}
+
+		prev := st.factor
+		switch {
+		case unhealthy:
+			st.factor = max(a.MinFactor, st.factor*a.Decrease)
+		case st.factor < 1:
+			st.factor = min(1, st.factor+a.Increase)
+		default:
+			continue
+		}
+		metrics.AdaptiveFactor.WithLabelValues(route).Set(st.factor)
+		steps = append(steps, step{
+			route:     route,
+			factor:    st.factor,
+			tightened: st.factor < prev,
+			cleared:   st.factor >= 1 && prev < 1,
+		})
+	}
+	c.mu.Unlock()
+
+	// Redis round trips happen outside the lock so Observe never waits on them.
+	ttl := 3 * time.Duration(a.IntervalSeconds) * time.Second
+	for _, s := range steps {
+		c.apply(s, ttl)
+	}
+}
+
+// forget drops the state of route (c.mu held).
+func (c *Controller) forget(route string) {
+	delete(c.rts, route)
+	metrics.AdaptiveFactor.DeleteLabelValues(route)
+	metrics.UpstreamLatencyP95.DeleteLabelValues(route)
+	metrics.UpstreamErrorRatio.DeleteLabelValues(route)
+}
+
+func (c *Controller) apply(s step, ttl time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if s.cleared {
+		if err := c.mit.ClearOverride(ctx, s.route, rl.AllClients); err != nil {
+			log.Error().Err(err).Str("route", s.route).Msg("adaptive_clear_failed")
+			return
+		}
+		log.Info().Str("route", s.route).Msg("adaptive_recovered")
+		return
+	}
+
+	// The rate limiter scales each client's limit by the factor and applies
+	// the mitigation.min_* rails.
+	ov := rl.Override{Factor: s.factor}
+	// Refreshed every interval while tightened, so a stopped replica's
+	// override expires on its own.
+	if err := c.mit.SetOverride(ctx, s.route, rl.AllClients, ov, ttl); err != nil {
+		log.Error().Err(err).Str("route", s.route).Msg("adaptive_override_failed")
+		return
+	}
+	if s.tightened {
+		metrics.OverridesTotal.WithLabelValues(s.route, "adaptive").Inc()
+		log.Warn().
+			Str("route", s.route).
+			Float64("factor", s.factor).
+			Msg("adaptive_tightened")
+	}
+}
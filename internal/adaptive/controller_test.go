@@ -0,0 +1,103 @@
+package adaptive
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/skywalker-88/stormgate/internal/rl"
+	"github.com/skywalker-88/stormgate/pkg/config"
+)
+
+func newTestController(t *testing.T, a config.Adaptive) (*Controller, *config.Holder, rl.Mitigator) {
+	t.Helper()
+	cfg := config.NewHolder(&config.Config{Adaptive: a})
+	mit := rl.NewMemoryMitigator()
+	// No loop: the test drives tick itself.
+	return &Controller{mit: mit, cfg: cfg, rts: map[string]*routeState{}, stop: make(chan struct{})}, cfg, mit
+}
+
+// factorOf returns the route-wide factor in place (0 when there is none).
+func factorOf(t *testing.T, mit rl.Mitigator, route string) float64 {
+	t.Helper()
+	ov, err := mit.GetOverride(context.Background(), route, rl.AllClients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ov == nil {
+		return 0
+	}
+	return ov.Factor
+}
+
+func TestControllerAIMD(t *testing.T) {
+	c, _, mit := newTestController(t, config.Adaptive{
+		Enabled: true, MinSamples: 4, ErrorRate: 0.5,
+		Decrease: 0.5, Increase: 0.25, MinFactor: 0.2,
+	})
+	fail := func(n int) {
+		for range n {
+			c.Observe("/api", time.Millisecond, http.StatusBadGateway)
+		}
+	}
+
+	fail(3)
+	c.tick()
+	if f := factorOf(t, mit, "/api"); f != 0 {
+		t.Fatalf("tightened on 3 samples (min 4): factor %v", f)
+	}
+
+	// Multiplicative decrease down to min_factor.
+	fail(1)
+	for _, want := range []float64{0.5, 0.25, 0.2, 0.2} {
+		c.tick()
+		if f := factorOf(t, mit, "/api"); f != want {
+			t.Fatalf("bad interval: factor %v, want %v", f, want)
+		}
+	}
+
+	// Additive increase once healthy, cleared at 1.
+	c.rts["/api"].ring = nil
+	for _, want := range []float64{0.45, 0.7, 0.95} {
+		c.tick()
+		if f := factorOf(t, mit, "/api"); f < want-1e-9 || f > want+1e-9 {
+			t.Fatalf("healthy interval: factor %v, want %v", f, want)
+		}
+	}
+	c.tick()
+	if f := factorOf(t, mit, "/api"); f != 0 {
+		t.Fatalf("override kept at factor %v after recovering", f)
+	}
+}
+
+func TestControllerLatency(t *testing.T) {
+	c, _, mit := newTestController(t, config.Adaptive{Enabled: true, MinSamples: 4, LatencyP95Ms: 100})
+	for range 3 {
+		c.Observe("/api", 10*time.Millisecond, http.StatusOK)
+	}
+	c.Observe("/api", 200*time.Millisecond, http.StatusOK)
+	c.tick()
+	if f := factorOf(t, mit, "/api"); f != 0.7 { // default decrease
+		t.Fatalf("slow p95: factor %v, want 0.7", f)
+	}
+}
+
+// Switching adaptive off on reload lifts the override and drops the state.
+func TestControllerDisabledClears(t *testing.T) {
+	c, cfg, mit := newTestController(t, config.Adaptive{Enabled: true, MinSamples: 1, ErrorRate: 0.1})
+	c.Observe("/api", time.Millisecond, http.StatusInternalServerError)
+	c.tick()
+	if factorOf(t, mit, "/api") == 0 {
+		t.Fatal("not tightened")
+	}
+
+	cfg.Swap(&config.Config{})
+	c.tick()
+	if f := factorOf(t, mit, "/api"); f != 0 {
+		t.Fatalf("override kept at factor %v after disabling", f)
+	}
+	if len(c.rts) != 0 {
+		t.Fatalf("route state kept: %v", c.rts)
+	}
+}
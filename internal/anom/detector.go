@@ -2,7 +2,6 @@ package anom
 
 import (
 	"context"
-	"net"
 	"net/http"
 	"strings"
 	"sync"
@@ -11,6 +10,8 @@ import (
 
 	"github.com/rs/zerolog/log"
 
+	"github.com/skywalker-88/stormgate/internal/access"
+	"github.com/skywalker-88/stormgate/internal/identity"
 	"github.com/skywalker-88/stormgate/internal/rl"
 	"github.com/skywalker-88/stormgate/pkg/config"
 	"github.com/skywalker-88/stormgate/pkg/metrics"
@@ -32,8 +33,9 @@ type Config struct {
 
 // Deps lets the detector apply mitigation when an anomaly fires.
 type Deps struct {
-	Mit rl.Mitigator
-	Cfg *config.Config
+	Mit   rl.Mitigator
+	Cfg   *config.Holder // live policy (routes, mitigation rails)
+	Lists *access.Lists  // allowed clients are never mitigated (optional)
 }
 
 type bucketState struct {
@@ -53,7 +55,7 @@ type perKey struct {
 
 // Detector tracks per {route,client} windows and detects spikes.
 type Detector struct {
-	cfg      Config
+	cfg      atomic.Pointer[Config]
 	deps     Deps
 	keys     sync.Map
 	perRoute sync.Map
@@ -66,6 +68,15 @@ type routeState struct {
 }
 
 func NewDetector(cfg Config, deps Deps) *Detector {
+	d := &Detector{deps: deps, stop: make(chan struct{})}
+	d.SetConfig(cfg)
+	go d.janitor()
+	return d
+}
+
+// SetConfig swaps detector settings at runtime. Keys whose bucket count no
+// longer matches start a fresh window on their next request.
+func (d *Detector) SetConfig(cfg Config) {
 	if cfg.WindowSeconds <= 0 {
 		cfg.WindowSeconds = 10
 	}
@@ -87,12 +98,7 @@ func NewDetector(cfg Config, deps Deps) *Detector {
 	if cfg.KeepSuspiciousSeconds < 0 {
 		cfg.KeepSuspiciousSeconds = 0
 	}
-
-	d := &Detector{cfg: cfg, deps: deps, stop: make(chan struct{})}
-	if cfg.TTLSeconds > 0 || cfg.KeepSuspiciousSeconds > 0 {
-		go d.janitor()
-	}
-	return d
+	d.cfg.Store(&cfg)
 }
 
 func (d *Detector) Close() {
@@ -103,28 +109,32 @@ func (d *Detector) Close() {
 
 // Middleware observes each request; logs + increments metric on anomalies (no blocking).
 func (d *Detector) Middleware(next http.Handler) http.Handler {
-	if !d.cfg.Enabled {
-		return next
-	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.cfg.Load().Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		pol := d.policy()
 		raw := r.URL.Path
 		route := raw
-		if d.deps.Cfg != nil {
-			route = rl.NormalizeRoute(d.deps.Cfg, raw)
+		if pol != nil {
+			route = rl.NormalizeRoute(pol, r.Method, raw)
 		}
-		if route == "/metrics" || route == "/health" {
+		if raw == "/metrics" || raw == "/health" || strings.HasPrefix(raw, "/admin/") {
 			next.ServeHTTP(w, r)
 			return
 		}
-		client := d.clientIDFrom(r)
+		id := identity.Of(pol, r)
+		client := id.ID
+		allowlisted := d.deps.Lists.Allowed(id)
 
-		if d.observe(route, client) {
+		if d.observe(route, client, allowlisted) {
 			metrics.AnomaliesTotal.WithLabelValues(route, client).Inc()
 			log.Warn().Str("route", route).Str("client", client).Msg("anomaly_detected")
 
 			// Apply mitigation if wired and not allowlisted
-			if d.deps.Mit != nil && d.deps.Cfg != nil && !rl.IsAllowlisted(d.deps.Cfg, client) {
-				d.onAnomaly(route, client)
+			if d.deps.Mit != nil && pol != nil && !allowlisted {
+				d.onAnomaly(pol, route, client, id.Plan)
 			}
 		}
 
@@ -132,8 +142,17 @@ func (d *Detector) Middleware(next http.Handler) http.Handler {
 	})
 }
 
+// policy returns the current config snapshot, or nil when none is wired.
+func (d *Detector) policy() *config.Config {
+	if d.deps.Cfg == nil {
+		return nil
+	}
+	return d.deps.Cfg.Get()
+}
+
 // observe updates the window for {route,client} and returns true if anomalous.
-func (d *Detector) observe(route, client string) bool {
+func (d *Detector) observe(route, client string, allowlisted bool) bool {
+	cfg := d.cfg.Load()
 	key := route + "|" + client
 	pkIface, _ := d.keys.LoadOrStore(key, &perKey{})
 	pk := pkIface.(*perKey)
@@ -144,9 +163,9 @@ func (d *Detector) observe(route, client string) bool {
 	pk.Lock()
 	defer pk.Unlock()
 
-	if pk.state == nil {
+	if pk.state == nil || len(pk.state.counts) != cfg.Buckets {
 		pk.state = &bucketState{
-			counts:   make([]int64, d.cfg.Buckets),
+			counts:   make([]int64, cfg.Buckets),
 			idx:      0,
 			tsSec:    nowSec,
 			total:    0,
@@ -181,14 +200,14 @@ func (d *Detector) observe(route, client string) bool {
 
 	current := float64(pk.state.total)
 	prev := pk.state.baseline
-	threshold := d.cfg.ThresholdMultiplier * maxFloat(1.0, prev)
+	threshold := cfg.ThresholdMultiplier * maxFloat(1.0, prev)
 
 	isAnom := current > threshold
 
 	if isAnom {
 		atomic.StoreInt64(&pk.lastAnomaly, nowSec)
-		if d.cfg.KeepSuspiciousSeconds > 0 {
-			if !(d.deps.Cfg != nil && rl.IsAllowlisted(d.deps.Cfg, client)) {
+		if cfg.KeepSuspiciousSeconds > 0 {
+			if !allowlisted {
 				rsIface, _ := d.perRoute.LoadOrStore(route, &routeState{clients: make(map[string]int64)})
 				rs := rsIface.(*routeState)
 				rs.Lock()
@@ -199,7 +218,7 @@ func (d *Detector) observe(route, client string) bool {
 		}
 	}
 
-	alpha := d.cfg.EWMAAlpha
+	alpha := cfg.EWMAAlpha
 	if prev == 0 {
 		pk.state.baseline = alpha * current
 	} else {
@@ -210,17 +229,17 @@ func (d *Detector) observe(route, client string) bool {
 }
 
 // onAnomaly applies a scoped override with TTL and escalates on repeat offenders.
-func (d *Detector) onAnomaly(route, client string) {
+func (d *Detector) onAnomaly(pol *config.Config, route, client, plan string) {
 	ctx := context.Background()
 
 	// 1) Determine ramp factor/step from existing override (if any)
 	step := 0
 	factor := 0.5
-	if d.deps.Cfg.Mitigation.StepRamp.Enabled {
+	if pol.Mitigation.StepRamp.Enabled {
 		if ov, _ := d.deps.Mit.GetOverride(ctx, route, client); ov != nil {
 			step = ov.Step + 1
 		}
-		steps := d.deps.Cfg.Mitigation.StepRamp.Steps
+		steps := pol.Mitigation.StepRamp.Steps
 		if len(steps) > 0 {
 			if step >= len(steps) {
 				step = len(steps) - 1
@@ -229,18 +248,19 @@ func (d *Detector) onAnomaly(route, client string) {
 		}
 	}
 
-	// 2) Base policy for this route
-	base := rl.EffectiveLimit(d.deps.Cfg, route)
+	// 2) Base policy for this route (on the client's plan)
+	base := rl.EffectiveLimit(pol, route, plan)
 
 	// 3) Compute effective clamped values with rails
-	minRPS := d.deps.Cfg.Mitigation.MinRPS
-	minBurst := int64(d.deps.Cfg.Mitigation.MinBurst)
+	minRPS := pol.Mitigation.MinRPS
+	minBurst := int64(pol.Mitigation.MinBurst)
 
-	newRPS := clampFloat(minRPS, factor*base.RPS, base.RPS)
-	newBurst := clampInt(minBurst, int64(float64(base.Burst)*factor), base.Burst)
+	rate := rl.RateOf(base) // window algorithms may only configure burst/window
+	newRPS := clampFloat(minRPS, factor*rate.RPS, rate.RPS)
+	newBurst := clampInt(minBurst, int64(float64(rate.Burst)*factor), rate.Burst)
 
 	// 4) Set override with TTL (shared across replicas)
-	ttl := time.Duration(d.deps.Cfg.Mitigation.OverrideTTLSeconds) * time.Second
+	ttl := time.Duration(pol.Mitigation.OverrideTTLSeconds) * time.Second
 	if err := d.deps.Mit.SetOverride(ctx, route, client, rl.Override{
 		RPS:   int(newRPS),
 		Burst: int(newBurst),
@@ -253,10 +273,10 @@ func (d *Detector) onAnomaly(route, client string) {
 	}
 
 	// 5) Escalate if repeat offender within window
-	window := time.Duration(d.deps.Cfg.Mitigation.RepeatOffender.WindowSeconds) * time.Second
+	window := time.Duration(pol.Mitigation.RepeatOffender.WindowSeconds) * time.Second
 	streak, _ := d.deps.Mit.IncrStreak(ctx, route, client, window)
-	if streak >= int64(d.deps.Cfg.Mitigation.RepeatOffender.Threshold) {
-		bttl := time.Duration(d.deps.Cfg.Mitigation.BlockTTLSeconds) * time.Second
+	if streak >= int64(pol.Mitigation.RepeatOffender.Threshold) {
+		bttl := time.Duration(pol.Mitigation.BlockTTLSeconds) * time.Second
 		if err := d.deps.Mit.SetBlock(ctx, route, client, rl.Block{Reason: "repeat_offender"}, bttl); err != nil {
 			log.Error().Err(err).Str("route", route).Str("client", client).Msg("block_failed")
 		} else {
@@ -277,7 +297,8 @@ func (d *Detector) onAnomaly(route, client string) {
 }
 
 func (d *Detector) janitor() {
-	ticker := time.NewTicker(time.Duration(d.cfg.EvictEverySeconds) * time.Second)
+	every := d.cfg.Load().EvictEverySeconds
+	ticker := time.NewTicker(time.Duration(every) * time.Second)
 	defer ticker.Stop()
 
 	for {
@@ -285,9 +306,18 @@ func (d *Detector) janitor() {
 		case <-d.stop:
 			return
 		case <-ticker.C:
+			cfg := d.cfg.Load()
+			if cfg.EvictEverySeconds != every {
+				every = cfg.EvictEverySeconds
+				ticker.Reset(time.Duration(every) * time.Second)
+			}
+			if cfg.TTLSeconds == 0 && cfg.KeepSuspiciousSeconds == 0 {
+				continue // eviction disabled
+			}
+
 			now := time.Now().Unix()
-			ttl := int64(d.cfg.TTLSeconds)
-			keepSusp := int64(d.cfg.KeepSuspiciousSeconds)
+			ttl := int64(cfg.TTLSeconds)
+			keepSusp := int64(cfg.KeepSuspiciousSeconds)
 
 			survivors := 0
 			d.keys.Range(func(k, v any) bool {
@@ -312,8 +342,8 @@ func (d *Detector) janitor() {
 
 			metrics.ActiveKeys.Set(float64(survivors))
 
-			if d.cfg.KeepSuspiciousSeconds > 0 {
-				cutoff := now - int64(d.cfg.KeepSuspiciousSeconds)
+			if cfg.KeepSuspiciousSeconds > 0 {
+				cutoff := now - int64(cfg.KeepSuspiciousSeconds)
 				d.perRoute.Range(func(rk, rv any) bool {
 					route := rk.(string)
 					rs := rv.(*routeState)
@@ -332,31 +362,6 @@ func (d *Detector) janitor() {
 	}
 }
 
-func (d *Detector) clientIDFrom(r *http.Request) string {
-	// Prefer configured identity source (e.g., "header:X-API-Key")
-	if d.deps.Cfg != nil {
-		src := d.deps.Cfg.Identity.Source
-		if strings.HasPrefix(strings.ToLower(src), "header:") {
-			h := strings.TrimSpace(strings.SplitN(src, ":", 2)[1])
-			if v := r.Header.Get(h); v != "" {
-				return v
-			}
-		}
-	}
-	// Fallback to IP (first XFF, else RemoteAddr)
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		if len(parts) > 0 {
-			return strings.TrimSpace(parts[0])
-		}
-	}
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err == nil && host != "" {
-		return host
-	}
-	return r.RemoteAddr
-}
-
 func maxFloat(a, b float64) float64 {
 	if a > b {
 		return a
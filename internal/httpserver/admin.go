@@ -0,0 +1,273 @@
+package httpserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+
+	"github.com/skywalker-88/stormgate/internal/rl"
+	"github.com/skywalker-88/stormgate/pkg/config"
+	"github.com/skywalker-88/stormgate/pkg/metrics"
+)
+
+// Admin API (mounted at /admin whenever a Mitigator is wired; every request
+// is rejected while admin.token is unset, so a reload can enable it):
+//
+//	GET    /admin/blocks[?route=]            list active blocks
+//	GET    /admin/blocks?route=&client=      inspect one block (404 if none)
+//	POST   /admin/blocks                     {"route","client","reason","ttl_seconds"}
+//	DELETE /admin/blocks?route=&client=      clear a block
+//
+// /admin/overrides mirrors the same shape with {"route","client","rps","burst","ttl_seconds"}.
+type adminAPI struct {
+	mit rl.Mitigator
+	cfg *config.Holder
+}
+
+type blockRequest struct {
+	Route      string `json:"route"`
+	Client     string `json:"client"`
+	Reason     string `json:"reason"`
+	TTLSeconds int    `json:"ttl_seconds"`
+}
+
+type overrideRequest struct {
+	Route      string `json:"route"`
+	Client     string `json:"client"`
+	RPS        int    `json:"rps"`
+	Burst      int    `json:"burst"`
+	TTLSeconds int    `json:"ttl_seconds"`
+}
+
+func newAdminRouter(mit rl.Mitigator, cfg *config.Holder) http.Handler {
+	a := &adminAPI{mit: mit, cfg: cfg}
+	r := chi.NewRouter()
+	r.Use(requireBearer(func() string { return cfg.Get().Admin.Token }))
+
+	r.Get("/blocks", a.getBlocks)
+	r.Post("/blocks", a.setBlock)
+	r.Delete("/blocks", a.clearBlock)
+
+	r.Get("/overrides", a.getOverrides)
+	r.Post("/overrides", a.setOverride)
+	r.Delete("/overrides", a.clearOverride)
+	return r
+}
+
+// requireBearer rejects requests without "Authorization: Bearer <token>".
+// The token is read per request so a reload can rotate it; an empty token denies everything.
+func requireBearer(token func() string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			want := token()
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || want == "" || subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="stormgate-admin"`)
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ---------- blocks ----------
+
+func (a *adminAPI) getBlocks(w http.ResponseWriter, r *http.Request) {
+	route, client := r.URL.Query().Get("route"), r.URL.Query().Get("client")
+	if route != "" && client != "" {
+		bl, err := a.mit.GetBlock(r.Context(), route, client)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if bl == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not_found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, rl.BlockEntry{Route: route, Client: client, Block: *bl})
+		return
+	}
+
+	all, err := a.mit.ListBlocks(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	out := all[:0]
+	for _, e := range all {
+		if (route == "" || e.Route == route) && (client == "" || e.Client == client) {
+			out = append(out, e)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"blocks": out})
+}
+
+func (a *adminAPI) setBlock(w http.ResponseWriter, r *http.Request) {
+	var req blockRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Route == "" || req.Client == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "route and client are required"})
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "admin"
+	}
+	ttl, ok := adminTTL(w, req.TTLSeconds, a.cfg.Get().Mitigation.BlockTTLSeconds)
+	if !ok {
+		return
+	}
+
+	bl := rl.Block{Reason: req.Reason}
+	if err := a.mit.SetBlock(r.Context(), req.Route, req.Client, bl, ttl); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	metrics.BlocksTotal.WithLabelValues(req.Route, "admin").Inc()
+	log.Warn().Str("route", req.Route).Str("client", req.Client).Str("reason", req.Reason).
+		Dur("ttl", ttl).Msg("admin_block_set")
+
+	bl.Exp = time.Now().Add(ttl).Unix()
+	writeJSON(w, http.StatusCreated, rl.BlockEntry{Route: req.Route, Client: req.Client, Block: bl})
+}
+
+func (a *adminAPI) clearBlock(w http.ResponseWriter, r *http.Request) {
+	route, client, ok := routeClientQuery(w, r)
+	if !ok {
+		return
+	}
+	if err := a.mit.ClearBlock(r.Context(), route, client); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	log.Warn().Str("route", route).Str("client", client).Msg("admin_block_cleared")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ---------- overrides ----------
+
+func (a *adminAPI) getOverrides(w http.ResponseWriter, r *http.Request) {
+	route, client := r.URL.Query().Get("route"), r.URL.Query().Get("client")
+	if route != "" && client != "" {
+		ov, err := a.mit.GetOverride(r.Context(), route, client)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if ov == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not_found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, rl.OverrideEntry{Route: route, Client: client, Override: *ov})
+		return
+	}
+
+	all, err := a.mit.ListOverrides(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	out := all[:0]
+	for _, e := range all {
+		if (route == "" || e.Route == route) && (client == "" || e.Client == client) {
+			out = append(out, e)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"overrides": out})
+}
+
+func (a *adminAPI) setOverride(w http.ResponseWriter, r *http.Request) {
+	var req overrideRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Route == "" || req.Client == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "route and client are required"})
+		return
+	}
+	if req.RPS <= 0 && req.Burst <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "rps or burst must be > 0"})
+		return
+	}
+	ttl, ok := adminTTL(w, req.TTLSeconds, a.cfg.Get().Mitigation.OverrideTTLSeconds)
+	if !ok {
+		return
+	}
+
+	ov := rl.Override{RPS: req.RPS, Burst: req.Burst}
+	if err := a.mit.SetOverride(r.Context(), req.Route, req.Client, ov, ttl); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	metrics.OverridesTotal.WithLabelValues(req.Route, "admin").Inc()
+	log.Warn().Str("route", req.Route).Str("client", req.Client).Int("rps", req.RPS).Int("burst", req.Burst).
+		Dur("ttl", ttl).Msg("admin_override_set")
+
+	ov.Exp = time.Now().Add(ttl).Unix()
+	writeJSON(w, http.StatusCreated, rl.OverrideEntry{Route: req.Route, Client: req.Client, Override: ov})
+}
+
+func (a *adminAPI) clearOverride(w http.ResponseWriter, r *http.Request) {
+	route, client, ok := routeClientQuery(w, r)
+	if !ok {
+		return
+	}
+	if err := a.mit.ClearOverride(r.Context(), route, client); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	log.Warn().Str("route", route).Str("client", client).Msg("admin_override_cleared")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ---------- tiny helpers ----------
+
+func routeClientQuery(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	route, client := r.URL.Query().Get("route"), r.URL.Query().Get("client")
+	if route == "" || client == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "route and client query parameters are required"})
+		return "", "", false
+	}
+	return route, client, true
+}
+
+// adminTTL falls back to the configured policy TTL when the request omits one.
+func adminTTL(w http.ResponseWriter, reqSeconds, defSeconds int) (time.Duration, bool) {
+	secs := reqSeconds
+	if secs == 0 {
+		secs = defSeconds
+	}
+	if secs <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "ttl_seconds must be > 0"})
+		return 0, false
+	}
+	return time.Duration(secs) * time.Second, true
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_json", "detail": err.Error()})
+		return false
+	}
+	return true
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	log.Error().Err(err).Msg("admin store error")
+	writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "store_unavailable"})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
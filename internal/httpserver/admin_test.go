@@ -0,0 +1,47 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/skywalker-88/stormgate/internal/rl"
+	"github.com/skywalker-88/stormgate/pkg/config"
+)
+
+func TestAdminTokenFromReload(t *testing.T) {
+	cfg := config.NewHolder(&config.Config{})
+	h := newAdminRouter(rl.NewMemoryMitigator(), cfg)
+
+	get := func(auth string) int {
+		req := httptest.NewRequest(http.MethodGet, "/blocks", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := get("Bearer "); code != http.StatusUnauthorized {
+		t.Fatalf("empty token: status %d, want 401", code)
+	}
+
+	c := &config.Config{}
+	c.Admin.Token = "s3cret"
+	cfg.Swap(c)
+	tests := []struct {
+		auth string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Basic s3cret", http.StatusUnauthorized},
+		{"Bearer s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code := get(tt.auth); code != tt.want {
+			t.Errorf("Authorization %q: status %d, want %d", tt.auth, code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// PROXY protocol (HAProxy) v1 text and v2 binary headers.
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	proxyV1MaxLen     = 107
+	proxyHeaderWithin = 5 * time.Second
+)
+
+var errProxyHeader = errors.New("proxy protocol: malformed header")
+
+// ProxyProtoListener wraps l for server.proxy_protocol: every connection must
+// start with a PROXY v1 or v2 header, and its RemoteAddr becomes the source
+// address the header names. Connections from peers that trusted rejects
+// (checked per connection, so reloads apply) or with a malformed header are
+// closed. The header is read lazily so a slow peer never stalls Accept.
+func ProxyProtoListener(l net.Listener, trusted func(addr string) bool) net.Listener {
+	return &proxyListener{Listener: l, trusted: trusted}
+}
+
+type proxyListener struct {
+	net.Listener
+	trusted func(addr string) bool
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: c, trusted: l.trusted, r: bufio.NewReader(c)}, nil
+}
+
+type proxyConn struct {
+	net.Conn
+	trusted func(addr string) bool
+	r       *bufio.Reader
+	once    sync.Once
+	remote  net.Addr
+	err     error
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) readHeader() {
+	peer := c.Conn.RemoteAddr().String()
+	if !c.trusted(peer) {
+		c.fail(errors.New("proxy protocol: peer is not a trusted proxy"), peer)
+		return
+	}
+	_ = c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderWithin))
+	defer func() { _ = c.Conn.SetReadDeadline(time.Time{}) }()
+
+	sig, err := c.r.Peek(len(proxyV2Sig))
+	switch {
+	case err != nil:
+		c.fail(err, peer)
+	case bytes.Equal(sig, proxyV2Sig):
+		c.remote, c.err = readProxyV2(c.r)
+	case bytes.HasPrefix(sig, []byte("PROXY ")):
+		c.remote, c.err = readProxyV1(c.r)
+	default:
+		c.err = errProxyHeader
+	}
+	if c.err != nil {
+		c.fail(c.err, peer)
+	}
+}
+
+func (c *proxyConn) fail(err error, peer string) {
+	log.Debug().Err(err).Str("peer", peer).Msg("proxy protocol connection rejected")
+	c.err = err
+	_ = c.Conn.Close()
+}
+
+// readProxyV1 parses "PROXY TCP4|TCP6|UNKNOWN src dst sport dport\r\n".
+// A nil address (UNKNOWN) keeps the peer address.
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errProxyHeader
+	}
+	f := strings.Fields(s)
+	if len(f) >= 2 && f[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(f) != 6 || (f[1] != "TCP4" && f[1] != "TCP6") {
+		return nil, errProxyHeader
+	}
+	ip := net.ParseIP(f[2])
+	port, err := strconv.ParseUint(f[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 parses the binary header; LOCAL commands and non-IP families
+// keep the peer address.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, errProxyHeader
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	if hdr[12]&0x0f == 0 { // LOCAL: health check from the proxy itself
+		return nil, nil
+	}
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		if len(body) < 12 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 2: // AF_INET6
+		if len(body) < 36 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,146 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// proxyV2 builds a v2 header: cmd 0 (LOCAL) or 1 (PROXY), fam 1 (inet) or
+// 2 (inet6), followed by body.
+func proxyV2(cmd, fam byte, body []byte) []byte {
+	b := append([]byte{}, proxyV2Sig...)
+	b = append(b, 0x20|cmd, fam<<4|1)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(body)))
+	return append(b, body...)
+}
+
+func inetBody(src, dst net.IP, sport, dport uint16) []byte {
+	b := append(append([]byte{}, src...), dst...)
+	b = binary.BigEndian.AppendUint16(b, sport)
+	return binary.BigEndian.AppendUint16(b, dport)
+}
+
+func TestReadProxyV1(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string // "" = keep the peer address
+		wantErr bool
+	}{
+		{in: "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n", want: "203.0.113.7:51234"},
+		{in: "PROXY TCP6 2001:db8::1 2001:db8::2 51234 443\r\n", want: "[2001:db8::1]:51234"},
+		{in: "PROXY UNKNOWN\r\n"},
+		{in: "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"},
+		{in: "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\n", wantErr: true},   // LF only
+		{in: "PROXY TCP4 203.0.113.7 10.0.0.1 51234\r\n", wantErr: true},     // missing field
+		{in: "PROXY UDP4 203.0.113.7 10.0.0.1 51234 443\r\n", wantErr: true}, // not TCP
+		{in: "PROXY TCP4 not-an-ip 10.0.0.1 51234 443\r\n", wantErr: true},
+		{in: "PROXY TCP4 203.0.113.7 10.0.0.1 70000 443\r\n", wantErr: true},   // port out of range
+		{in: "PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n", wantErr: true}, // longer than 107 bytes
+		{in: "PROXY TCP4 203.0.113.7", wantErr: true},                          // EOF
+	}
+	for _, tt := range tests {
+		addr, err := readProxyV1(bufio.NewReader(strings.NewReader(tt.in)))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: err = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got := addrString(addr); !tt.wantErr && got != tt.want {
+			t.Errorf("%q: addr = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReadProxyV2(t *testing.T) {
+	v4 := inetBody(net.IPv4(203, 0, 113, 7).To4(), net.IPv4(10, 0, 0, 1).To4(), 51234, 443)
+	v6 := inetBody(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 51234, 443)
+	tests := []struct {
+		name    string
+		in      []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "inet", in: proxyV2(1, 1, v4), want: "203.0.113.7:51234"},
+		{name: "inet with TLVs", in: proxyV2(1, 1, append(v4, 0x04, 0x00, 0x01, 0xff)), want: "203.0.113.7:51234"},
+		{name: "inet6", in: proxyV2(1, 2, v6), want: "[2001:db8::1]:51234"},
+		{name: "local", in: proxyV2(0, 1, v4)},
+		{name: "unix family", in: proxyV2(1, 3, make([]byte, 216))},
+		{name: "short inet body", in: proxyV2(1, 1, v4[:8]), wantErr: true},
+		{name: "short inet6 body", in: proxyV2(1, 2, v6[:20]), wantErr: true},
+		{name: "wrong version", in: func() []byte { b := proxyV2(1, 1, v4); b[12] = 0x11; return b }(), wantErr: true},
+		{name: "truncated body", in: proxyV2(1, 1, v4)[:20], wantErr: true},
+	}
+	for _, tt := range tests {
+		addr, err := readProxyV2(bufio.NewReader(bytes.NewReader(tt.in)))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got := addrString(addr); !tt.wantErr && got != tt.want {
+			t.Errorf("%s: addr = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func addrString(a net.Addr) string {
+	if a == nil {
+		return ""
+	}
+	return a.String()
+}
+
+func TestProxyProtoListener(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    bool
+		send       string
+		wantRemote string // "" = connection rejected
+	}{
+		{"v1 from a trusted peer", true, "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\nGET / HTTP/1.0\r\n", "203.0.113.7:51234"},
+		{"untrusted peer", false, "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\nGET / HTTP/1.0\r\n", ""},
+		{"missing header", true, "GET / HTTP/1.0\r\n\r\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Skipf("no loopback listener: %v", err)
+			}
+			pl := ProxyProtoListener(ln, func(string) bool { return tt.trusted })
+			defer pl.Close()
+
+			go func() {
+				c, err := net.Dial("tcp", ln.Addr().String())
+				if err != nil {
+					return
+				}
+				defer c.Close()
+				_, _ = io.WriteString(c, tt.send)
+				_, _ = io.Copy(io.Discard, c)
+			}()
+			conn, err := pl.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if tt.wantRemote == "" {
+				if err == nil {
+					t.Fatalf("read %q, want the connection rejected", line)
+				}
+				return
+			}
+			if err != nil || line != "GET / HTTP/1.0\r\n" {
+				t.Fatalf("read %q, %v; want the request after the header", line, err)
+			}
+			if got := conn.RemoteAddr().String(); got != tt.wantRemote {
+				t.Fatalf("RemoteAddr = %q, want %q", got, tt.wantRemote)
+			}
+		})
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"os"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -15,8 +14,11 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 
+	"github.com/skywalker-88/stormgate/internal/adaptive"
 	"github.com/skywalker-88/stormgate/internal/anom"
+	"github.com/skywalker-88/stormgate/internal/identity"
 	Lm "github.com/skywalker-88/stormgate/internal/middleware"
+	"github.com/skywalker-88/stormgate/internal/plans"
 	"github.com/skywalker-88/stormgate/internal/rl"
 	"github.com/skywalker-88/stormgate/pkg/config"
 	"github.com/skywalker-88/stormgate/pkg/metrics"
@@ -44,50 +46,54 @@ func (sr *statusRecorder) WriteHeader(code int) {
 }
 
 type RouterDeps struct {
-	Cfg       *config.Config
+	Cfg       *config.Holder
 	RL        *Lm.RateLimiter
-	Mitigator rl.Mitigator // optional: future admin endpoints may use this
+	Mitigator rl.Mitigator    // optional: mounts the /admin API (usable once Cfg.Admin.Token is set)
+	Plans     *plans.Resolver // optional: client plans (plans.tiers)
 }
 
 // NewRouter builds the Chi router. If proxy is nil, only local routes are served.
 func NewRouter(d RouterDeps, proxy *httputil.ReverseProxy) (http.Handler, func()) {
 	r := chi.NewRouter()
 
-	// Built-in safety middlewares
-	r.Use(chimw.RequestID, chimw.RealIP, chimw.Recoverer)
+	// Built-in safety middlewares (no chimw.RealIP: it trusts forwarding
+	// headers from anyone; identity resolves the client IP via trusted_proxies)
+	r.Use(chimw.RequestID, chimw.Recoverer)
+
+	// Client identity, shared by the detector, shedder and rate limiter
+	r.Use(identity.Middleware(d.Cfg))
+	if d.Plans != nil {
+		r.Use(d.Plans.Middleware)
+	}
 
 	// zerolog access logging (reads ACCESS_LOG / ACCESS_LOG_SAMPLE)
 	r.Use(Lm.AccessLoggerFromEnv())
 
-	// Anomaly detection middleware (keeps /metrics and /health excluded inside the detector)
+	// Anomaly detection middleware (keeps /metrics, /health and /admin excluded inside the detector)
 	metrics.RegisterAnomalyMetrics(prometheus.DefaultRegisterer)
-	ad := anom.NewDetector(anom.Config{
-		Enabled:               d.Cfg.Anomaly.Enabled,
-		WindowSeconds:         d.Cfg.Anomaly.WindowSeconds,
-		Buckets:               d.Cfg.Anomaly.Buckets,
-		ThresholdMultiplier:   d.Cfg.Anomaly.ThresholdMultiplier,
-		EWMAAlpha:             d.Cfg.Anomaly.EWMAAlpha,
-		TTLSeconds:            d.Cfg.Anomaly.TTLSeconds,
-		EvictEverySeconds:     d.Cfg.Anomaly.EvictEverySeconds,
-		KeepSuspiciousSeconds: d.Cfg.Anomaly.KeepSuspiciousSeconds,
-	}, anom.Deps{
-		Mit: d.RL.Mit,
-		Cfg: d.Cfg,
+	ad := anom.NewDetector(anomalyConfig(d.Cfg.Get()), anom.Deps{
+		Mit:   d.RL.Mit,
+		Cfg:   d.Cfg,
+		Lists: d.RL.Lists,
+	})
+	logAnomalyConfig(d.Cfg.Get())
+	d.Cfg.OnChange(func(c *config.Config) {
+		ad.SetConfig(anomalyConfig(c))
+		logAnomalyConfig(c)
 	})
-	log.Info().
-		Bool("enabled", d.Cfg.Anomaly.Enabled).
-		Int("window_seconds", d.Cfg.Anomaly.WindowSeconds).
-		Int("buckets", d.Cfg.Anomaly.Buckets).
-		Float64("threshold_multiplier", d.Cfg.Anomaly.ThresholdMultiplier).
-		Float64("ewma_alpha", d.Cfg.Anomaly.EWMAAlpha).
-		Int("ttl_seconds", d.Cfg.Anomaly.TTLSeconds).
-		Int("evict_every_seconds", d.Cfg.Anomaly.EvictEverySeconds).
-		Int("keep_suspicious_seconds", d.Cfg.Anomaly.KeepSuspiciousSeconds).
-		Msg("anomaly_config")
 	r.Use(ad.Middleware)
 
+	// Adaptive upstream protection (fed by the proxy handler below)
+	var ctl *adaptive.Controller
+	if d.RL.Mit != nil {
+		ctl = adaptive.NewController(d.RL.Mit, d.Cfg)
+	}
+
 	cleanup := func() {
 		ad.Close() // stop janitor goroutine
+		if ctl != nil {
+			ctl.Close()
+		}
 	}
 
 	r.Get("/", func(w http.ResponseWriter, _ *http.Request) {
@@ -111,12 +117,27 @@ func NewRouter(d RouterDeps, proxy *httputil.ReverseProxy) (http.Handler, func()
 
 	r.Handle("/metrics", promhttp.Handler())
 
+	// Admin API (blocks / overrides); answers 401 to everything while
+	// admin.token is empty, so a reload can enable it
+	if d.Mitigator != nil {
+		r.Mount("/admin", newAdminRouter(d.Mitigator, d.Cfg))
+		if d.Cfg.Get().Admin.Token != "" {
+			log.Info().Msg("admin API enabled at /admin")
+		} else {
+			log.Info().Msg("admin API at /admin disabled until admin.token is set")
+		}
+	}
+
 	// ---- Local demo endpoints (rate-limited) ----
-	readLim := rl.EffectiveLimit(d.Cfg, "/read")
-	searchLim := rl.EffectiveLimit(d.Cfg, "/search")
+
+	// Priority shedding runs in front of the rate limiter on every limited route.
+	sh := Lm.NewShedder(d.Cfg)
+	limit := func(resolve func(*config.Config, *http.Request) string, next http.Handler) http.Handler {
+		return sh.Shed(resolve, d.RL.LimitBy(resolve, next))
+	}
 
 	// /read
-	r.With(func(next http.Handler) http.Handler { return d.RL.Limit("/read", readLim, next) }).
+	r.With(func(next http.Handler) http.Handler { return limit(matchOr("/read"), next) }).
 		Get("/read", func(w http.ResponseWriter, _ *http.Request) {
 			time.Sleep(5 * time.Millisecond)
 			Requests.WithLabelValues("200", "/read").Inc()
@@ -126,7 +147,7 @@ func NewRouter(d RouterDeps, proxy *httputil.ReverseProxy) (http.Handler, func()
 		})
 
 	// /search
-	r.With(func(next http.Handler) http.Handler { return d.RL.Limit("/search", searchLim, next) }).
+	r.With(func(next http.Handler) http.Handler { return limit(matchOr("/search"), next) }).
 		Get("/search", func(w http.ResponseWriter, _ *http.Request) {
 			time.Sleep(40 * time.Millisecond)
 			Requests.WithLabelValues("200", "/search").Inc()
@@ -149,67 +170,41 @@ func NewRouter(d RouterDeps, proxy *httputil.ReverseProxy) (http.Handler, func()
 	}
 	prefix = strings.TrimRight(prefix, "/") // normalize
 
-	// Build the proxy handler (captures status for metrics)
+	// Build the proxy handler (captures status and latency for metrics and
+	// adaptive protection)
 	proxyHandler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		sr := &statusRecorder{ResponseWriter: w, code: 200}
+		start := time.Now()
 		proxy.ServeHTTP(sr, req)
+		if ctl != nil {
+			ctl.Observe(Lm.RouteFrom(req.Context()), time.Since(start), sr.code)
+		}
 		Requests.WithLabelValues(strconv.Itoa(sr.code), "proxy").Inc()
 	})
 
+	// Sub-routes under the prefix ("/api/search", "POST /api/orders", "/api/users/{id}", …)
+	// are resolved per request from the live config, so adding one to policies.yaml
+	// needs no restart.
+	resolve := matchOr(prefix)
+
 	if proxy != nil {
-		// Mount a router at the prefix so we can apply per-subroute limits if configured.
+		// Limit by the specific route key, but always strip <prefix> before proxying upstream.
+		limited := limit(resolve, http.StripPrefix(prefix, proxyHandler))
 		r.Route(prefix, func(api chi.Router) {
-			// 1) Collect all configured routes that are more specific than the prefix.
-			var specific []string
-			for route := range d.Cfg.Limits.Routes {
-				if route == "" || !strings.HasPrefix(route, "/") {
-					continue
-				}
-				if route == prefix {
-					continue
-				}
-				if strings.HasPrefix(route, prefix+"/") {
-					specific = append(specific, route)
-				}
-			}
-			// Longest-first so deeper paths bind before the fallback.
-			sort.Slice(specific, func(i, j int) bool { return len(specific[i]) > len(specific[j]) })
-
-			// 2) For each specific route (/api/search, /api/users, …) apply its own policy.
-			for _, route := range specific {
-				subPath := strings.TrimPrefix(route, prefix) // e.g., "/search"
-				if subPath == "" {
-					continue
-				}
-				base := rl.EffectiveLimit(d.Cfg, route)
-
-				api.Route(subPath, func(sr chi.Router) {
-					// Limit by the specific route key, but always strip <prefix> before proxying upstream.
-					limited := d.RL.Limit(route, base, http.StripPrefix(prefix, proxyHandler))
-					// Match both the exact path and any children under it.
-					sr.Handle("/", limited)
-					sr.Handle("/*", limited)
-				})
-			}
-
-			// 3) Fallback for anything else under the prefix -> use the prefix-level policy.
-			prefixBase := rl.EffectiveLimit(d.Cfg, prefix)
-			prefixLimited := d.RL.Limit(prefix, prefixBase, http.StripPrefix(prefix, proxyHandler))
-			api.Handle("/", prefixLimited)
-			api.Handle("/*", prefixLimited)
+			api.Handle("/", limited)
+			api.Handle("/*", limited)
 		})
 
 	} else {
-		// Stub handler when no proxy exists, still rate-limited (prefix-level)
+		// Stub handler when no proxy exists, still rate-limited
 		r.Route(prefix, func(api chi.Router) {
-			prefixBase := rl.EffectiveLimit(d.Cfg, prefix)
 			stub := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusOK)
 				_, _ = w.Write([]byte(`{"ok":true,"via":"stub","path":"` + r.URL.Path + `"}`))
 			})
-			api.Handle("/", d.RL.Limit(prefix, prefixBase, stub))
-			api.Handle("/*", d.RL.Limit(prefix, prefixBase, stub))
+			api.Handle("/", limit(resolve, stub))
+			api.Handle("/*", limit(resolve, stub))
 		})
 	}
 
@@ -221,3 +216,40 @@ func NewRouter(d RouterDeps, proxy *httputil.ReverseProxy) (http.Handler, func()
 
 	return r, cleanup
 }
+
+// matchOr resolves the request to the most specific configured route
+// (method + path template), falling back to def when none covers it.
+func matchOr(def string) func(*config.Config, *http.Request) string {
+	return func(c *config.Config, req *http.Request) string {
+		if route := rl.MatchRoute(c, req.Method, req.URL.Path); route != "" {
+			return route
+		}
+		return def
+	}
+}
+
+func anomalyConfig(c *config.Config) anom.Config {
+	return anom.Config{
+		Enabled:               c.Anomaly.Enabled,
+		WindowSeconds:         c.Anomaly.WindowSeconds,
+		Buckets:               c.Anomaly.Buckets,
+		ThresholdMultiplier:   c.Anomaly.ThresholdMultiplier,
+		EWMAAlpha:             c.Anomaly.EWMAAlpha,
+		TTLSeconds:            c.Anomaly.TTLSeconds,
+		EvictEverySeconds:     c.Anomaly.EvictEverySeconds,
+		KeepSuspiciousSeconds: c.Anomaly.KeepSuspiciousSeconds,
+	}
+}
+
+func logAnomalyConfig(c *config.Config) {
+	log.Info().
+		Bool("enabled", c.Anomaly.Enabled).
+		Int("window_seconds", c.Anomaly.WindowSeconds).
+		Int("buckets", c.Anomaly.Buckets).
+		Float64("threshold_multiplier", c.Anomaly.ThresholdMultiplier).
+		Float64("ewma_alpha", c.Anomaly.EWMAAlpha).
+		Int("ttl_seconds", c.Anomaly.TTLSeconds).
+		Int("evict_every_seconds", c.Anomaly.EvictEverySeconds).
+		Int("keep_suspicious_seconds", c.Anomaly.KeepSuspiciousSeconds).
+		Msg("anomaly_config")
+}
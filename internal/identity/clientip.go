@@ -0,0 +1,149 @@
+package identity
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/skywalker-88/stormgate/pkg/config"
+)
+
+// ClientIP is the address the request came from. The peer (RemoteAddr,
+// already rewritten by a PROXY protocol header) is the client unless it is
+// a trusted proxy; then identity.forwarded_header (X-Forwarded-For, or
+// RFC 7239 Forwarded) is walked right to left and the first hop that is not
+// a trusted proxy wins. Entries left of it were written by the client and
+// are ignored, and so is the other header.
+func ClientIP(c *config.Config, req *http.Request) string {
+	peer := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	var trusted []netip.Prefix
+	header := ""
+	if c != nil {
+		trusted = chainFor(c).trusted
+		header = c.Identity.ForwardedHeader
+	}
+	if !isTrusted(trusted, peer) {
+		return peer
+	}
+
+	var hops []string
+	if strings.EqualFold(header, "forwarded") {
+		hops = forwardedFor(req.Header.Values("Forwarded"))
+	} else {
+		for _, v := range req.Header.Values("X-Forwarded-For") {
+			for _, h := range strings.Split(v, ",") {
+				hops = append(hops, strings.TrimSpace(h))
+			}
+		}
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		if _, err := netip.ParseAddr(hops[i]); err != nil {
+			break // garbage or "unknown": keep the last hop we could read
+		}
+		client = hops[i]
+		if !isTrusted(trusted, client) {
+			break
+		}
+	}
+	return client
+}
+
+// IsTrustedProxy reports whether addr (an IP, or host:port) is in
+// identity.trusted_proxies.
+func IsTrustedProxy(c *config.Config, addr string) bool {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return isTrusted(chainFor(c).trusted, addr)
+}
+
+func isTrusted(trusted []netip.Prefix, ip string) bool {
+	if len(trusted) == 0 {
+		return false
+	}
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	a = a.Unmap()
+	for _, p := range trusted {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor lists the for= addresses of RFC 7239 Forwarded headers in
+// order: quotes, brackets and ports are stripped;
+// obfuscated or unknown nodes are kept as is.
+func forwardedFor(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			node := ""
+			for _, pair := range strings.Split(elem, ";") {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					node = strings.Trim(val, `"`)
+				}
+			}
+			if strings.HasPrefix(node, "[") {
+				// "[2001:db8::1]:4711"
+				if end := strings.IndexByte(node, ']'); end > 0 {
+					node = node[1:end]
+				}
+			} else if host, _, err := net.SplitHostPort(node); err == nil {
+				node = host
+			}
+			out = append(out, node)
+		}
+	}
+	return out
+}
+
+// Network returns the v4 or v6 prefix (by ip's family) containing ip; false
+// for an unparsable ip or a family whose length is 0.
+func Network(ip string, v4, v6 int) (netip.Prefix, bool) {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	a = a.Unmap().WithZone("")
+	bits := v6
+	if a.Is4() {
+		bits = v4
+	}
+	if bits <= 0 {
+		return netip.Prefix{}, false
+	}
+	p, err := a.Prefix(bits)
+	return p, err == nil
+}
+
+// clientKey is the ID of a client known by ip: ip itself, or its network
+// under identity.ip_prefix ("2001:db8:1:2::/64").
+func clientKey(c *config.Config, ip string) string {
+	v4, v6 := 32, 64
+	if c != nil {
+		if p := c.Identity.IPPrefix; p.V4 > 0 {
+			v4 = p.V4
+		}
+		if p := c.Identity.IPPrefix; p.V6 > 0 {
+			v6 = p.V6
+		}
+	}
+	p, ok := Network(ip, v4, v6)
+	if !ok {
+		return ip
+	}
+	if p.IsSingleIP() {
+		return p.Addr().String()
+	}
+	return p.String()
+}
@@ -0,0 +1,112 @@
+package identity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/skywalker-88/stormgate/pkg/config"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []string{"10.0.0.0/8", "192.168.1.1"}
+	tests := []struct {
+		name      string
+		header    string // identity.forwarded_header
+		trusted   []string
+		peer      string
+		xff       []string
+		forwarded []string
+		want      string
+	}{
+		{name: "no trusted proxies", peer: "10.0.0.1:5000", xff: []string{"203.0.113.7"}, want: "10.0.0.1"},
+		{name: "untrusted peer ignores headers", trusted: trusted, peer: "198.51.100.9:5000",
+			xff: []string{"203.0.113.7"}, want: "198.51.100.9"},
+		{name: "trusted peer without header", trusted: trusted, peer: "10.0.0.1:5000", want: "10.0.0.1"},
+		{name: "single hop", trusted: trusted, peer: "10.0.0.1:5000", xff: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "right to left skips trusted hops", trusted: trusted, peer: "10.0.0.1:5000",
+			xff: []string{"203.0.113.7, 192.168.1.1, 10.2.3.4"}, want: "203.0.113.7"},
+		{name: "client-written entries left of the first untrusted hop are ignored", trusted: trusted,
+			peer: "10.0.0.1:5000", xff: []string{"1.2.3.4, 9.9.9.9, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "untrusted hop in the middle stops the walk", trusted: trusted, peer: "10.0.0.1:5000",
+			xff: []string{"203.0.113.7, 198.51.100.2, 10.2.3.4"}, want: "198.51.100.2"},
+		{name: "repeated headers are one list", trusted: trusted, peer: "10.0.0.1:5000",
+			xff: []string{"203.0.113.7", "10.2.3.4"}, want: "203.0.113.7"},
+		{name: "garbage keeps the last readable hop", trusted: trusted, peer: "10.0.0.1:5000",
+			xff: []string{"not-an-ip, 10.2.3.4"}, want: "10.2.3.4"},
+		{name: "all hops trusted", trusted: trusted, peer: "10.0.0.1:5000",
+			xff: []string{"10.9.9.9, 10.2.3.4"}, want: "10.9.9.9"},
+		{name: "mapped v4 peer is trusted", trusted: trusted, peer: "[::ffff:10.0.0.1]:5000",
+			xff: []string{"203.0.113.7"}, want: "203.0.113.7"},
+
+		// Only the configured header counts.
+		{name: "xff mode ignores a client Forwarded", trusted: trusted, peer: "10.0.0.1:5000",
+			xff: []string{"203.0.113.7"}, forwarded: []string{"for=9.9.9.1"}, want: "203.0.113.7"},
+		{name: "xff mode without xff ignores Forwarded", trusted: trusted, peer: "10.0.0.1:5000",
+			forwarded: []string{"for=9.9.9.1"}, want: "10.0.0.1"},
+		{name: "forwarded mode ignores xff", header: "forwarded", trusted: trusted, peer: "10.0.0.1:5000",
+			xff: []string{"9.9.9.1"}, forwarded: []string{"for=203.0.113.7"}, want: "203.0.113.7"},
+		{name: "forwarded mode walks right to left", header: "Forwarded", trusted: trusted, peer: "10.0.0.1:5000",
+			forwarded: []string{`for=1.2.3.4, for="[2001:db8::1]:4711";proto=https, for=10.2.3.4:80`},
+			want:      "2001:db8::1"},
+		{name: "forwarded unknown node stops the walk", header: "forwarded", trusted: trusted, peer: "10.0.0.1:5000",
+			forwarded: []string{"for=203.0.113.7, for=unknown, for=10.2.3.4"}, want: "10.2.3.4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &config.Config{}
+			c.Identity.TrustedProxies = tt.trusted
+			c.Identity.ForwardedHeader = tt.header
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.peer
+			for _, v := range tt.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			for _, v := range tt.forwarded {
+				req.Header.Add("Forwarded", v)
+			}
+			if got := ClientIP(c, req); got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// A client behind an XFF-appending proxy cannot mint fresh identities by
+// rotating its own Forwarded header.
+func TestClientIPForwardedSpoofing(t *testing.T) {
+	c := &config.Config{}
+	c.Identity.TrustedProxies = []string{"10.0.0.1"}
+	seen := map[string]bool{}
+	for _, f := range []string{"for=9.9.9.1", "for=9.9.9.2", "for=9.9.9.3"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0.1:5000"
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		req.Header.Set("Forwarded", f)
+		seen[ClientIP(c, req)] = true
+	}
+	if len(seen) != 1 || !seen["203.0.113.7"] {
+		t.Fatalf("resolved %v, want only 203.0.113.7", seen)
+	}
+}
+
+func TestNetwork(t *testing.T) {
+	tests := []struct {
+		ip     string
+		v4, v6 int
+		want   string
+		ok     bool
+	}{
+		{"203.0.113.7", 24, 48, "203.0.113.0/24", true},
+		{"::ffff:203.0.113.7", 24, 48, "203.0.113.0/24", true},
+		{"2001:db8:1:2:3::1", 24, 48, "2001:db8:1::/48", true},
+		{"203.0.113.7", 0, 48, "", false},
+		{"bogus", 24, 48, "", false},
+	}
+	for _, tt := range tests {
+		p, ok := Network(tt.ip, tt.v4, tt.v6)
+		if ok != tt.ok || (ok && p.String() != tt.want) {
+			t.Errorf("Network(%q, %d, %d) = %v, %v; want %s, %v", tt.ip, tt.v4, tt.v6, p, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Package identity decides who the client of a request is. Every limiter,
+// detector and shedder keys on the same Identity, attached to the request
+// context by Middleware.
+package identity
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/skywalker-88/stormgate/pkg/config"
+)
+
+// Identity is the client of one request.
+type Identity struct {
+	ID        string // key of the client's buckets, overrides and blocks (an IP is cut to identity.ip_prefix)
+	Source    string // type of the source that matched (header, jwt, ..., ip)
+	Anonymous bool   // no source matched; the client is known by its IP only
+	IP        string // client address (see ClientIP)
+	Plan      string // limits plan (plans.tiers), set by plans.Middleware; "" for the top-level limits
+	Org       string // tenant organization (tenants); "" for none
+}
+
+type ctxKey struct{}
+
+// NewContext attaches id to ctx.
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the identity attached by Middleware.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(ctxKey{}).(Identity)
+	return id, ok
+}
+
+// Middleware resolves the identity once per request and attaches it.
+func Middleware(cfg *config.Holder) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			id := Resolve(cfg.Get(), req)
+			next.ServeHTTP(w, req.WithContext(NewContext(req.Context(), id)))
+		})
+	}
+}
+
+// Of returns the identity Middleware attached to req, resolving it from c
+// when the request did not pass the middleware.
+func Of(c *config.Config, req *http.Request) Identity {
+	if id, ok := FromContext(req.Context()); ok {
+		return id
+	}
+	return Resolve(c, req)
+}
+
+// Resolve runs the identity chain of c (nil: IP only) against req.
+func Resolve(c *config.Config, req *http.Request) Identity {
+	ip := ClientIP(c, req)
+	key := clientKey(c, ip)
+	if c != nil {
+		ch := chainFor(c)
+		for _, s := range ch.sources {
+			if v, ok := s.extract(req, key); ok {
+				return Identity{ID: v, Source: s.Type, IP: ip, Org: ch.org(req, v)}
+			}
+		}
+	}
+	id := key
+	if id == "" {
+		id = "anon"
+	}
+	return Identity{ID: id, Source: "ip", Anonymous: true, IP: ip}
+}
+
+// ---- chain ----
+
+// source is one compiled identity.sources entry.
+type source struct {
+	config.IdentitySource
+	jwt   *jwtVerifier // jwt sources
+	parts []source     // composite sources
+}
+
+type chain struct {
+	c       *config.Config
+	sources []source
+	trusted []netip.Prefix // identity.trusted_proxies
+	tenant  *source        // tenants.source; nil when tenants are off or orgs come from tenants.keys only
+}
+
+// chains caches the chain of the live config; a reload swaps the *Config,
+// which triggers a recompile on the next request.
+var chains atomic.Pointer[chain]
+
+func chainFor(c *config.Config) *chain {
+	if ch := chains.Load(); ch != nil && ch.c == c {
+		return ch
+	}
+	ch := &chain{c: c}
+	srcs := c.Identity.Sources
+	if len(srcs) == 0 {
+		// identity.source shorthand: "header:<Name>" (else IP only)
+		if src := c.Identity.Source; strings.HasPrefix(strings.ToLower(src), "header:") {
+			srcs = []config.IdentitySource{{Type: "header", Name: strings.TrimSpace(src[len("header:"):])}}
+		}
+	}
+	for _, s := range srcs {
+		ch.sources = append(ch.sources, compile(s))
+	}
+	for _, p := range c.Identity.TrustedProxies {
+		if pfx, err := config.ParsePrefix(p); err == nil { // else rejected by Validate
+			ch.trusted = append(ch.trusted, pfx)
+		}
+	}
+	if t := c.Tenants; t.Enabled && t.Source.Type != "" {
+		s := compile(t.Source)
+		ch.tenant = &s
+	}
+	chains.Store(ch)
+	return ch
+}
+
+// org finds the organization of the client id (tenants.keys, else
+// tenants.source).
+func (ch *chain) org(req *http.Request, id string) string {
+	if !ch.c.Tenants.Enabled {
+		return ""
+	}
+	if org, ok := ch.c.Tenants.Keys[id]; ok {
+		return org
+	}
+	if ch.tenant != nil {
+		org, _ := ch.tenant.extract(req, "")
+		return org
+	}
+	return ""
+}
+
+func compile(s config.IdentitySource) source {
+	out := source{IdentitySource: s}
+	if s.Type == "jwt" {
+		secret := s.HMACSecret
+		if s.HMACSecretEnv != "" {
+			secret = os.Getenv(s.HMACSecretEnv)
+		}
+		out.jwt = newJWTVerifier(secret, s.PublicKeyFile)
+	}
+	for _, p := range s.Parts {
+		out.parts = append(out.parts, compile(p))
+	}
+	return out
+}
+
+func (s source) extract(req *http.Request, ip string) (string, bool) {
+	v := ""
+	switch s.Type {
+	case "header":
+		v = strings.TrimSpace(req.Header.Get(s.Name))
+	case "cookie":
+		if ck, err := req.Cookie(s.Name); err == nil {
+			v = ck.Value
+		}
+	case "query":
+		v = req.URL.Query().Get(s.Name)
+	case "jwt":
+		v = s.jwt.claim(bearer(req, s.Name), s.Claim)
+	case "mtls":
+		v = certField(req, s.Field)
+	case "ip":
+		v = ip
+	case "composite":
+		vals := make([]string, 0, len(s.parts))
+		for _, p := range s.parts {
+			pv, ok := p.extract(req, ip)
+			if !ok {
+				return "", false
+			}
+			vals = append(vals, pv)
+		}
+		v = strings.Join(vals, "+")
+	}
+	if v == "" {
+		return "", false
+	}
+	if s.Hash {
+		sum := sha256.Sum256([]byte(v))
+		v = hex.EncodeToString(sum[:16])
+	}
+	return v, true
+}
+
+// bearer reads the token from header (default Authorization), stripping
+// a "Bearer " scheme.
+func bearer(req *http.Request, header string) string {
+	if header == "" {
+		header = "Authorization"
+	}
+	v := strings.TrimSpace(req.Header.Get(header))
+	if len(v) > 7 && strings.EqualFold(v[:7], "bearer ") {
+		v = strings.TrimSpace(v[7:])
+	}
+	return v
+}
+
+// certField reads the verified client certificate (server.tls.client_ca_file).
+func certField(req *http.Request, field string) string {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	cert := req.TLS.VerifiedChains[0][0]
+	switch field {
+	case "subject":
+		return cert.Subject.String()
+	case "fingerprint":
+		sum := sha256.Sum256(cert.Raw)
+		return hex.EncodeToString(sum[:])
+	default:
+		return cert.Subject.CommonName
+	}
+}
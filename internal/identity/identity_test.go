@@ -0,0 +1,61 @@
+package identity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/skywalker-88/stormgate/pkg/config"
+)
+
+func TestResolve(t *testing.T) {
+	c := &config.Config{}
+	c.Identity.Sources = []config.IdentitySource{
+		{Type: "composite", Parts: []config.IdentitySource{{Type: "header", Name: "X-Tenant"}, {Type: "header", Name: "X-User"}}},
+		{Type: "header", Name: "X-API-Key", Hash: true},
+		{Type: "jwt", Claim: "sub", HMACSecret: "s3cret"},
+		{Type: "cookie", Name: "session"},
+		{Type: "query", Name: "api_key"},
+	}
+	c.Identity.IPPrefix = config.IPPrefix{V6: 64}
+	token := signJWT(t, "HS256", map[string]any{"sub": "user-1"}, hs256("s3cret"))
+
+	tests := []struct {
+		name       string
+		target     string
+		remote     string
+		headers    map[string]string
+		wantID     string
+		wantSource string
+	}{
+		{name: "composite needs every part", headers: map[string]string{"X-Tenant": "acme", "X-User": "bob"},
+			wantID: "acme+bob", wantSource: "composite"},
+		{name: "partial composite falls through", headers: map[string]string{"X-Tenant": "acme", "X-API-Key": "k1"},
+			wantID: "6ab9f1eb8f7d3388f4f9d586f66e99fd", wantSource: "header"}, // hash: first 16 bytes of SHA-256
+		{name: "jwt", headers: map[string]string{"Authorization": "Bearer " + token}, wantID: "user-1", wantSource: "jwt"},
+		{name: "cookie", headers: map[string]string{"Cookie": "session=abc"}, wantID: "abc", wantSource: "cookie"},
+		{name: "query", target: "/?api_key=q1", wantID: "q1", wantSource: "query"},
+		{name: "bad jwt falls through to the ip", headers: map[string]string{"Authorization": "Bearer x.y.z"},
+			wantID: "192.0.2.1", wantSource: "ip"},
+		{name: "v6 clients are keyed by /64", remote: "[2001:db8:1:2:3::9]:443", wantID: "2001:db8:1:2::/64", wantSource: "ip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target
+			if target == "" {
+				target = "/"
+			}
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			if tt.remote != "" {
+				req.RemoteAddr = tt.remote
+			}
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			id := Resolve(c, req)
+			if id.ID != tt.wantID || id.Source != tt.wantSource || id.Anonymous != (tt.wantSource == "ip") {
+				t.Fatalf("got %+v, want ID %q from %s", id, tt.wantID, tt.wantSource)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package identity
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"hash"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	_ "crypto/sha256" // register SHA-256 for crypto.Hash
+	_ "crypto/sha512" // register SHA-384/512
+
+	"github.com/rs/zerolog/log"
+)
+
+// jwtVerifier checks compact JWS tokens with one key; with neither a
+// secret nor a key it only decodes them.
+type jwtVerifier struct {
+	secret []byte
+	key    crypto.PublicKey
+	broken bool // the key file could not be loaded: nothing verifies
+}
+
+func newJWTVerifier(secret, keyFile string) *jwtVerifier {
+	v := &jwtVerifier{}
+	if secret != "" {
+		v.secret = []byte(secret)
+	}
+	if keyFile != "" {
+		key, err := loadPublicKey(keyFile)
+		if err != nil {
+			log.Error().Err(err).Str("file", keyFile).Msg("identity: jwt public key unusable; jwt source disabled")
+			v.broken = true
+		}
+		v.key = key
+	}
+	return v
+}
+
+func loadPublicKey(file string) (crypto.PublicKey, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	blk, _ := pem.Decode(b)
+	if blk == nil {
+		return nil, errNoPEM
+	}
+	if blk.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(blk.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	return x509.ParsePKIXPublicKey(blk.Bytes)
+}
+
+var errNoPEM = errors.New("no PEM block")
+
+var hashes = map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+
+// claim returns the claim at path (dot-separated) of a valid, unexpired
+// token, or "".
+func (v *jwtVerifier) claim(token, path string) string {
+	if token == "" || v.broken {
+		return ""
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	if v.secret != nil || v.key != nil {
+		var hdr struct {
+			Alg string `json:"alg"`
+		}
+		if !decodeSegment(parts[0], &hdr) || !v.verify(hdr.Alg, parts[0]+"."+parts[1], parts[2]) {
+			return ""
+		}
+	}
+	var claims map[string]any
+	if !decodeSegment(parts[1], &claims) {
+		return ""
+	}
+	now := float64(time.Now().Unix())
+	if exp, ok := claims["exp"].(json.Number); ok {
+		if f, err := exp.Float64(); err == nil && now >= f {
+			return ""
+		}
+	}
+	if nbf, ok := claims["nbf"].(json.Number); ok {
+		if f, err := nbf.Float64(); err == nil && now < f {
+			return ""
+		}
+	}
+
+	var cur any = claims
+	for _, k := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return ""
+		}
+		cur = m[k]
+	}
+	switch val := cur.(type) {
+	case string:
+		return val
+	case json.Number:
+		return val.String()
+	}
+	return ""
+}
+
+func decodeSegment(seg string, out any) bool {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return false
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(out) == nil
+}
+
+// verify checks sig over signed; alg must match the configured key type
+// ("none" and mismatches never verify).
+func (v *jwtVerifier) verify(alg, signed, sig string) bool {
+	if len(alg) != 5 {
+		return false
+	}
+	h, ok := hashes[alg[2:]]
+	if !ok {
+		return false
+	}
+	s, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	if alg[:2] == "HS" {
+		if v.secret == nil {
+			return false
+		}
+		mac := hmac.New(func() hash.Hash { return h.New() }, v.secret)
+		mac.Write([]byte(signed))
+		return hmac.Equal(mac.Sum(nil), s)
+	}
+
+	d := h.New()
+	d.Write([]byte(signed))
+	digest := d.Sum(nil)
+	switch key := v.key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(key, h, digest, s) == nil
+		case "PS":
+			return rsa.VerifyPSS(key, h, digest, s, nil) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(s) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(s[:size])
+		ss := new(big.Int).SetBytes(s[size:])
+		return ecdsa.Verify(key, digest, r, ss)
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package identity
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func segment(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b64(b)
+}
+
+// signJWT builds a compact token; sign gets the signing input.
+func signJWT(t *testing.T, alg string, claims map[string]any, sign func(signed []byte) []byte) string {
+	t.Helper()
+	signed := segment(t, map[string]string{"alg": alg, "typ": "JWT"}) + "." + segment(t, claims)
+	return signed + "." + b64(sign([]byte(signed)))
+}
+
+func hs256(secret string) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(signed)
+		return mac.Sum(nil)
+	}
+}
+
+func writePublicKey(t *testing.T, pub any) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestJWTClaim(t *testing.T) {
+	now := time.Now().Unix()
+	claims := map[string]any{"sub": "user-1", "org": map[string]any{"id": "acme", "n": 42}, "exp": now + 60}
+	hmacV := newJWTVerifier("s3cret", "")
+
+	tests := []struct {
+		name  string
+		v     *jwtVerifier
+		token string
+		path  string
+		want  string
+	}{
+		{"hs256", hmacV, signJWT(t, "HS256", claims, hs256("s3cret")), "sub", "user-1"},
+		{"nested claim", hmacV, signJWT(t, "HS256", claims, hs256("s3cret")), "org.id", "acme"},
+		{"numeric claim", hmacV, signJWT(t, "HS256", claims, hs256("s3cret")), "org.n", "42"},
+		{"object claim", hmacV, signJWT(t, "HS256", claims, hs256("s3cret")), "org", ""},
+		{"missing claim", hmacV, signJWT(t, "HS256", claims, hs256("s3cret")), "email", ""},
+		{"wrong secret", hmacV, signJWT(t, "HS256", claims, hs256("other")), "sub", ""},
+		{"alg none", hmacV, signJWT(t, "none", claims, func([]byte) []byte { return nil }), "sub", ""},
+		{"alg mismatch", hmacV, signJWT(t, "HS384", claims, hs256("s3cret")), "sub", ""},
+		{"expired", hmacV, signJWT(t, "HS256", map[string]any{"sub": "u", "exp": now - 1}, hs256("s3cret")), "sub", ""},
+		{"not yet valid", hmacV, signJWT(t, "HS256", map[string]any{"sub": "u", "nbf": now + 60}, hs256("s3cret")), "sub", ""},
+		{"malformed", hmacV, "a.b", "sub", ""},
+		{"garbage segments", hmacV, "!!.??.==", "sub", ""},
+		{"unverified decode", newJWTVerifier("", ""), signJWT(t, "none", claims, func([]byte) []byte { return nil }), "sub", "user-1"},
+		{"unverified still checks exp", newJWTVerifier("", ""),
+			signJWT(t, "none", map[string]any{"sub": "u", "exp": now - 1}, func([]byte) []byte { return nil }), "sub", ""},
+		{"broken key file", newJWTVerifier("", filepath.Join(t.TempDir(), "missing.pem")),
+			signJWT(t, "none", claims, func([]byte) []byte { return nil }), "sub", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.v.claim(tt.token, tt.path); got != tt.want {
+				t.Errorf("claim(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWTPublicKeys(t *testing.T) {
+	claims := map[string]any{"sub": "user-1"}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := func(b []byte) []byte { d := sha256.Sum256(b); return d[:] }
+	rs256 := func(b []byte) []byte {
+		s, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest(b))
+		return s
+	}
+	ps256 := func(b []byte) []byte {
+		s, _ := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest(b), nil)
+		return s
+	}
+	es256 := func(b []byte) []byte {
+		r, s, _ := ecdsa.Sign(rand.Reader, ecKey, digest(b))
+		out := make([]byte, 64)
+		r.FillBytes(out[:32])
+		s.FillBytes(out[32:])
+		return out
+	}
+	rsaV := newJWTVerifier("", writePublicKey(t, &rsaKey.PublicKey))
+	ecV := newJWTVerifier("", writePublicKey(t, &ecKey.PublicKey))
+	pubDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+
+	tests := []struct {
+		name  string
+		v     *jwtVerifier
+		token string
+		want  string
+	}{
+		{"rs256", rsaV, signJWT(t, "RS256", claims, rs256), "user-1"},
+		{"ps256", rsaV, signJWT(t, "PS256", claims, ps256), "user-1"},
+		{"es256", ecV, signJWT(t, "ES256", claims, es256), "user-1"},
+		{"rs256 against an ec key", ecV, signJWT(t, "RS256", claims, rs256), ""},
+		{"es256 against an rsa key", rsaV, signJWT(t, "ES256", claims, es256), ""},
+		// HS256 keyed with the public key bytes must not verify against an RSA key.
+		{"hmac with the public key", rsaV, signJWT(t, "HS256", claims, hs256(string(pubDER))), ""},
+		{"tampered claims", rsaV, func() string {
+			// the claims of one token with the signature of another
+			tok := signJWT(t, "RS256", map[string]any{"sub": "admin"}, rs256)
+			orig := signJWT(t, "RS256", claims, rs256)
+			return tok[:strings.LastIndex(tok, ".")] + orig[strings.LastIndex(orig, "."):]
+		}(), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.v.claim(tt.token, "sub"); got != tt.want {
+				t.Errorf("claim(sub) = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
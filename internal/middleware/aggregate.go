@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"strconv"
+
+	"github.com/skywalker-88/stormgate/internal/identity"
+	"github.com/skywalker-88/stormgate/internal/rl"
+	"github.com/skywalker-88/stormgate/pkg/config"
+)
+
+// sharedBucket is a bucket a client shares with other clients (its
+// networks, its org), charged in the same atomic call as its own.
+type sharedBucket struct {
+	scope string
+	key   string
+	limit config.Limit
+}
+
+// ipAggregates returns the aggregate buckets of a client known by IP and
+// the hash tag its own buckets must share with them: the coarsest of the
+// networks. Identified clients and IPs no entry covers get none.
+func ipAggregates(cfg *config.Config, id identity.Identity) (tag string, out []sharedBucket) {
+	if !id.Anonymous || len(cfg.Limits.IPAggregates) == 0 {
+		return "", nil
+	}
+	type network struct {
+		scope, cidr string
+		limit       config.Limit
+	}
+	var nets []network
+	coarsest := -1
+	for _, a := range cfg.Limits.IPAggregates {
+		p, ok := identity.Network(id.IP, a.V4, a.V6)
+		if !ok {
+			continue
+		}
+		nets = append(nets, network{"aggregate/" + strconv.Itoa(p.Bits()), p.String(), a.Limit})
+		if coarsest < 0 || p.Bits() < coarsest {
+			coarsest, tag = p.Bits(), p.String()
+		}
+	}
+	for _, n := range nets {
+		out = append(out, sharedBucket{scope: n.scope, key: rl.AggregateKey(tag, n.cidr), limit: n.limit})
+	}
+	return tag, out
+}
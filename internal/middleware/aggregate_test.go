@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/skywalker-88/stormgate/internal/identity"
+	"github.com/skywalker-88/stormgate/internal/rl"
+	"github.com/skywalker-88/stormgate/pkg/config"
+)
+
+func TestIPAggregates(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Limits.IPAggregates = []config.IPAggregate{
+		{V4: 24, V6: 64, Limit: config.Limit{RPS: 50, Burst: 50}},
+		{V4: 16, V6: 48, Limit: config.Limit{RPS: 500, Burst: 500}},
+		{V6: 56, Limit: config.Limit{RPS: 200, Burst: 200}}, // IPv6 only
+	}
+	tests := []struct {
+		name string
+		id   identity.Identity
+		tag  string
+		keys map[string]string // scope -> key
+	}{
+		{"ipv4 tagged by the coarsest network", identity.Identity{ID: "203.0.113.7", IP: "203.0.113.7", Anonymous: true},
+			"203.0.0.0/16", map[string]string{
+				"aggregate/24": "rl:{203.0.0.0/16}:agg:203.0.113.0/24",
+				"aggregate/16": "rl:{203.0.0.0/16}:agg:203.0.0.0/16",
+			}},
+		{"ipv6", identity.Identity{ID: "2001:db8:1:2::/64", IP: "2001:db8:1:2::5", Anonymous: true},
+			"2001:db8:1::/48", map[string]string{
+				"aggregate/64": "rl:{2001:db8:1::/48}:agg:2001:db8:1:2::/64",
+				"aggregate/48": "rl:{2001:db8:1::/48}:agg:2001:db8:1::/48",
+				"aggregate/56": "rl:{2001:db8:1::/48}:agg:2001:db8:1::/56",
+			}},
+		{"identified client", identity.Identity{ID: "key-1", IP: "203.0.113.7"}, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tag, shared := ipAggregates(cfg, tt.id)
+			if tag != tt.tag || len(shared) != len(tt.keys) {
+				t.Fatalf("tag %q with %d buckets, want %q with %d", tag, len(shared), tt.tag, len(tt.keys))
+			}
+			for _, s := range shared {
+				if s.key != tt.keys[s.scope] {
+					t.Errorf("%s: key %q, want %q", s.scope, s.key, tt.keys[s.scope])
+				}
+			}
+		})
+	}
+}
+
+// Clients of one network share its aggregate bucket, and their own buckets
+// move under the network's hash tag.
+func TestAggregateSharedAcrossClients(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Limits.Default = config.Limit{RPS: 1, Burst: 100, Cost: 1}
+	cfg.Limits.IPAggregates = []config.IPAggregate{{V4: 24, Limit: config.Limit{RPS: 1, Burst: 2}}}
+	mem := rl.NewMemory()
+	r := NewRateLimiter(mem, config.NewHolder(cfg), nil, nil)
+	h := r.Limit("/api", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	serve := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api", nil)
+		req = req.WithContext(identity.NewContext(req.Context(), identity.Identity{ID: ip, IP: ip, Anonymous: true}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	serve("198.51.100.1")
+	serve("198.51.100.2")
+	if w := serve("198.51.100.3"); w.Code != http.StatusTooManyRequests || w.Header().Get("X-StormGate-Denied-By") != "aggregate/24" {
+		t.Fatalf("third client of the /24: %d denied by %q, want 429 by aggregate/24", w.Code, w.Header().Get("X-StormGate-Denied-By"))
+	}
+	if w := serve("198.51.101.1"); w.Code != http.StatusOK {
+		t.Fatalf("client of another /24: %d", w.Code)
+	}
+
+	// The first client's route bucket lives under the network's tag.
+	_, res, err := mem.ConsumeAll(t.Context(), []rl.Bucket{{
+		Key:  rl.TaggedRouteKey("198.51.100.0/24", "/api", "198.51.100.1"),
+		Rate: rl.RateOf(cfg.Limits.Default), Cost: 1,
+	}})
+	if err != nil || res[0].Remaining >= 99 {
+		t.Fatalf("tagged route bucket: %+v %v, want the first request charged there", res, err)
+	}
+}
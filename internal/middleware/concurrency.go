@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/skywalker-88/stormgate/internal/rl"
+	"github.com/skywalker-88/stormgate/pkg/config"
+	"github.com/skywalker-88/stormgate/pkg/metrics"
+)
+
+const defaultLeaseSeconds = 30
+
+// lease is one in-flight slot a request must hold.
+type lease struct {
+	key   string
+	limit int64
+	ttl   time.Duration
+	store rl.Backend // where it was acquired (Redis, or Local after a failure)
+}
+
+// concurrencyLeases lists the slots configured for route and client (whose
+// plan may set its own global_client.concurrency).
+func concurrencyLeases(cfg *config.Config, base config.Limit, route, clientID, plan string) []lease {
+	var out []lease
+	add := func(key string, limit int64, c config.Concurrency) {
+		if limit <= 0 {
+			return
+		}
+		secs := c.LeaseSeconds
+		if secs <= 0 {
+			secs = defaultLeaseSeconds
+		}
+		out = append(out, lease{key: key, limit: limit, ttl: time.Duration(secs) * time.Second})
+	}
+	g := cfg.Limits.GlobalClient.Concurrency
+	if pc := cfg.Plans.Tiers[plan].GlobalClient.Concurrency; pc != (config.Concurrency{}) {
+		g = pc
+	}
+	add(rl.GlobalConcurrencyKey(clientID), g.PerClient, g)
+	add(rl.ConcurrencyKey(route, clientID), base.Concurrency.PerClient, base.Concurrency)
+	add(rl.RouteConcurrencyKey(route), base.Concurrency.Route, base.Concurrency)
+	return out
+}
+
+// acquireLeases takes every in-flight slot of the request. On success it
+// returns a release func (also stopping the renew heartbeat). On a denial or
+// a store failure answered by on_store_error=deny it writes the response,
+// refunds the rate-limit charge and returns ok=false.
+func (r *RateLimiter) acquireLeases(w http.ResponseWriter, req *http.Request, st *storeState,
+	cfg *config.Config, base config.Limit, route, clientID, plan string, charged []rl.Bucket) (release func(), ok bool) {
+	leases := concurrencyLeases(cfg, base, route, clientID, plan)
+	if len(leases) == 0 {
+		return func() {}, true
+	}
+	id := rl.NewLeaseID()
+	held := leases[:0:0]
+	releaseAll := func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), time.Second)
+		defer cancel()
+		for _, l := range held {
+			if err := l.store.Release(ctx, l.key, id); err != nil {
+				log.Debug().Err(err).Str("key", l.key).Msg("lease release failed")
+			}
+		}
+	}
+
+	for _, l := range leases {
+		store, acquired, err := r.acquire(req.Context(), st, l, id)
+		if err != nil {
+			if st.fail(w, "acquire", err) {
+				releaseAll()
+				r.refund(req.Context(), st, charged)
+				return nil, false
+			}
+			continue // on_store_error=allow: this slot is not enforced
+		}
+		if !acquired {
+			releaseAll()
+			r.refund(req.Context(), st, charged)
+			status := base.Concurrency.Status
+			if status == 0 {
+				status = http.StatusTooManyRequests
+			}
+			w.Header().Set("X-StormGate-Denied-By", "concurrency")
+			deny(w, req, cfg, base, route, "concurrency_limited", status, time.Second)
+			metrics.Limited.WithLabelValues(route).Inc()
+			return nil, false
+		}
+		l.store = store
+		held = append(held, l)
+	}
+
+	if len(held) == 0 {
+		return func() {}, true // every acquire failed under on_store_error=allow
+	}
+
+	// Heartbeat: renew well before the shortest lease runs out.
+	every := held[0].ttl
+	for _, l := range held {
+		every = min(every, l.ttl)
+	}
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(every / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				ctx, cancel := context.WithTimeout(context.Background(), every/3)
+				for _, l := range held {
+					if err := l.store.Renew(ctx, l.key, id, l.ttl); err != nil {
+						log.Debug().Err(err).Str("key", l.key).Msg("lease renew failed")
+					}
+				}
+				cancel()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		releaseAll()
+	}, true
+}
+
+// acquire takes one lease in Redis; in local mode a Redis failure (or an
+// earlier one in this request) falls through to the in-memory leases.
+func (r *RateLimiter) acquire(ctx context.Context, st *storeState, l lease, id string) (rl.Backend, bool, error) {
+	if !(st.degraded && st.mode == rl.OnStoreErrorLocal) {
+		ok, _, err := r.L.Acquire(ctx, l.key, l.limit, l.ttl, id)
+		if err == nil || st.mode != rl.OnStoreErrorLocal {
+			return r.L, ok, err
+		}
+		st.note("acquire", err)
+	}
+	ok, _, err := r.Local.Acquire(ctx, l.key, l.limit, l.ttl, id)
+	return r.Local, ok, err
+}
+
+// refund gives back the rate-limit tokens of a request that was not served.
+func (r *RateLimiter) refund(ctx context.Context, st *storeState, charged []rl.Bucket) {
+	adj := make([]rl.Bucket, len(charged))
+	for i, b := range charged {
+		b.Cost = -b.Cost
+		adj[i] = b
+	}
+	store := r.L
+	if st.degraded && st.mode == rl.OnStoreErrorLocal {
+		store = r.Local
+	}
+	if err := store.Adjust(context.WithoutCancel(ctx), adj); err != nil {
+		log.Debug().Err(err).Msg("rate-limit refund failed")
+	}
+}
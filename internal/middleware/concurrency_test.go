@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/skywalker-88/stormgate/internal/rl"
+	"github.com/skywalker-88/stormgate/pkg/config"
+)
+
+// leaseDown is a store whose buckets work but whose leases always fail.
+type leaseDown struct{ rl.Backend }
+
+func (leaseDown) Acquire(context.Context, string, int64, time.Duration, string) (bool, int64, error) {
+	return false, 0, errors.New("acquire: connection refused")
+}
+
+func TestAcquireFailuresFailOpen(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Limits.Default = config.Limit{RPS: 100, Burst: 100, Cost: 1, Concurrency: config.Concurrency{PerClient: 2}}
+	r := NewRateLimiter(leaseDown{rl.NewMemory()}, config.NewHolder(cfg), nil, nil)
+
+	served := false
+	h := r.Limit("/api/data", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		served = true
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/data", nil))
+
+	if !served || w.Code != http.StatusOK {
+		t.Fatalf("served=%v status=%d, want request served (on_store_error=allow)", served, w.Code)
+	}
+}
+
+func TestAcquireFailuresDenyMode(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Limits.Default = config.Limit{RPS: 100, Burst: 100, Cost: 1, OnStoreError: rl.OnStoreErrorDeny,
+		Concurrency: config.Concurrency{PerClient: 2}}
+	r := NewRateLimiter(leaseDown{rl.NewMemory()}, config.NewHolder(cfg), nil, nil)
+
+	h := r.Limit("/api/data", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		t.Fatal("handler ran although the lease store failed in deny mode")
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/data", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status=%d, want 503", w.Code)
+	}
+}
@@ -0,0 +1,279 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	htmltemplate "html/template"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	chimw "github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog/log"
+
+	"github.com/skywalker-88/stormgate/pkg/config"
+)
+
+// denial is what a denial response (and a responses template) can show.
+type denial struct {
+	Reason     string
+	Status     int
+	Title      string
+	Detail     string
+	Route      string
+	Path       string
+	RetryAfter int64 // seconds; 0 when unknown
+	RequestID  string
+}
+
+var denialDetails = map[string]string{
+	"denied":                 "Requests from this client are not accepted.",
+	"blocked":                "Requests from this client are temporarily blocked.",
+	"rate_limited":           "Rate limit exceeded for this route.",
+	"rate_limited_global":    "Rate limit exceeded for this client across all routes.",
+	"rate_limited_aggregate": "Rate limit exceeded for this client's network.",
+	"rate_limited_tenant":    "Rate limit exceeded for this client's organization.",
+	"concurrency_limited":    "Too many requests in flight.",
+	"store_unavailable":      "The rate limiter is unavailable.",
+	"overloaded":             "The service is shedding load.",
+}
+
+// Built-in bodies, in order of preference when Accept allows several.
+var builtinTypes = []string{"application/problem+json", "application/json", "text/html", "text/plain"}
+
+var builtinHTML = htmltemplate.Must(htmltemplate.New("denial").Parse(
+	`<!doctype html><html><head><title>{{.Status}} {{.Title}}</title></head><body>` +
+		`<h1>{{.Title}}</h1><p>{{.Detail}}</p>` +
+		`{{if .RetryAfter}}<p>Retry after {{.RetryAfter}} seconds.</p>{{end}}` +
+		`{{if .RequestID}}<p><small>Request ID: {{.RequestID}}</small></p>{{end}}` +
+		`</body></html>`))
+
+// deny writes the response for a request rejected for reason, using the
+// route's responses entry (else limits.default's) and falling back to
+// status and the built-in bodies.
+func deny(w http.ResponseWriter, req *http.Request, cfg *config.Config, l config.Limit,
+	route, reason string, status int, retry time.Duration) {
+	resp, ok := l.Responses[reason]
+	if !ok {
+		resp = cfg.Limits.Default.Responses[reason]
+	}
+	if resp.Status != 0 {
+		status = resp.Status
+	}
+
+	h := w.Header()
+	h.Set("X-StormGate", "protector")
+	if retry > 0 {
+		h.Set("Retry-After", formatSeconds(retry))
+	}
+	if resp.Redirect != "" {
+		if status < 300 || status > 399 {
+			status = http.StatusFound
+		}
+		http.Redirect(w, req, resp.Redirect, status)
+		return
+	}
+
+	d := denial{
+		Reason:     reason,
+		Status:     status,
+		Title:      http.StatusText(status),
+		Detail:     denialDetails[reason],
+		Route:      route,
+		Path:       req.URL.Path,
+		RetryAfter: int64((retry + time.Second - 1) / time.Second),
+		RequestID:  chimw.GetReqID(req.Context()),
+	}
+
+	var body bytes.Buffer
+	types := slices.Sorted(maps.Keys(resp.Templates))
+	if mt, ok := negotiate(req.Header.Get("Accept"), types); ok {
+		td := d
+		if isJSON(mt) {
+			td = d.jsonEscaped()
+		}
+		if err := templatesFor(cfg).get(mt, resp.Templates[mt]).Execute(&body, td); err != nil {
+			log.Error().Err(err).Str("route", route).Str("reason", reason).Msg("denial template failed")
+			body.Reset()
+		} else {
+			h.Set("Content-Type", mt)
+			w.WriteHeader(status)
+			_, _ = w.Write(body.Bytes())
+			return
+		}
+	}
+
+	// No template the client accepts: a built-in body (the first one if
+	// none is acceptable either).
+	mt, _ := negotiate(req.Header.Get("Accept"), builtinTypes)
+	switch mt {
+	case "text/html":
+		_ = builtinHTML.Execute(&body, d)
+		mt += "; charset=utf-8"
+	case "text/plain":
+		body.WriteString(strconv.Itoa(status) + " " + d.Title + ": " + d.Detail + "\n")
+		if d.RetryAfter > 0 {
+			body.WriteString("Retry after " + strconv.FormatInt(d.RetryAfter, 10) + " seconds.\n")
+		}
+		mt += "; charset=utf-8"
+	default:
+		// RFC 9457 problem details; "error" keeps the reason where older
+		// clients look for it.
+		b, _ := json.Marshal(struct {
+			Type       string `json:"type"`
+			Title      string `json:"title"`
+			Status     int    `json:"status"`
+			Detail     string `json:"detail,omitempty"`
+			Instance   string `json:"instance,omitempty"`
+			Error      string `json:"error"`
+			RetryAfter int64  `json:"retry_after,omitempty"`
+			RequestID  string `json:"request_id,omitempty"`
+		}{"about:blank", d.Title, status, d.Detail, d.Path, reason, d.RetryAfter, d.RequestID})
+		body.Write(b)
+	}
+	h.Set("Content-Type", mt)
+	w.WriteHeader(status)
+	_, _ = w.Write(body.Bytes())
+}
+
+// isJSON reports whether mt is application/json or a +json type.
+func isJSON(mt string) bool {
+	base, _, _ := mime.ParseMediaType(mt)
+	return base == "application/json" || strings.HasSuffix(base, "+json")
+}
+
+// jsonEscaped returns d with its strings escaped for use inside a JSON
+// string literal, so a path with `"` or `\` cannot break a template's body
+// or inject fields.
+func (d denial) jsonEscaped() denial {
+	esc := func(s string) string {
+		b, _ := json.Marshal(s)
+		return string(b[1 : len(b)-1])
+	}
+	d.Reason, d.Title, d.Detail = esc(d.Reason), esc(d.Title), esc(d.Detail)
+	d.Route, d.Path, d.RequestID = esc(d.Route), esc(d.Path), esc(d.RequestID)
+	return d
+}
+
+// negotiate picks the offered media type Accept ranks highest (earlier
+// offers win ties; no Accept takes the first). ok is false when Accept
+// rules out every offer; best is then the first.
+func negotiate(accept string, offers []string) (best string, ok bool) {
+	if len(offers) == 0 {
+		return "", false
+	}
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+	best, bestQ := offers[0], 0.0
+	for _, offer := range offers {
+		if q := acceptQ(accept, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// acceptQ returns the q-value of the most specific range in accept matching mt.
+func acceptQ(accept, mt string) float64 {
+	typ, sub, _ := strings.Cut(mt, "/")
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		rng, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		rt, rs, _ := strings.Cut(rng, "/")
+		s := 0
+		switch {
+		case rt == typ && rs == sub:
+			s = 2
+		case rt == typ && rs == "*":
+			s = 1
+		case rt == "*" && rs == "*":
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, q = s, 1
+		if v, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			q = v
+		}
+	}
+	return q
+}
+
+type executor interface {
+	Execute(io.Writer, any) error
+}
+
+// templateSet holds the compiled responses templates of one config, keyed
+// by media type + "\x00" + body. It is rebuilt when a reload swaps the
+// *Config, so edited templates do not pile up.
+type templateSet struct {
+	c *config.Config
+	m map[string]executor
+}
+
+var templateSets atomic.Pointer[templateSet]
+
+func templatesFor(c *config.Config) *templateSet {
+	if ts := templateSets.Load(); ts != nil && ts.c == c {
+		return ts
+	}
+	ts := &templateSet{c: c, m: map[string]executor{}}
+	add := func(l config.Limit) {
+		for _, resp := range l.Responses {
+			for mt, body := range resp.Templates {
+				ts.m[mt+"\x00"+body] = compile(mt, body)
+			}
+		}
+	}
+	addAll := func(ls config.Limits) {
+		add(ls.Default)
+		for _, l := range ls.Routes {
+			add(l)
+		}
+	}
+	addAll(c.Limits)
+	for _, tier := range c.Plans.Tiers {
+		addAll(tier)
+	}
+	templateSets.Store(ts)
+	return ts
+}
+
+// get returns the compiled template of mt and body (compiling it on the
+// spot should the config not list it).
+func (ts *templateSet) get(mt, body string) executor {
+	if t, ok := ts.m[mt+"\x00"+body]; ok {
+		return t
+	}
+	return compile(mt, body)
+}
+
+// compile parses a responses template (HTML-escaped for text/html; JSON
+// types get pre-escaped values from deny). Templates were checked at
+// config validation, so a parse error here only yields an empty body.
+func compile(mt, body string) executor {
+	var t executor
+	var err error
+	if base, _, _ := mime.ParseMediaType(mt); base == "text/html" {
+		t, err = htmltemplate.New(mt).Parse(body)
+	} else {
+		t, err = template.New(mt).Parse(body)
+	}
+	if err != nil {
+		t = template.Must(template.New(mt).Parse(""))
+	}
+	return t
+}
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skywalker-88/stormgate/pkg/config"
+)
+
+func TestDenyTemplateEscaping(t *testing.T) {
+	l := config.Limit{Responses: map[string]config.Response{
+		"rate_limited": {Templates: map[string]string{
+			"application/problem+json": `{"error":"{{.Reason}}","path":"{{.Path}}","route":"{{.Route}}","retry_after":{{.RetryAfter}}}`,
+			"text/html":                `<p>{{.Path}}</p>`,
+		}},
+	}}
+	path := `/api/x","admin":true,"y":"\`
+	tests := []struct {
+		accept string
+		check  func(t *testing.T, body string)
+	}{
+		{"application/problem+json", func(t *testing.T, body string) {
+			var got map[string]any
+			if err := json.Unmarshal([]byte(body), &got); err != nil {
+				t.Fatalf("invalid JSON %q: %v", body, err)
+			}
+			if _, injected := got["admin"]; injected {
+				t.Fatalf("path injected a field: %q", body)
+			}
+			if got["path"] != path || got["retry_after"] != 2.0 {
+				t.Fatalf("got %v", got)
+			}
+		}},
+		{"text/html", func(t *testing.T, body string) {
+			if strings.Contains(body, `"admin"`) {
+				t.Fatalf("path not HTML-escaped: %q", body)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.URL.Path = path
+			req.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			deny(w, req, &config.Config{}, l, "/api/*", "rate_limited", http.StatusTooManyRequests, 2*time.Second)
+			if w.Code != http.StatusTooManyRequests || w.Header().Get("Content-Type") != tt.accept {
+				t.Fatalf("status=%d content-type=%q", w.Code, w.Header().Get("Content-Type"))
+			}
+			tt.check(t, w.Body.String())
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	templates := []string{"application/problem+json", "text/html"}
+	tests := []struct {
+		accept string
+		offers []string
+		want   string
+		ok     bool
+	}{
+		{"", builtinTypes, "application/problem+json", true},
+		{"text/html", builtinTypes, "text/html", true},
+		{"text/html;q=0.5, text/plain", builtinTypes, "text/plain", true},
+		{"text/*", builtinTypes, "text/html", true},
+		{"*/*", builtinTypes, "application/problem+json", true},
+		{"image/png", builtinTypes, "application/problem+json", false},
+		{"application/json;q=0.9, application/problem+json;q=0.1", builtinTypes, "application/json", true},
+		{"text/plain", templates, "application/problem+json", false},
+		{"text/html;q=0, application/problem+json;q=0", templates, "application/problem+json", false},
+		{"*/*;q=0.1, text/html", templates, "text/html", true},
+	}
+	for _, tt := range tests {
+		if got, ok := negotiate(tt.accept, tt.offers); got != tt.want || ok != tt.ok {
+			t.Errorf("negotiate(%q, %q) = %q, %v, want %q, %v", tt.accept, tt.offers, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDenyFallsBackToBuiltin(t *testing.T) {
+	l := config.Limit{Responses: map[string]config.Response{
+		"rate_limited": {Templates: map[string]string{"text/html": `<p>slow down</p>`}},
+	}}
+	req := httptest.NewRequest(http.MethodGet, "/api/x", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	deny(w, req, &config.Config{}, l, "/api/*", "rate_limited", http.StatusTooManyRequests, 0)
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("content-type = %q, want the built-in application/json", ct)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got["error"] != "rate_limited" {
+		t.Fatalf("body %q: %v", w.Body.String(), err)
+	}
+}
+
+func TestTemplatesPerConfig(t *testing.T) {
+	cfg := func(body string) *config.Config {
+		return &config.Config{Limits: config.Limits{Default: config.Limit{Responses: map[string]config.Response{
+			"rate_limited": {Templates: map[string]string{"text/plain": body}},
+		}}}}
+	}
+	old, cur := cfg("old {{.Reason}}"), cfg("new {{.Reason}}")
+	if _, ok := templatesFor(old).m["text/plain\x00old {{.Reason}}"]; !ok {
+		t.Fatal("old config's template not compiled")
+	}
+	ts := templatesFor(cur)
+	if _, ok := ts.m["text/plain\x00old {{.Reason}}"]; ok {
+		t.Fatal("template of a replaced config kept")
+	}
+	if _, ok := ts.m["text/plain\x00new {{.Reason}}"]; !ok {
+		t.Fatal("new config's template not compiled")
+	}
+	if templatesFor(cur) != ts {
+		t.Fatal("templates recompiled for the same config")
+	}
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/skywalker-88/stormgate/internal/rl"
+	"github.com/skywalker-88/stormgate/pkg/config"
+)
+
+// headerFamilies reports the header families l enables (legacy by default).
+func headerFamilies(l config.Limit) (legacy, ietf bool) {
+	if len(l.Headers) == 0 {
+		return true, false
+	}
+	for _, h := range l.Headers {
+		switch h {
+		case "legacy":
+			legacy = true
+		case "ietf":
+			ietf = true
+		}
+	}
+	return legacy, ietf
+}
+
+// setHeaders writes the rate-limit headers of the route's families.
+//
+// legacy: X-RateLimit-* for the route and X-ClientRateLimit-* for the
+// global bucket, each reporting its most restrictive window.
+//
+// ietf (draft-ietf-httpapi-ratelimit-headers): every bucket charged is a
+// policy, named by scope ("route", "global") and, for the extra windows,
+// their length ("route-86400s"):
+//
+//	RateLimit-Policy: "route";q=100;w=50, "route-86400s";q=5000;w=86400
+//	RateLimit: "route";r=97;t=1, "route-86400s";r=4990;t=86400
+func (c *charge) setHeaders(h http.Header, l config.Limit, results []rl.Result) {
+	legacy, ietf := headerFamilies(l)
+	if legacy {
+		if b, res, ok := c.report("global", results); ok {
+			h.Set("X-ClientRateLimit-Limit", formatFloat(b.Rate.Quota()))
+			h.Set("X-ClientRateLimit-Remaining", formatFloat(res.Remaining))
+			h.Set("X-ClientRateLimit-Reset", formatSeconds(res.ResetAfter))
+		}
+		if b, res, ok := c.report("route", results); ok {
+			h.Set("X-RateLimit-Limit", formatFloat(b.Rate.Quota()))
+			h.Set("X-RateLimit-Remaining", formatFloat(res.Remaining))
+			h.Set("X-RateLimit-Reset", formatSeconds(res.ResetAfter))
+		}
+	}
+	if !ietf {
+		return
+	}
+
+	policies := make([]string, 0, len(c.buckets))
+	states := make([]string, 0, len(c.buckets))
+	for i, b := range c.buckets {
+		quota, window := b.Rate.Policy()
+		name := c.scopes[i]
+		if i > 0 && c.scopes[i-1] == name { // an extra window of the scope
+			name += "-" + formatSeconds(window) + "s"
+		}
+		name = strconv.Quote(name)
+
+		p := name + ";q=" + strconv.FormatInt(quota, 10)
+		if window > 0 {
+			p += ";w=" + formatSeconds(window)
+		}
+		policies = append(policies, p)
+		states = append(states, name+
+			";r="+strconv.FormatInt(int64(results[i].Remaining), 10)+
+			";t="+formatSeconds(results[i].ResetAfter))
+	}
+	h.Set("RateLimit-Policy", strings.Join(policies, ", "))
+	h.Set("RateLimit", strings.Join(states, ", "))
+}
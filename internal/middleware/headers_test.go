@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/skywalker-88/stormgate/internal/rl"
+	"github.com/skywalker-88/stormgate/pkg/config"
+)
+
+func TestSetHeaders(t *testing.T) {
+	global := config.Limit{RPS: 10, Burst: 20, Cost: 1}
+	route := config.Limit{RPS: 50, Burst: 100, Cost: 1, Windows: []config.Window{{Limit: 5000, WindowSeconds: 86400}}}
+	var ch charge
+	ch.add("global", rl.Buckets("g", rl.RateOf(global), global))
+	ch.add("route", rl.Buckets("r", rl.RateOf(route), route))
+
+	results := []rl.Result{
+		{Allowed: true, Remaining: 19, ResetAfter: 100 * time.Millisecond},
+		{Allowed: true, Remaining: 97.5, ResetAfter: 50 * time.Millisecond},
+		{Allowed: true, Remaining: 4990, ResetAfter: 86400 * time.Second},
+	}
+	dailyLow := []rl.Result{results[0], results[1], {Allowed: true, Remaining: 3, ResetAfter: 3600 * time.Second}}
+
+	legacy := map[string]string{
+		"X-ClientRateLimit-Limit":     "10",
+		"X-ClientRateLimit-Remaining": "19",
+		"X-ClientRateLimit-Reset":     "1",
+		"X-RateLimit-Limit":           "50",
+		"X-RateLimit-Remaining":       "97.5",
+		"X-RateLimit-Reset":           "1",
+	}
+	ietf := map[string]string{
+		"RateLimit-Policy": `"global";q=20;w=2, "route";q=100;w=2, "route-86400s";q=5000;w=86400`,
+		"RateLimit":        `"global";r=19;t=1, "route";r=97;t=1, "route-86400s";r=4990;t=86400`,
+	}
+	merge := func(ms ...map[string]string) map[string]string {
+		out := map[string]string{}
+		for _, m := range ms {
+			maps.Copy(out, m)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name    string
+		headers []string
+		results []rl.Result
+		want    map[string]string // headers absent here must be unset
+	}{
+		{"legacy by default", nil, results, legacy},
+		{"ietf only", []string{"ietf"}, results, ietf},
+		{"both", []string{"legacy", "ietf"}, results, merge(legacy, ietf)},
+		{"none", []string{"none"}, results, map[string]string{}},
+		{"route reports its most restrictive window", []string{"legacy"}, dailyLow, merge(legacy, map[string]string{
+			"X-RateLimit-Limit":     "5000",
+			"X-RateLimit-Remaining": "3",
+			"X-RateLimit-Reset":     "3600",
+		})},
+	}
+	all := slices.Collect(maps.Keys(merge(legacy, ietf)))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			ch.setHeaders(h, config.Limit{Headers: tt.headers}, tt.results)
+			for _, k := range all {
+				if got := h.Get(k); got != tt.want[k] {
+					t.Errorf("%s = %q, want %q", k, got, tt.want[k])
+				}
+			}
+		})
+	}
+}
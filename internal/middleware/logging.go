@@ -9,6 +9,8 @@ import (
 
 	chimw "github.com/go-chi/chi/v5/middleware"
 	"github.com/rs/zerolog/log"
+
+	"github.com/skywalker-88/stormgate/internal/identity"
 )
 
 // Options controls access log behavior.
@@ -41,7 +43,10 @@ func AccessLogger(opts Options) func(http.Handler) http.Handler {
 
 			// Chi's RequestID middleware stores the ID in context
 			reqID := chimw.GetReqID(r.Context())
-			remote := r.RemoteAddr // RealIP middleware helps make this accurate
+			remote := r.RemoteAddr
+			if id, ok := identity.FromContext(r.Context()); ok {
+				remote = id.IP // honours identity.trusted_proxies
+			}
 
 			log.Info().
 				Str("method", r.Method).
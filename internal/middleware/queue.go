@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/skywalker-88/stormgate/internal/rl"
+	"github.com/skywalker-88/stormgate/pkg/config"
+	"github.com/skywalker-88/stormgate/pkg/metrics"
+)
+
+const (
+	defaultMaxQueue = 100
+
+	// A client may hold at most 1/clientQueueShare of max_queue (at least
+	// one place), so one client cannot fill a route's queue alone.
+	clientQueueShare = 4
+)
+
+// routeQueue holds one route's delayed requests in arrival order. Only the
+// head charges; the others wait for their turn, so neither later waiters
+// nor new arrivals take the tokens the head is waiting for.
+type routeQueue struct {
+	mu      sync.Mutex
+	waiters list.List      // of *waiter, oldest first
+	clients map[string]int // waiters per client
+}
+
+type waiter struct {
+	client string
+	turn   chan struct{} // closed once the waiter is the head
+}
+
+func (r *RateLimiter) queueOf(route string) *routeQueue {
+	q, _ := r.queues.LoadOrStore(route, &routeQueue{clients: map[string]int{}})
+	return q.(*routeQueue)
+}
+
+// waiting reports whether requests of route are queued.
+func (r *RateLimiter) waiting(route string) bool {
+	q, ok := r.queues.Load(route)
+	if !ok {
+		return false
+	}
+	rq := q.(*routeQueue)
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+	return rq.waiters.Len() > 0
+}
+
+// join queues client unless the queue or the client's share of it is full.
+func (q *routeQueue) join(client string, maxQueue int) (*list.Element, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.waiters.Len() >= maxQueue || q.clients[client] >= max(1, maxQueue/clientQueueShare) {
+		return nil, false
+	}
+	w := &waiter{client: client, turn: make(chan struct{})}
+	e := q.waiters.PushBack(w)
+	q.clients[client]++
+	if e == q.waiters.Front() {
+		close(w.turn)
+	}
+	return e, true
+}
+
+// leave removes e and hands the turn on when e was the head.
+func (q *routeQueue) leave(e *list.Element) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	w := e.Value.(*waiter)
+	head := e == q.waiters.Front()
+	q.waiters.Remove(e)
+	if q.clients[w.client]--; q.clients[w.client] == 0 {
+		delete(q.clients, w.client)
+	}
+	if next := q.waiters.Front(); head && next != nil {
+		close(next.Value.(*waiter).turn)
+	}
+}
+
+// delay holds a denied request while its charge could still fit within
+// l.MaxDelayMs. Waiters of a route are served first come, first served: the
+// head retries after each retry-after (a denied charge commits nothing, so
+// retries are free) and the next one starts when it leaves. results is nil
+// for a request that has not charged yet because others were already
+// waiting. It returns the last decision; err is the context error when the
+// client went away while queued.
+func (r *RateLimiter) delay(ctx context.Context, st *storeState, route, client string, l config.Limit,
+	ch *charge, results []rl.Result) (bool, []rl.Result, error) {
+	maxDelay := time.Duration(l.MaxDelayMs) * time.Millisecond
+	if results != nil {
+		if _, retry := ch.denial(results); retry > maxDelay {
+			return false, results, nil // would time out anyway
+		}
+	}
+
+	maxQueue := l.MaxQueue
+	if maxQueue <= 0 {
+		maxQueue = defaultMaxQueue
+	}
+	q := r.queueOf(route)
+	e, ok := q.join(client, maxQueue)
+	if !ok {
+		metrics.QueueWait.WithLabelValues(route, "full").Observe(0)
+		if results == nil { // not held: decided on the spot
+			return r.consumeAll(ctx, st, ch.buckets)
+		}
+		return false, results, nil
+	}
+	metrics.QueueDepth.WithLabelValues(route).Inc()
+	defer func() {
+		q.leave(e)
+		metrics.QueueDepth.WithLabelValues(route).Dec()
+	}()
+
+	start := time.Now()
+	deadline := start.Add(maxDelay)
+	observe := func(outcome string) {
+		metrics.QueueWait.WithLabelValues(route, outcome).Observe(time.Since(start).Seconds())
+	}
+
+	// Everyone ahead leaves by their own deadline, which is no later than
+	// ours, so waiting for the turn needs no timer of its own.
+	select {
+	case <-ctx.Done():
+		observe("canceled")
+		return false, results, ctx.Err()
+	case <-e.Value.(*waiter).turn:
+	}
+
+	// The head charges at once unless it was denied just before joining
+	// (and the wait for the turn did not already cover the retry-after).
+	first := start
+	if results != nil {
+		_, retry := ch.denial(results)
+		first = start.Add(retry)
+	}
+	t := time.NewTimer(time.Until(first))
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			observe("canceled")
+			return false, results, ctx.Err()
+		case <-t.C:
+		}
+		allowed, res, err := r.consumeAll(ctx, st, ch.buckets)
+		if err != nil {
+			return false, results, err
+		}
+		results = res
+		if allowed {
+			observe("admitted")
+			return true, results, nil
+		}
+		_, retry := ch.denial(results)
+		if time.Now().Add(retry).After(deadline) {
+			observe("timeout")
+			return false, results, nil
+		}
+		t.Reset(max(retry, time.Millisecond))
+	}
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"container/list"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skywalker-88/stormgate/internal/identity"
+	"github.com/skywalker-88/stormgate/internal/rl"
+	"github.com/skywalker-88/stormgate/pkg/config"
+)
+
+func TestRouteQueueOrderAndShare(t *testing.T) {
+	var q routeQueue
+	q.clients = map[string]int{}
+	turn := func(e *list.Element) bool {
+		select {
+		case <-e.Value.(*waiter).turn:
+			return true
+		default:
+			return false
+		}
+	}
+
+	a1, ok1 := q.join("a", 8)
+	a2, ok2 := q.join("a", 8)
+	_, ok3 := q.join("a", 8) // a quarter of 8
+	b1, ok4 := q.join("b", 8)
+	if !ok1 || !ok2 || ok3 || !ok4 {
+		t.Fatalf("joins = %v %v %v %v, want a client capped at 2 of 8", ok1, ok2, ok3, ok4)
+	}
+	if !turn(a1) || turn(a2) || turn(b1) {
+		t.Fatal("only the first waiter may have the turn")
+	}
+
+	q.leave(b1) // leaving from the middle hands nothing on
+	if turn(a2) {
+		t.Fatal("turn handed on by a waiter that was not the head")
+	}
+	q.leave(a1)
+	if !turn(a2) {
+		t.Fatal("turn not handed to the next waiter")
+	}
+	if _, ok := q.join("a", 8); !ok {
+		t.Fatal("client share not released on leave")
+	}
+}
+
+// Delayed requests are admitted in arrival order, and a client past its
+// share of max_queue is denied at once while others can still queue.
+func TestDelayQueue(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Limits.Default = config.Limit{RPS: 20, Burst: 1, Cost: 1, MaxDelayMs: 2000, MaxQueue: 8}
+	r := NewRateLimiter(rl.NewMemory(), config.NewHolder(cfg), nil, nil)
+
+	var mu sync.Mutex
+	var order []string
+	h := r.Limit("/api", http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		order = append(order, req.URL.Query().Get("n"))
+		mu.Unlock()
+	}))
+	serve := func(client, n string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api?n="+n, nil)
+		req = req.WithContext(identity.NewContext(req.Context(), identity.Identity{ID: client}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	first := time.Now()
+	if code := serve("a", "0"); code != http.StatusOK {
+		t.Fatalf("first request: %d", code)
+	}
+	var wg sync.WaitGroup
+	for _, n := range []string{"1", "2"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if code := serve("a", n); code != http.StatusOK {
+				t.Errorf("request %s: %d", n, code)
+			}
+		}()
+		time.Sleep(10 * time.Millisecond) // join in order
+	}
+
+	start := time.Now()
+	if code := serve("a", "3"); code != http.StatusTooManyRequests || time.Since(start) > 40*time.Millisecond {
+		t.Fatalf("request over the client's share: %d after %v, want an immediate 429", code, time.Since(start))
+	}
+	// b has tokens but arrives behind a's waiters, the last of which gets
+	// its token 100ms after the first request.
+	if code := serve("b", "4"); code != http.StatusOK || time.Since(first) < 90*time.Millisecond {
+		t.Fatalf("another client's request: %d after %v, want 200 once a's waiters are through", code, time.Since(first))
+	}
+	wg.Wait()
+
+	// b's handler may run alongside the last of a's, so it can be recorded
+	// either way round.
+	i := slices.Index(order, "4")
+	if i < len(order)-2 {
+		t.Fatalf("admitted %v: b ahead of a's waiters", order)
+	}
+	order = slices.Delete(order, i, i+1)
+	want := []string{"0", "1", "2"}
+	if !slices.Equal(order, want) {
+		t.Fatalf("admitted %v, want %v", order, want)
+	}
+}
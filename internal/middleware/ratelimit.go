@@ -1,189 +1,348 @@
 package middleware
 
 import (
-	"net"
+	"context"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
 
+	"github.com/skywalker-88/stormgate/internal/access"
+	"github.com/skywalker-88/stormgate/internal/identity"
 	"github.com/skywalker-88/stormgate/internal/rl"
 	"github.com/skywalker-88/stormgate/pkg/config"
 	"github.com/skywalker-88/stormgate/pkg/metrics"
 )
 
-const globalKeyPrefix = "rl:global:"
-
 type RateLimiter struct {
-	L   *rl.Limiter
-	Cfg *config.Config
-	Mit rl.Mitigator // mitigation (overrides, blocks)
-}
+	L     rl.Backend     // Redis (shared) or memory (standalone)
+	Local rl.Backend     // per-instance buckets for on_store_error=local, scaled by limiter.replicas
+	Cfg   *config.Holder // live policy; re-read on every request so reloads apply immediately
+	Mit   rl.Mitigator   // mitigation (overrides, blocks)
+	Lists *access.Lists  // allow / deny lists (optional)
 
-func NewRateLimiter(l *rl.Limiter, cfg *config.Config, mit rl.Mitigator) *RateLimiter {
-	return &RateLimiter{L: l, Cfg: cfg, Mit: mit}
+	queues sync.Map // route -> *routeQueue requests waiting for tokens (max_delay_ms)
 }
 
-// ---------- identity / keys ----------
-
-func (r *RateLimiter) clientIDFrom(req *http.Request) string {
-	id := ""
-	src := r.Cfg.Identity.Source
-	if strings.HasPrefix(strings.ToLower(src), "header:") {
-		h := strings.TrimSpace(strings.SplitN(src, ":", 2)[1])
-		if v := req.Header.Get(h); v != "" {
-			id = v
-		}
-	}
-	if id == "" {
-		id = clientIP(req)
-	}
-	if id == "" {
-		id = "anon"
-	}
-	return id
+func NewRateLimiter(l rl.Backend, cfg *config.Holder, mit rl.Mitigator, lists *access.Lists) *RateLimiter {
+	local := rl.Scaled(rl.NewMemory(), func() int { return cfg.Get().Limiter.Replicas })
+	return &RateLimiter{L: l, Local: local, Cfg: cfg, Mit: mit, Lists: lists}
 }
 
-func (r *RateLimiter) rlKey(route, clientID string) string { return "rl:" + route + ":" + clientID }
-func (r *RateLimiter) globalKey(clientID string) string    { return globalKeyPrefix + clientID }
+// ---------- request context ----------
 
-func clientIP(req *http.Request) string {
-	if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		return strings.TrimSpace(parts[0])
-	}
-	host, _, err := net.SplitHostPort(req.RemoteAddr)
-	if err == nil {
-		return host
-	}
-	return req.RemoteAddr
-}
+type routeCtxKey struct{}
 
-func (r *RateLimiter) hasGlobalClientLimit() bool {
-	return r != nil && r.Cfg != nil && (r.Cfg.Limits.GlobalClient.RPS > 0 || r.Cfg.Limits.GlobalClient.Burst > 0)
+// RouteFrom returns the route key the request was limited under ("" when it
+// did not pass the rate limiter).
+func RouteFrom(ctx context.Context) string {
+	route, _ := ctx.Value(routeCtxKey{}).(string)
+	return route
 }
 
 // ---------- main middleware ----------
 
-func (r *RateLimiter) Limit(route string, base config.Limit, next http.Handler) http.Handler {
+// Limit enforces the policy of a fixed route key. The limit itself is looked up
+// per request, so edits to policies.yaml take effect without re-registering routes.
+func (r *RateLimiter) Limit(route string, next http.Handler) http.Handler {
+	return r.LimitBy(func(*config.Config, *http.Request) string { return route }, next)
+}
+
+// LimitBy resolves the route key per request (used for proxied prefixes whose
+// sub-routes come from config and may change on reload).
+func (r *RateLimiter) LimitBy(resolve func(*config.Config, *http.Request) string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		clientID := r.clientIDFrom(req)
+		cfg := r.Cfg.Get()
+		route := resolve(cfg, req)
+		id := identity.Of(cfg, req)
+		clientID := id.ID
+		base := rl.EffectiveLimit(cfg, route, id.Plan)
+
+		allowlisted := r.Lists.Allowed(id)
 
-		allowlisted := rl.IsAllowlisted(r.Cfg, clientID)
+		// Once Redis fails for this request, the route's on_store_error mode decides.
+		st := &storeState{mode: rl.StoreErrorMode(base), route: route, req: req, cfg: cfg, limit: base}
+		defer st.count()
 
-		// 0) Blocks (deny fast) — now SKIPPED for allowlisted clients
+		// 0) Deny list, then blocks (deny fast) — blocks are SKIPPED for allowlisted clients
+		if r.Lists.Denied(id) {
+			w.Header().Set("X-StormGate-Denied-By", "denylist")
+			deny(w, req, cfg, base, route, "denied", http.StatusForbidden, 0)
+			metrics.AccessDenied.WithLabelValues(route).Inc()
+			return
+		}
 		if r.Mit != nil && !allowlisted {
-			if bl, _ := r.Mit.GetBlock(req.Context(), route, clientID); bl != nil {
-				w.Header().Set("X-StormGate", "protector")
+			bl, err := r.Mit.GetBlock(req.Context(), route, clientID)
+			if err != nil && st.fail(w, "block", err) {
+				return
+			}
+			if bl != nil {
+				var retry time.Duration
+				if bl.Exp > 0 {
+					retry = time.Until(time.Unix(bl.Exp, 0))
+				}
 				w.Header().Set("X-StormGate-Block", bl.Reason)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusTooManyRequests) // or 403
-				_, _ = w.Write([]byte(`{"error":"blocked"}`))
+				w.Header().Set("X-StormGate-Denied-By", "block")
+				deny(w, req, cfg, base, route, "blocked", http.StatusTooManyRequests, retry)
 				return
 			}
 		}
 
-		// 1) Route effective limits (apply override with rails)
-		effRPS := base.RPS
-		effBurst := base.Burst
+		// 1) Route effective limits (apply overrides with rails). With adaptive
+		// protection on, a route-wide override may tighten every client too,
+		// each by the same factor of its own limit.
+		rate := rl.RateOf(base)
+		effRPS := rate.RPS
+		effBurst := rate.Burst
 		overrideApplied := false
-		if r.Mit != nil && !allowlisted {
-			if ov, _ := r.Mit.GetOverride(req.Context(), route, clientID); ov != nil {
+		if r.Mit != nil && !allowlisted && !st.degraded {
+			clients := []string{clientID}
+			if cfg.Adaptive.Enabled {
+				clients = append(clients, rl.AllClients)
+			}
+			for _, c := range clients {
+				ov, err := r.Mit.GetOverride(req.Context(), route, c)
+				if err != nil {
+					if st.fail(w, "override", err) {
+						return
+					}
+					break
+				}
+				if ov == nil {
+					continue
+				}
 				overrideApplied = true
-				minRPS := r.Cfg.Mitigation.MinRPS
-				minBurst := int64(r.Cfg.Mitigation.MinBurst)
+				if ov.Factor > 0 {
+					// adaptive: a share of this client's (plan) limit
+					effRPS = min(effRPS, max(1, rate.RPS*ov.Factor))
+					effBurst = min(effBurst, max(1, int64(float64(rate.Burst)*ov.Factor)))
+				}
 				if ov.RPS > 0 && float64(ov.RPS) < effRPS {
 					effRPS = float64(ov.RPS)
 				}
 				if ov.Burst > 0 && int64(ov.Burst) < effBurst {
 					effBurst = int64(ov.Burst)
 				}
-				if effRPS < minRPS {
-					effRPS = minRPS
-				}
-				if effBurst < minBurst {
-					effBurst = minBurst
-				}
+			}
+			if overrideApplied {
+				effRPS = max(effRPS, cfg.Mitigation.MinRPS)
+				effBurst = max(effBurst, int64(cfg.Mitigation.MinBurst))
 			}
 		}
 
-		// 2) Global client effective limits (base only for now)
-		globalEnabled := r.hasGlobalClientLimit()
-		gRPS := r.Cfg.Limits.GlobalClient.RPS
-		gBurst := r.Cfg.Limits.GlobalClient.Burst
-
-		// 3) Consume GLOBAL first (avoid half-consume drift if it denies)
-		if globalEnabled {
-			gKey := r.globalKey(clientID)
-			gAllowed, gRemaining, gRetryAfter, gResetAfter, gErr :=
-				r.L.Consume(req.Context(), gKey, gRPS, gBurst, base.Cost)
-			if gErr != nil {
-				log.Error().Err(gErr).Str("key", gKey).Msg("global limiter error; allowing request")
-			} else {
-				// Always expose global headers when enabled
-				w.Header().Set("X-ClientRateLimit-Limit", formatFloat(gRPS))
-				w.Header().Set("X-ClientRateLimit-Remaining", formatFloat(gRemaining))
-				w.Header().Set("X-ClientRateLimit-Reset", formatDuration(gResetAfter))
-
-				if !gAllowed {
-					if gRetryAfter > 0 {
-						w.Header().Set("Retry-After", formatSeconds(gRetryAfter))
-					}
-					w.Header().Set("X-StormGate", "protector")
-					w.Header().Set("X-StormGate-Denied-By", "global")
-					w.Header().Set("Content-Type", "application/json")
-					w.WriteHeader(http.StatusTooManyRequests)
-					_, _ = w.Write([]byte(`{"error":"rate_limited_global"}`))
-					metrics.Limited.WithLabelValues(route).Inc() // keep route label for continuity
-					return
-				}
-			}
+		rate.RPS, rate.Burst = effRPS, effBurst
+		base.Cost = rl.RequestCost(base, req) // cost_rules / body_cost_per_bytes
+
+		// 2) Everything this request charges: the client's global bucket (if
+		// enabled), the route bucket and the buckets it shares with others
+		// (its networks when known by IP, else its org), each with its extra
+		// windows. One atomic call commits all of them or none, so a route
+		// denial no longer burns global tokens and vice versa.
+		var ch charge
+		tag, shared := ipAggregates(cfg, id)
+		if tag == "" {
+			tag, shared = orgBuckets(cfg, id)
+		}
+		globalKey, routeKey := rl.GlobalKey(clientID), rl.RouteKey(route, clientID)
+		if tag != "" {
+			globalKey, routeKey = rl.TaggedGlobalKey(tag, clientID), rl.TaggedRouteKey(tag, route, clientID)
+		}
+		if gLim, ok := rl.EffectiveGlobalClientLimit(cfg, id.Plan); ok {
+			gLim.Cost = rl.CapCost(gLim, base.Cost) // the route's cost, at most a full global bucket
+			ch.add("global", rl.Buckets(globalKey, rl.RateOf(gLim), gLim))
 		}
+		for _, s := range shared {
+			s.limit.Cost = rl.CapCost(s.limit, base.Cost)
+			ch.add(s.scope, rl.Buckets(s.key, rl.RateOf(s.limit), s.limit))
+		}
+		ch.add("route", rl.Buckets(routeKey, rate, base))
 
-		// 4) Consume ROUTE bucket (existing behavior, now with effective limits)
-		key := r.rlKey(route, clientID)
-		allowed, remaining, retryAfter, resetAfter, err :=
-			r.L.Consume(req.Context(), key, effRPS, effBurst, base.Cost)
+		// With max_delay_ms, requests arriving while others wait for the
+		// route queue behind them rather than take their tokens.
+		var allowed bool
+		var results []rl.Result
+		var err error
+		if base.MaxDelayMs > 0 && r.waiting(route) {
+			allowed, results, err = r.delay(req.Context(), st, route, clientID, base, &ch, nil)
+		} else {
+			allowed, results, err = r.consumeAll(req.Context(), st, ch.buckets)
+			if err == nil && !allowed && base.MaxDelayMs > 0 {
+				allowed, results, err = r.delay(req.Context(), st, route, clientID, base, &ch, results)
+			}
+		}
+		if req.Context().Err() != nil && base.MaxDelayMs > 0 {
+			return // client gave up while queued
+		}
 		if err != nil {
-			log.Error().Err(err).Str("key", key).Msg("limiter error; allowing request")
-			next.ServeHTTP(w, req)
+			if st.fail(w, "consume", err) {
+				return
+			}
+			next.ServeHTTP(w, req) // on_store_error=allow
 			return
 		}
 
-		// 5) Headers & decision
+		// 3) Headers (per the route's header families) & decision
 		w.Header().Set("X-StormGate", "protector")
 		if overrideApplied {
 			w.Header().Set("X-StormGate-Override", "1")
 		}
-		w.Header().Set("X-RateLimit-Limit", formatFloat(effRPS))
-		w.Header().Set("X-RateLimit-Remaining", formatFloat(remaining))
-		w.Header().Set("X-RateLimit-Reset", formatDuration(resetAfter))
+		ch.setHeaders(w.Header(), base, results)
 
 		if !allowed {
-			if retryAfter > 0 {
-				w.Header().Set("Retry-After", formatSeconds(retryAfter))
+			scope, retryAfter := ch.denial(results)
+			reason := "rate_limited"
+			switch {
+			case scope == "global":
+				reason = "rate_limited_global"
+			case strings.HasPrefix(scope, "aggregate/"):
+				reason = "rate_limited_aggregate"
+			case scope == "org" || scope == "org-share":
+				reason = "rate_limited_tenant"
 			}
-			w.Header().Set("X-StormGate-Denied-By", "route")
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusTooManyRequests)
-			_, _ = w.Write([]byte(`{"error":"rate_limited"}`))
-			metrics.Limited.WithLabelValues(route).Inc()
+			w.Header().Set("X-StormGate-Denied-By", scope)
+			deny(w, req, cfg, base, route, reason, http.StatusTooManyRequests, retryAfter)
+			metrics.Limited.WithLabelValues(route).Inc() // route label for global denials too
+			countTenant(cfg, id, reason)
 			return
 		}
+		countTenant(cfg, id, "allowed")
 
+		// 4) In-flight slots (concurrency), held until the response completes
+		release, ok := r.acquireLeases(w, req, st, cfg, base, route, clientID, id.Plan, ch.buckets)
+		if !ok {
+			return
+		}
+		defer release()
+
+		req = req.WithContext(context.WithValue(req.Context(), routeCtxKey{}, route))
+		if base.Reconcile != (config.Reconcile{}) {
+			r.serveReconciled(w, req, next, st, ch.buckets, base.Reconcile, route)
+			return
+		}
 		next.ServeHTTP(w, req)
 	})
 }
 
+// ---------- buckets ----------
+
+// charge collects the buckets of one request, each tagged with the scope
+// that owns it (reported in X-StormGate-Denied-By).
+type charge struct {
+	buckets []rl.Bucket
+	scopes  []string
+}
+
+func (c *charge) add(scope string, buckets []rl.Bucket) {
+	for _, b := range buckets {
+		c.buckets = append(c.buckets, b)
+		c.scopes = append(c.scopes, scope)
+	}
+}
+
+// report returns the most restrictive bucket of scope and its result.
+func (c *charge) report(scope string, res []rl.Result) (rl.Bucket, rl.Result, bool) {
+	var idx []int
+	var sub []rl.Result
+	for i, s := range c.scopes {
+		if s == scope {
+			idx = append(idx, i)
+			sub = append(sub, res[i])
+		}
+	}
+	if len(idx) == 0 {
+		return rl.Bucket{}, rl.Result{}, false
+	}
+	i := idx[rl.MostRestrictive(sub)]
+	return c.buckets[i], res[i], true
+}
+
+// denial names the first scope that denied (in add order) and how long the
+// client must wait until every denying bucket has room again.
+func (c *charge) denial(res []rl.Result) (string, time.Duration) {
+	scope := ""
+	var retry time.Duration
+	for i, r := range res {
+		if r.Allowed {
+			continue
+		}
+		if scope == "" {
+			scope = c.scopes[i]
+		}
+		if r.RetryAfter > retry {
+			retry = r.RetryAfter
+		}
+	}
+	return scope, retry
+}
+
+// ---------- store failures ----------
+
+// storeState tracks whether Redis failed while deciding one request.
+type storeState struct {
+	mode     string
+	route    string
+	degraded bool
+
+	// for the store_unavailable response
+	req   *http.Request
+	cfg   *config.Config
+	limit config.Limit
+}
+
+// note records a store error for op (logged once per request).
+func (st *storeState) note(op string, err error) {
+	if !st.degraded {
+		ev := log.Error()
+		if errors.Is(err, rl.ErrStoreUnavailable) {
+			ev = log.Debug() // breaker open: already reported when it tripped
+		}
+		ev.Err(err).Str("route", st.route).Str("op", op).Str("on_store_error", st.mode).Msg("limiter store error")
+	}
+	st.degraded = true
+}
+
+// fail records a store error for op and, in deny mode, answers 503.
+// It returns true when the response has been written.
+func (st *storeState) fail(w http.ResponseWriter, op string, err error) bool {
+	st.note(op, err)
+	if st.mode != rl.OnStoreErrorDeny {
+		return false
+	}
+	w.Header().Set("X-StormGate-Denied-By", "store")
+	deny(w, st.req, st.cfg, st.limit, st.route, "store_unavailable", http.StatusServiceUnavailable, time.Second)
+	return true
+}
+
+// count exports one degraded decision per affected request.
+func (st *storeState) count() {
+	if st.degraded {
+		metrics.DegradedDecisions.WithLabelValues(st.route, st.mode).Inc()
+	}
+}
+
+// consumeAll charges buckets in Redis; in local mode a Redis failure (or an
+// earlier one in this request) falls through to the in-memory buckets instead.
+func (r *RateLimiter) consumeAll(ctx context.Context, st *storeState, buckets []rl.Bucket) (bool, []rl.Result, error) {
+	if !(st.degraded && st.mode == rl.OnStoreErrorLocal) {
+		allowed, results, err := r.L.ConsumeAll(ctx, buckets)
+		if err == nil || st.mode != rl.OnStoreErrorLocal {
+			return allowed, results, err
+		}
+		st.note("consume", err)
+	}
+	return r.Local.ConsumeAll(ctx, buckets)
+}
+
 // ---------- tiny helpers ----------
 
 func formatFloat(f float64) string {
 	return strings.TrimRight(strings.TrimRight(fmtFloat(f), "0"), ".")
 }
-func fmtFloat(f float64) string             { return strconv.FormatFloat(f, 'f', 3, 64) }
-func formatDuration(d time.Duration) string { return strconv.FormatInt(int64(d/time.Second), 10) }
+func fmtFloat(f float64) string { return strconv.FormatFloat(f, 'f', 3, 64) }
 func formatSeconds(d time.Duration) string {
 	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
 }
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/skywalker-88/stormgate/internal/identity"
+	"github.com/skywalker-88/stormgate/internal/rl"
+	"github.com/skywalker-88/stormgate/pkg/config"
+)
+
+// An adaptive (route-wide) override scales every client's own limit, so a
+// bigger plan stays bigger while the backend is unhealthy.
+func TestAdaptiveFactorScalesPlanLimits(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Adaptive.Enabled = true
+	cfg.Limits.Default = config.Limit{RPS: 10, Burst: 20, Cost: 1}
+	cfg.Plans.Tiers = map[string]config.Limits{"pro": {Default: config.Limit{RPS: 100, Burst: 200, Cost: 1}}}
+	mit := rl.NewMemoryMitigator()
+	_ = mit.SetOverride(context.Background(), "/api", rl.AllClients, rl.Override{Factor: 0.5}, time.Minute)
+	_ = mit.SetOverride(context.Background(), "/api", "capped", rl.Override{RPS: 20, Burst: 20}, time.Minute)
+	r := NewRateLimiter(rl.NewMemory(), config.NewHolder(cfg), mit, nil)
+	h := r.Limit("/api", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	tests := []struct {
+		id        identity.Identity
+		wantLimit string
+	}{
+		{identity.Identity{ID: "free-key"}, "5"},
+		{identity.Identity{ID: "pro-key", Plan: "pro"}, "50"},
+		{identity.Identity{ID: "capped", Plan: "pro"}, "20"}, // a client override still caps
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api", nil)
+		req = req.WithContext(identity.NewContext(req.Context(), tt.id))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if got := w.Header().Get("X-RateLimit-Limit"); got != tt.wantLimit || w.Header().Get("X-StormGate-Override") != "1" {
+			t.Errorf("%s: X-RateLimit-Limit %q (override %q), want %s", tt.id.ID, got, w.Header().Get("X-StormGate-Override"), tt.wantLimit)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/skywalker-88/stormgate/internal/rl"
+	"github.com/skywalker-88/stormgate/pkg/config"
+	"github.com/skywalker-88/stormgate/pkg/metrics"
+)
+
+// serveReconciled runs next and then corrects the up-front charge of buckets
+// by what the response turned out to cost (limits.*.reconcile). The client
+// already has its response, so the adjustment runs in the background.
+func (r *RateLimiter) serveReconciled(w http.ResponseWriter, req *http.Request, next http.Handler,
+	st *storeState, buckets []rl.Bucket, rc config.Reconcile, route string) {
+	m := &responseMeter{ResponseWriter: w, code: http.StatusOK}
+	start := time.Now()
+	next.ServeHTTP(m, req)
+	took := time.Since(start)
+
+	var extra, refund int64
+	if rc.RefundCached > 0 && (m.code == http.StatusNotModified ||
+		strings.HasPrefix(strings.ToUpper(w.Header().Get("X-Cache")), "HIT")) {
+		refund = rc.RefundCached
+	} else {
+		if rc.SlowMs > 0 {
+			extra += int64(took/(time.Duration(rc.SlowMs)*time.Millisecond)) * rc.SlowCost
+		}
+		if rc.LargeBytes > 0 {
+			extra += m.bytes / rc.LargeBytes * rc.LargeCost
+		}
+	}
+	if extra == 0 && refund == 0 {
+		return
+	}
+
+	adj := make([]rl.Bucket, len(buckets))
+	refunded := int64(0)
+	for i, b := range buckets {
+		back := min(refund, b.Cost) // never refund more than was charged
+		refunded = max(refunded, back)
+		b.Cost = extra - back
+		adj[i] = b
+	}
+	if extra > 0 {
+		metrics.CostAdjusted.WithLabelValues(route, "extra").Add(float64(extra))
+	} else {
+		metrics.CostAdjusted.WithLabelValues(route, "refund").Add(float64(refunded))
+	}
+
+	backend := r.L
+	if st.degraded && st.mode == rl.OnStoreErrorLocal {
+		backend = r.Local
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := backend.Adjust(ctx, adj); err != nil {
+			log.Debug().Err(err).Str("route", route).Msg("cost reconcile failed")
+		}
+	}()
+}
+
+// responseMeter records the status and body size of a response.
+type responseMeter struct {
+	http.ResponseWriter
+	code  int
+	bytes int64
+}
+
+func (m *responseMeter) WriteHeader(code int) {
+	m.code = code
+	m.ResponseWriter.WriteHeader(code)
+}
+
+func (m *responseMeter) Write(p []byte) (int, error) {
+	n, err := m.ResponseWriter.Write(p)
+	m.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController (used by the reverse proxy to flush
+// streamed responses) reach the underlying writer.
+func (m *responseMeter) Unwrap() http.ResponseWriter { return m.ResponseWriter }
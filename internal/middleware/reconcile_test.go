@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skywalker-88/stormgate/internal/rl"
+	"github.com/skywalker-88/stormgate/pkg/config"
+)
+
+// adjustRecorder records the deltas handed to Adjust.
+type adjustRecorder struct {
+	rl.Backend
+	got chan []int64
+}
+
+func (a adjustRecorder) Adjust(_ context.Context, buckets []rl.Bucket) error {
+	deltas := make([]int64, len(buckets))
+	for i, b := range buckets {
+		deltas[i] = b.Cost
+	}
+	a.got <- deltas
+	return nil
+}
+
+func TestServeReconciled(t *testing.T) {
+	tests := []struct {
+		name    string
+		rc      config.Reconcile
+		handler http.HandlerFunc
+		want    []int64 // nil: no adjustment
+	}{
+		{"not modified refunds up to the charge", config.Reconcile{RefundCached: 3},
+			func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusNotModified) },
+			[]int64{-2, -3}},
+		{"cache hit refunds", config.Reconcile{RefundCached: 1, LargeBytes: 1, LargeCost: 1},
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("X-Cache", "hit from edge")
+				_, _ = w.Write([]byte("cached body"))
+			},
+			[]int64{-1, -1}},
+		{"large body charges per large_bytes", config.Reconcile{LargeBytes: 10, LargeCost: 2},
+			func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte(strings.Repeat("x", 25))) },
+			[]int64{4, 4}},
+		{"slow response charges per slow_ms", config.Reconcile{SlowMs: 30, SlowCost: 5},
+			func(http.ResponseWriter, *http.Request) { time.Sleep(35 * time.Millisecond) },
+			[]int64{5, 5}},
+		{"cheap response is left alone", config.Reconcile{SlowMs: 1000, SlowCost: 5, LargeBytes: 100, LargeCost: 1},
+			func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte("ok")) },
+			nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := adjustRecorder{rl.NewMemory(), make(chan []int64, 1)}
+			r := NewRateLimiter(rec, config.NewHolder(&config.Config{}), nil, nil)
+			buckets := []rl.Bucket{{Key: "g", Cost: 2}, {Key: "r", Cost: 5}}
+			r.serveReconciled(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil),
+				tt.handler, &storeState{}, buckets, tt.rc, "/api")
+
+			var got []int64
+			select {
+			case got = <-rec.got:
+			case <-time.After(100 * time.Millisecond):
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("adjusted %v, want %v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Fatalf("adjusted %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
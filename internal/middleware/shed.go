@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/skywalker-88/stormgate/internal/identity"
+	"github.com/skywalker-88/stormgate/internal/rl"
+	"github.com/skywalker-88/stormgate/pkg/config"
+	"github.com/skywalker-88/stormgate/pkg/metrics"
+)
+
+// Shedder drops low-priority requests first when this instance is busy.
+// All routes share one utilization signal: requests in flight through the
+// shedder over shedding.max_in_flight.
+type Shedder struct {
+	Cfg      *config.Holder
+	inFlight atomic.Int64
+}
+
+func NewShedder(cfg *config.Holder) *Shedder {
+	return &Shedder{Cfg: cfg}
+}
+
+// Utilization is the share of shedding.max_in_flight currently in use.
+func (s *Shedder) Utilization() float64 {
+	limit := s.Cfg.Get().Shedding.MaxInFlight
+	if limit <= 0 {
+		return 0
+	}
+	return float64(s.inFlight.Load()) / float64(limit)
+}
+
+// Shed runs in front of the rate limiter, so a shed request is never charged.
+func (s *Shedder) Shed(resolve func(*config.Config, *http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		cfg := s.Cfg.Get()
+		sh := cfg.Shedding
+		n := s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+		if !sh.Enabled || sh.MaxInFlight <= 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		util := float64(n) / float64(sh.MaxInFlight)
+		metrics.ShedUtilization.Set(util)
+		route := resolve(cfg, req)
+		class, ok := requestClass(cfg, req, route)
+		if !ok || util <= class.ShedAt {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		status := sh.Status
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("X-StormGate-Denied-By", "shed")
+		deny(w, req, cfg, rl.EffectiveLimit(cfg, route, identity.Of(cfg, req).Plan), route, "overloaded", status, time.Second)
+		metrics.Shed.WithLabelValues(route, class.Name).Inc()
+	})
+}
+
+// requestClass picks the shedding class of req. A class named by
+// shedding.header wins; otherwise the request is as sheddable as the less
+// important of its caller's class (identity.priority) and its route's
+// (limits.*.priority), where set, else shedding.default_class. Without any
+// class the request is never shed.
+func requestClass(cfg *config.Config, req *http.Request, route string) (config.ShedClass, bool) {
+	classes := cfg.Shedding.Classes
+	index := func(name string) int {
+		for i, c := range classes {
+			if c.Name == name {
+				return i
+			}
+		}
+		return -1
+	}
+	if h := cfg.Shedding.Header; h != "" {
+		if i := index(req.Header.Get(h)); i >= 0 {
+			return classes[i], true
+		}
+	}
+
+	caller := ""
+	id := identity.Of(cfg, req)
+	if id.Anonymous {
+		caller = cfg.Identity.Priority.Anonymous
+	} else {
+		caller = cfg.Identity.Priority.Clients[id.ID]
+	}
+	work := rl.EffectiveLimit(cfg, route, id.Plan).Priority
+	if caller == "" && work == "" {
+		caller = cfg.Shedding.DefaultClass
+	}
+	// Later classes are less important.
+	i := max(index(caller), index(work))
+	if i < 0 {
+		return config.ShedClass{}, false
+	}
+	return classes[i], true
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/skywalker-88/stormgate/internal/identity"
+	"github.com/skywalker-88/stormgate/pkg/config"
+)
+
+func shedConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.Shedding = config.Shedding{
+		Enabled:     true,
+		MaxInFlight: 10,
+		Classes: []config.ShedClass{
+			{Name: "critical", ShedAt: 1.5},
+			{Name: "normal", ShedAt: 0.8},
+			{Name: "batch", ShedAt: 0.5},
+		},
+		DefaultClass: "normal",
+		Header:       "X-Priority",
+	}
+	cfg.Identity.Priority = config.IdentityPriority{
+		Anonymous: "batch",
+		Clients:   map[string]string{"ops": "critical", "crawler": "batch"},
+	}
+	cfg.Limits.Routes = map[string]config.Limit{
+		"/reports": {Priority: "batch"},
+		"/health":  {Priority: "critical"},
+	}
+	return cfg
+}
+
+func TestRequestClass(t *testing.T) {
+	cfg := shedConfig()
+	tests := []struct {
+		name   string
+		id     identity.Identity
+		route  string
+		header string
+		want   string
+	}{
+		{"default class", identity.Identity{ID: "app"}, "/api", "", "normal"},
+		{"anonymous caller", identity.Identity{ID: "10.0.0.1", Anonymous: true}, "/api", "", "batch"},
+		{"caller class", identity.Identity{ID: "ops"}, "/api", "", "critical"},
+		{"route class", identity.Identity{ID: "app"}, "/reports", "", "batch"},
+		{"less important of caller and route", identity.Identity{ID: "ops"}, "/reports", "", "batch"},
+		{"less important of route and caller", identity.Identity{ID: "crawler"}, "/health", "", "batch"},
+		{"header wins", identity.Identity{ID: "crawler"}, "/reports", "critical", "critical"},
+		{"unknown header class ignored", identity.Identity{ID: "app"}, "/api", "vip", "normal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.route, nil)
+			req = req.WithContext(identity.NewContext(req.Context(), tt.id))
+			if tt.header != "" {
+				req.Header.Set("X-Priority", tt.header)
+			}
+			c, ok := requestClass(cfg, req, tt.route)
+			if !ok || c.Name != tt.want {
+				t.Fatalf("class = %q (%v), want %q", c.Name, ok, tt.want)
+			}
+		})
+	}
+
+	cfg.Shedding.DefaultClass = ""
+	req := httptest.NewRequest(http.MethodGet, "/api", nil)
+	req = req.WithContext(identity.NewContext(req.Context(), identity.Identity{ID: "app"}))
+	if c, ok := requestClass(cfg, req, "/api"); ok {
+		t.Fatalf("class %q without any configured class, want none (never shed)", c.Name)
+	}
+}
+
+// A request is shed once utilization (counting itself) passes its class's
+// shed_at, so less important classes go first.
+func TestShedThresholds(t *testing.T) {
+	tests := []struct {
+		inFlight int64 // before the request
+		class    string
+		shed     bool
+	}{
+		{4, "batch", false}, // 0.5
+		{5, "batch", true},  // 0.6
+		{5, "normal", false},
+		{7, "normal", false}, // 0.8
+		{8, "normal", true},  // 0.9
+		{12, "critical", false},
+		{15, "critical", true}, // 1.6
+	}
+	for _, tt := range tests {
+		s := NewShedder(config.NewHolder(shedConfig()))
+		s.inFlight.Store(tt.inFlight)
+		served := false
+		h := s.Shed(func(*config.Config, *http.Request) string { return "/api" },
+			http.HandlerFunc(func(http.ResponseWriter, *http.Request) { served = true }))
+		req := httptest.NewRequest(http.MethodGet, "/api", nil)
+		req.Header.Set("X-Priority", tt.class)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if served == tt.shed {
+			t.Errorf("%s at %d in flight: served=%v, want shed=%v", tt.class, tt.inFlight+1, served, tt.shed)
+		}
+		if tt.shed && (w.Code != http.StatusServiceUnavailable || w.Header().Get("X-StormGate-Denied-By") != "shed") {
+			t.Errorf("%s shed with status %d, denied by %q", tt.class, w.Code, w.Header().Get("X-StormGate-Denied-By"))
+		}
+		if got := s.inFlight.Load(); got != tt.inFlight {
+			t.Errorf("in flight %d after the request, want %d", got, tt.inFlight)
+		}
+	}
+}
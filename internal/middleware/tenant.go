@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/skywalker-88/stormgate/internal/identity"
+	"github.com/skywalker-88/stormgate/internal/rl"
+	"github.com/skywalker-88/stormgate/pkg/config"
+	"github.com/skywalker-88/stormgate/pkg/metrics"
+)
+
+const defaultMetricsMaxTenants = 50
+
+// orgBuckets returns the buckets of the client's org and the hash tag its
+// own buckets must share with them: the org-wide bucket and, with fairness
+// split, the client's share of it.
+func orgBuckets(cfg *config.Config, id identity.Identity) (tag string, out []sharedBucket) {
+	if !cfg.Tenants.Enabled || id.Org == "" || id.Anonymous {
+		return "", nil
+	}
+	q, ok := cfg.Tenants.Orgs[id.Org]
+	if !ok {
+		q = cfg.Tenants.Default
+	}
+	tag = rl.OrgTag(id.Org)
+	out = append(out, sharedBucket{scope: "org", key: rl.OrgKey(tag), limit: q.Limit})
+	if q.Fairness == "split" {
+		ways := q.SplitWays
+		if ways <= 0 {
+			ways = orgKeys(cfg)[id.Org]
+		}
+		out = append(out, sharedBucket{scope: "org-share", key: rl.OrgShareKey(tag, id.ID), limit: share(q.Limit, ways)})
+	}
+	return tag, out
+}
+
+// share is one of ways equal parts of l (each at least 1 token).
+func share(l config.Limit, ways int) config.Limit {
+	if ways <= 1 {
+		return l
+	}
+	l.RPS /= float64(ways)
+	l.Burst = max(1, l.Burst/int64(ways))
+	windows := make([]config.Window, len(l.Windows))
+	for i, w := range l.Windows {
+		w.Limit = max(1, w.Limit/int64(ways))
+		windows[i] = w
+	}
+	l.Windows = windows
+	return l
+}
+
+type keyCounts struct {
+	c *config.Config
+	n map[string]int // org -> keys mapped to it in tenants.keys
+}
+
+var orgKeyCounts atomic.Pointer[keyCounts]
+
+func orgKeys(cfg *config.Config) map[string]int {
+	if kc := orgKeyCounts.Load(); kc != nil && kc.c == cfg {
+		return kc.n
+	}
+	kc := &keyCounts{c: cfg, n: map[string]int{}}
+	for _, org := range cfg.Tenants.Keys {
+		kc.n[org]++
+	}
+	orgKeyCounts.Store(kc)
+	return kc.n
+}
+
+// ---- tenant metrics ----
+
+// countTenant counts a rate-limit decision for the client's org.
+func countTenant(cfg *config.Config, id identity.Identity, outcome string) {
+	if cfg.Tenants.Enabled && id.Org != "" && !id.Anonymous {
+		metrics.TenantRequests.WithLabelValues(tenantLabel(cfg, id.Org), outcome).Inc()
+	}
+}
+
+var (
+	tenantLabels sync.Map // org -> struct{}: dynamic orgs that got their own label
+	tenantCount  atomic.Int64
+)
+
+// tenantLabel bounds the tenant label: orgs in tenants.orgs keep their
+// name, other orgs too until metrics_max_tenants of them have been seen;
+// the rest share "other".
+func tenantLabel(cfg *config.Config, org string) string {
+	if _, ok := cfg.Tenants.Orgs[org]; ok {
+		return org
+	}
+	if _, ok := tenantLabels.Load(org); ok {
+		return org
+	}
+	limit := int64(cfg.Tenants.MetricsMaxTenants)
+	if limit <= 0 {
+		limit = defaultMetricsMaxTenants
+	}
+	if tenantCount.Add(1) > limit {
+		tenantCount.Add(-1)
+		return "other"
+	}
+	if _, loaded := tenantLabels.LoadOrStore(org, struct{}{}); loaded {
+		tenantCount.Add(-1)
+	}
+	return org
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/skywalker-88/stormgate/internal/identity"
+	"github.com/skywalker-88/stormgate/internal/rl"
+	"github.com/skywalker-88/stormgate/pkg/config"
+)
+
+func tenantConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.Limits.Default = config.Limit{RPS: 100, Burst: 100, Cost: 1}
+	cfg.Tenants = config.Tenants{
+		Enabled: true,
+		Keys:    map[string]string{"a1": "acme", "a2": "acme", "a3": "acme", "g1": "globex"},
+		Default: config.TenantQuota{Limit: config.Limit{RPS: 1, Burst: 4}},
+		Orgs: map[string]config.TenantQuota{
+			"acme":   {Limit: config.Limit{RPS: 1, Burst: 6}, Fairness: "split"},
+			"globex": {Limit: config.Limit{RPS: 1, Burst: 8, Windows: []config.Window{{Limit: 100, WindowSeconds: 3600}}}, Fairness: "split", SplitWays: 4},
+		},
+	}
+	return cfg
+}
+
+func TestOrgBuckets(t *testing.T) {
+	cfg := tenantConfig()
+	tests := []struct {
+		name       string
+		id         identity.Identity
+		shareKey   string // "" for shared fairness
+		orgBurst   int64
+		shareBurst int64
+	}{
+		{"shared (default quota)", identity.Identity{ID: "i1", Org: "initech"}, "", 4, 0},
+		{"split by keys in tenants.keys", identity.Identity{ID: "a1", Org: "acme"}, "rl:{org:acme}:a1:share", 6, 2},
+		{"split_ways", identity.Identity{ID: "g1", Org: "globex"}, "rl:{org:globex}:g1:share", 8, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tag, out := orgBuckets(cfg, tt.id)
+			if tag != rl.OrgTag(tt.id.Org) || len(out) == 0 || out[0].key != rl.OrgKey(tag) || out[0].limit.Burst != tt.orgBurst {
+				t.Fatalf("tag %q, buckets %+v", tag, out)
+			}
+			if tt.shareKey == "" {
+				if len(out) != 1 {
+					t.Fatalf("shared org got %d buckets, want the org bucket only", len(out))
+				}
+				return
+			}
+			if len(out) != 2 || out[1].scope != "org-share" || out[1].key != tt.shareKey || out[1].limit.Burst != tt.shareBurst {
+				t.Fatalf("share bucket %+v, want %s with burst %d", out[1:], tt.shareKey, tt.shareBurst)
+			}
+		})
+	}
+
+	for _, id := range []identity.Identity{
+		{ID: "nobody"},
+		{ID: "10.0.0.1", Org: "acme", Anonymous: true},
+	} {
+		if tag, out := orgBuckets(cfg, id); tag != "" || out != nil {
+			t.Errorf("%+v: tag %q with %d buckets, want none", id, tag, len(out))
+		}
+	}
+}
+
+func TestShare(t *testing.T) {
+	l := config.Limit{RPS: 10, Burst: 9, Windows: []config.Window{{Limit: 2, WindowSeconds: 60}}}
+	got := share(l, 4)
+	if got.RPS != 2.5 || got.Burst != 2 || got.Windows[0].Limit != 1 {
+		t.Fatalf("share(l, 4) = %+v", got)
+	}
+	if l.Windows[0].Limit != 2 {
+		t.Fatal("share modified the org's windows")
+	}
+	if got := share(l, 1); got.Burst != 9 {
+		t.Fatalf("share(l, 1) = %+v, want l", got)
+	}
+}
+
+// Keys of a shared org draw from one pool; with split fairness each key is
+// also held to its share, leaving room for the others.
+func TestTenantFairness(t *testing.T) {
+	tests := []struct {
+		fairness string
+		allowed  int    // requests of the first key before a denial
+		deniedBy string // of the first key's denial
+		second   int    // status of the second key's next request
+	}{
+		{"shared", 6, "org", http.StatusTooManyRequests},
+		{"split", 2, "org-share", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fairness, func(t *testing.T) {
+			cfg := tenantConfig()
+			q := cfg.Tenants.Orgs["acme"]
+			q.Fairness = tt.fairness
+			cfg.Tenants.Orgs["acme"] = q
+			r := NewRateLimiter(rl.NewMemory(), config.NewHolder(cfg), nil, nil)
+			h := r.Limit("/api", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+			serve := func(key string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, "/api", nil)
+				req = req.WithContext(identity.NewContext(req.Context(), identity.Identity{ID: key, Org: "acme"}))
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, req)
+				return w
+			}
+
+			for i := range tt.allowed {
+				if w := serve("a1"); w.Code != http.StatusOK {
+					t.Fatalf("request %d: %d", i+1, w.Code)
+				}
+			}
+			w := serve("a1")
+			if w.Code != http.StatusTooManyRequests || w.Header().Get("X-StormGate-Denied-By") != tt.deniedBy {
+				t.Fatalf("request %d: %d denied by %q, want 429 by %s", tt.allowed+1, w.Code, w.Header().Get("X-StormGate-Denied-By"), tt.deniedBy)
+			}
+			if w := serve("a2"); w.Code != tt.second {
+				t.Fatalf("another key of the org: %d, want %d", w.Code, tt.second)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+// Package plans maps clients to their plan (plans.tiers), whose limits
+// rl.EffectiveLimit resolves. The mapping comes from the policy file, a
+// mapping file and a Redis hash; the last two are re-read in the
+// background, so a plan change applies within plans.refresh_seconds.
+package plans
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"maps"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+
+	"github.com/skywalker-88/stormgate/internal/identity"
+	"github.com/skywalker-88/stormgate/pkg/config"
+	"github.com/skywalker-88/stormgate/pkg/metrics"
+)
+
+// DefaultAnonymous is the plan of clients no source maps.
+const DefaultAnonymous = "anonymous"
+
+// Resolver serves the client -> plan mapping of the live config.
+type Resolver struct {
+	cfg *config.Holder
+	rdb redis.UniversalClient // nil: plans.redis_hash is skipped
+
+	cur  atomic.Pointer[map[string]string]
+	file mappingFile       // refresh goroutine only
+	hash map[string]string // last plans.redis_hash read; refresh goroutine only
+	bad  int               // clients mapped to unknown plans at the last refresh
+	kick chan struct{}
+	stop chan struct{}
+}
+
+type mappingFile struct {
+	path string
+	mod  time.Time
+	size int64
+	keys map[string]string
+}
+
+// NewResolver loads the mapping once before returning and then keeps it
+// fresh until Close.
+func NewResolver(cfg *config.Holder, rdb redis.UniversalClient) *Resolver {
+	p := &Resolver{cfg: cfg, rdb: rdb, kick: make(chan struct{}, 1), stop: make(chan struct{})}
+	p.refresh()
+	cfg.OnChange(func(*config.Config) {
+		select {
+		case p.kick <- struct{}{}:
+		default:
+		}
+	})
+	go p.loop()
+	return p
+}
+
+func (p *Resolver) Close() { close(p.stop) }
+
+// Of returns the plan of id: its mapping, else plans.anonymous.
+func (p *Resolver) Of(c *config.Config, id identity.Identity) string {
+	if p != nil {
+		if plan, ok := (*p.cur.Load())[id.ID]; ok {
+			return plan
+		}
+	}
+	if c.Plans.Anonymous != "" {
+		return c.Plans.Anonymous
+	}
+	return DefaultAnonymous
+}
+
+// Middleware sets the plan on the identity attached by identity.Middleware.
+func (p *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c := p.cfg.Get()
+		id := identity.Of(c, req)
+		id.Plan = p.Of(c, id)
+		next.ServeHTTP(w, req.WithContext(identity.NewContext(req.Context(), id)))
+	})
+}
+
+func (p *Resolver) loop() {
+	for {
+		every := time.Duration(p.cfg.Get().Plans.RefreshSeconds) * time.Second
+		if every <= 0 {
+			every = 10 * time.Second
+		}
+		t := time.NewTimer(every)
+		select {
+		case <-p.stop:
+			t.Stop()
+			return
+		case <-p.kick:
+			t.Stop()
+		case <-t.C:
+		}
+		p.refresh()
+	}
+}
+
+// refresh merges the sources; later ones win (keys, file, redis_hash).
+func (p *Resolver) refresh() {
+	c := p.cfg.Get().Plans
+	keys := maps.Clone(c.Keys)
+	if keys == nil {
+		keys = map[string]string{}
+	}
+	maps.Copy(keys, p.readFile(c.File))
+	maps.Copy(keys, p.readHash(c.RedisHash))
+
+	counts, unknown := map[string]int{}, 0
+	for id, plan := range keys {
+		if _, ok := c.Tiers[plan]; !ok {
+			delete(keys, id)
+			unknown++
+			continue
+		}
+		counts[plan]++
+	}
+	if unknown > 0 && unknown != p.bad {
+		log.Warn().Int("clients", unknown).Msg("clients mapped to unknown plans get the anonymous plan")
+	}
+	p.bad = unknown
+	metrics.PlanClients.Reset()
+	for plan := range c.Tiers {
+		metrics.PlanClients.WithLabelValues(plan).Set(float64(counts[plan]))
+	}
+	p.cur.Store(&keys)
+}
+
+// readFile returns the mapping in path, parsing it again only when it
+// changed; an unreadable file keeps its last mapping.
+func (p *Resolver) readFile(path string) map[string]string {
+	if path == "" {
+		p.file = mappingFile{}
+		return nil
+	}
+	prev := p.file
+	if prev.path != path {
+		prev = mappingFile{}
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		log.Warn().Err(err).Str("file", path).Msg("plans file unreadable; keeping last mapping")
+		return prev.keys
+	}
+	if prev.keys != nil && st.ModTime().Equal(prev.mod) && st.Size() == prev.size {
+		return prev.keys
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		log.Warn().Err(err).Str("file", path).Msg("plans file unreadable; keeping last mapping")
+		return prev.keys
+	}
+	f := mappingFile{path: path, mod: st.ModTime(), size: st.Size(), keys: map[string]string{}}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 0:
+		case 2:
+			f.keys[fields[0]] = fields[1]
+		default:
+			log.Warn().Str("file", path).Int("line", n).Msg("plans file: want \"<client ID> <plan>\"; line skipped")
+		}
+	}
+	if prev.keys != nil {
+		log.Info().Str("file", path).Int("clients", len(f.keys)).Msg("plans file reloaded")
+	}
+	p.file = f
+	return f.keys
+}
+
+// readHash returns the mapping in a Redis hash; on errors the last mapping
+// read is kept.
+func (p *Resolver) readHash(key string) map[string]string {
+	if key == "" || p.rdb == nil {
+		p.hash = nil
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	m, err := p.rdb.HGetAll(ctx, key).Result()
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("plans hash unreadable; keeping last mapping")
+		return p.hash
+	}
+	p.hash = m
+	return m
+}
@@ -0,0 +1,111 @@
+package plans
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/skywalker-88/stormgate/internal/identity"
+	"github.com/skywalker-88/stormgate/pkg/config"
+)
+
+// newTestResolver returns a resolver without its refresh loop; tests call
+// refresh themselves.
+func newTestResolver(c *config.Config, rdb redis.UniversalClient) *Resolver {
+	p := &Resolver{cfg: config.NewHolder(c), rdb: rdb}
+	p.refresh()
+	return p
+}
+
+func planOf(p *Resolver, client string) string {
+	return p.Of(p.cfg.Get(), identity.Identity{ID: client})
+}
+
+func writeFile(t *testing.T, path, body string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Later sources win: keys, then the file, then the Redis hash (when
+// STORMGATE_TEST_REDIS names a Redis). Unknown plans and unmapped clients
+// get plans.anonymous.
+func TestRefreshPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "plans.txt")
+	writeFile(t, file, "b pro\nc pro # upgraded\nmalformed line here\n")
+	c := &config.Config{Plans: config.Plans{
+		Keys:  map[string]string{"a": "free", "b": "free", "c": "free", "x": "gold"},
+		File:  file,
+		Tiers: map[string]config.Limits{"free": {}, "pro": {}, "enterprise": {}},
+	}}
+
+	var rdb redis.UniversalClient
+	want := map[string]string{"a": "free", "b": "pro", "c": "pro", "x": DefaultAnonymous, "nobody": DefaultAnonymous}
+	if addr := os.Getenv("STORMGATE_TEST_REDIS"); addr != "" {
+		client := redis.NewClient(&redis.Options{Addr: addr})
+		t.Cleanup(func() { _ = client.Close() })
+		c.Plans.RedisHash = "planstest:" + strconv.FormatInt(time.Now().UnixNano(), 36)
+		if err := client.HSet(context.Background(), c.Plans.RedisHash, "c", "enterprise").Err(); err != nil {
+			t.Fatalf("STORMGATE_TEST_REDIS=%s: %v", addr, err)
+		}
+		t.Cleanup(func() { client.Del(context.Background(), c.Plans.RedisHash) })
+		rdb = client
+		want["c"] = "enterprise"
+	}
+
+	p := newTestResolver(c, rdb)
+	for client, plan := range want {
+		if got := planOf(p, client); got != plan {
+			t.Errorf("plan of %s = %q, want %q", client, got, plan)
+		}
+	}
+
+	c2 := *c
+	c2.Plans.Anonymous = "free"
+	p.cfg.Swap(&c2)
+	if got := planOf(p, "nobody"); got != "free" {
+		t.Errorf("unmapped client with plans.anonymous set: %q, want free", got)
+	}
+}
+
+// The mapping file is re-read when it changes; a file that goes missing
+// keeps its last mapping.
+func TestRefreshFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "plans.txt")
+	writeFile(t, file, "a free\n")
+	p := newTestResolver(&config.Config{Plans: config.Plans{
+		File:  file,
+		Tiers: map[string]config.Limits{"free": {}, "pro": {}},
+	}}, nil)
+	if got := planOf(p, "a"); got != "free" {
+		t.Fatalf("plan of a = %q, want free", got)
+	}
+
+	writeFile(t, file, "a pro\nb pro\n")
+	p.refresh()
+	if got := planOf(p, "a"); got != "pro" {
+		t.Fatalf("plan of a after the file changed = %q, want pro", got)
+	}
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	p.refresh()
+	if got := planOf(p, "b"); got != "pro" {
+		t.Fatalf("plan of b with the file gone = %q, want the last mapping (pro)", got)
+	}
+
+	c := *p.cfg.Get()
+	c.Plans.File = ""
+	p.cfg.Swap(&c)
+	p.refresh()
+	if got := planOf(p, "b"); got != DefaultAnonymous {
+		t.Fatalf("plan of b with plans.file unset = %q, want %q", got, DefaultAnonymous)
+	}
+}
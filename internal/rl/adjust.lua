@@ -0,0 +1,19 @@
+-- Redis Lua script adjusting already-charged buckets (post-response reconcile).
+-- A positive delta charges extra tokens and may overdraw a bucket (bounded
+-- per algorithm); a negative delta refunds tokens, never beyond a full bucket.
+-- The per-algorithm functions (adj.*) are prepended by limiter.go.
+-- KEYS[i] = bucket key i (all keys share the client's hash tag)
+-- ARGV[1] = now_ms
+-- ARGV[2] = unique id (sliding_log members)
+-- ARGV[3+5(i-1) .. 7+5(i-1)] = algorithm, rate (per second), burst, window_ms, delta of bucket i
+-- Returns: 1
+
+local now_ms = tonumber(ARGV[1])
+local id     = ARGV[2]
+
+for i = 1, #KEYS do
+  local a = 3 + 5 * (i - 1)
+  adj[ARGV[a]](KEYS[i], now_ms, tonumber(ARGV[a + 1]), tonumber(ARGV[a + 2]),
+    tonumber(ARGV[a + 3]), tonumber(ARGV[a + 4]), id .. ':' .. i)
+end
+return 1
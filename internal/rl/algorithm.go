@@ -0,0 +1,129 @@
+package rl
+
+import (
+	"time"
+
+	cfg "github.com/skywalker-88/stormgate/pkg/config"
+)
+
+// Algorithm selects how a bucket is enforced (config.Limit.Algorithm).
+type Algorithm string
+
+const (
+	TokenBucket   Algorithm = "token_bucket"   // refill at rps up to burst (default)
+	FixedWindow   Algorithm = "fixed_window"   // burst requests per aligned window
+	SlidingWindow Algorithm = "sliding_window" // weighted current+previous window counter
+	SlidingLog    Algorithm = "sliding_log"    // exact rolling window (one entry per token)
+	GCRA          Algorithm = "gcra"           // generic cell rate; token bucket without refill state
+)
+
+// Rate is one limit as the backends see it.
+//
+// Token bucket and GCRA use RPS and Burst. The window algorithms admit Burst
+// tokens per Window ("1000 requests per rolling hour" = burst 1000, window 1h).
+type Rate struct {
+	Algorithm Algorithm
+	RPS       float64
+	Burst     int64
+	Window    time.Duration
+}
+
+// TokenBucketRate is the classic {rps, burst} limit.
+func TokenBucketRate(rps float64, burst int64) Rate {
+	return Rate{Algorithm: TokenBucket, RPS: rps, Burst: burst}
+}
+
+// RateOf converts a config limit. Window algorithms without window_seconds
+// use burst/rps; without rps, rps is derived as burst/window (for headers and rails).
+func RateOf(l cfg.Limit) Rate {
+	r := Rate{Algorithm: Algorithm(l.Algorithm), RPS: l.RPS, Burst: l.Burst}
+	if r.Algorithm == "" {
+		r.Algorithm = TokenBucket
+	}
+	if r.windowed() {
+		if l.WindowSeconds > 0 {
+			r.Window = time.Duration(l.WindowSeconds) * time.Second
+		} else if l.RPS > 0 {
+			r.Window = time.Duration(float64(l.Burst) / l.RPS * float64(time.Second))
+		}
+		if r.RPS <= 0 && r.Window > 0 {
+			r.RPS = float64(l.Burst) / r.Window.Seconds()
+		}
+	}
+	return r
+}
+
+// Quota reports the number clients see as the limit: tokens per window
+// for the window algorithms, the refill rate otherwise.
+func (r Rate) Quota() float64 {
+	if r.windowed() {
+		return float64(r.Burst)
+	}
+	return r.RPS
+}
+
+// Policy reports the rate as a quota per time window (RateLimit-Policy):
+// burst per window for the window algorithms, and for token bucket / GCRA
+// burst per the time an empty bucket takes to refill.
+func (r Rate) Policy() (quota int64, window time.Duration) {
+	if r.windowed() || r.RPS <= 0 {
+		return r.Burst, r.Window
+	}
+	return r.Burst, time.Duration(float64(r.Burst) / r.RPS * float64(time.Second))
+}
+
+// WindowRate converts an extra window (sliding_window unless set).
+func WindowRate(w cfg.Window) Rate {
+	l := cfg.Limit{Algorithm: w.Algorithm, Burst: w.Limit, WindowSeconds: w.WindowSeconds}
+	if l.Algorithm == "" {
+		l.Algorithm = string(SlidingWindow)
+	}
+	if w.WindowSeconds > 0 {
+		l.RPS = float64(w.Limit) / float64(w.WindowSeconds)
+	}
+	if a := Algorithm(l.Algorithm); a == TokenBucket || a == GCRA {
+		l.WindowSeconds = 0
+	}
+	return RateOf(l)
+}
+
+// Buckets lists what a request charges under key: rate (l's own, possibly
+// tightened by an override) followed by each of l's extra windows.
+func Buckets(key string, rate Rate, l cfg.Limit) []Bucket {
+	out := make([]Bucket, 0, 1+len(l.Windows))
+	out = append(out, Bucket{Key: key, Rate: rate, Cost: l.Cost})
+	for _, w := range l.Windows {
+		out = append(out, Bucket{Key: windowKey(key, w.WindowSeconds), Rate: WindowRate(w), Cost: l.Cost})
+	}
+	return out
+}
+
+func (r Rate) windowed() bool {
+	return r.Algorithm == FixedWindow || r.Algorithm == SlidingWindow || r.Algorithm == SlidingLog
+}
+
+func (r Rate) algorithm() Algorithm {
+	if r.Algorithm == "" {
+		return TokenBucket
+	}
+	return r.Algorithm
+}
+
+func (r Rate) valid(cost int64) bool {
+	if r.Burst <= 0 || cost <= 0 {
+		return false
+	}
+	if r.windowed() {
+		return r.Window >= time.Millisecond
+	}
+	return r.RPS > 0
+}
+
+// storageKey keeps each algorithm's state under its own key, so switching a
+// route's algorithm on reload never hits a WRONGTYPE on the old state.
+func (r Rate) storageKey(key string) string {
+	if r.algorithm() == TokenBucket {
+		return key
+	}
+	return key + ":" + string(r.Algorithm)
+}
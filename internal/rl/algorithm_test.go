@@ -0,0 +1,120 @@
+package rl
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// fakeClock is a settable time source shared by a test and its backend.
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// testBackends returns the memory backend and, when STORMGATE_TEST_REDIS
+// names a Redis (host:port), the Lua one, both driven by clock. Keys get a
+// per-run prefix so reruns against the same Redis start empty.
+func testBackends(t *testing.T, clock *fakeClock) map[string]Backend {
+	t.Helper()
+	m := NewMemory()
+	m.clock = clock.Now
+	out := map[string]Backend{"memory": m}
+	if addr := os.Getenv("STORMGATE_TEST_REDIS"); addr != "" {
+		rdb := redis.NewClient(&redis.Options{Addr: addr})
+		if err := rdb.Ping(context.Background()).Err(); err != nil {
+			t.Fatalf("STORMGATE_TEST_REDIS=%s: %v", addr, err)
+		}
+		t.Cleanup(func() { _ = rdb.Close() })
+		l := New(rdb)
+		l.clock = clock.Now
+		out["redis"] = l
+	}
+	return out
+}
+
+// testKey is unique per test and run.
+func testKey(t *testing.T, name string) string {
+	return "rltest:" + strconv.FormatInt(time.Now().UnixNano(), 36) + ":" + t.Name() + ":" + name
+}
+
+func TestAlgorithms(t *testing.T) {
+	tests := []struct {
+		rate    Rate
+		refill  time.Duration // wait until a full burst is available again
+		partial time.Duration // wait that admits exactly one more token
+	}{
+		{TokenBucketRate(3, 3), time.Second, 334 * time.Millisecond},
+		{Rate{Algorithm: GCRA, RPS: 3, Burst: 3}, time.Second, 334 * time.Millisecond},
+		{Rate{Algorithm: FixedWindow, RPS: 3, Burst: 3, Window: time.Second}, time.Second, 0},
+		{Rate{Algorithm: SlidingWindow, RPS: 3, Burst: 3, Window: time.Second}, 2 * time.Second, 0},
+		{Rate{Algorithm: SlidingLog, RPS: 3, Burst: 3, Window: time.Second}, time.Second, 0},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.rate.Algorithm), func(t *testing.T) {
+			clock := &fakeClock{now: time.UnixMilli(1_700_000_000_000)}
+			for name, b := range testBackends(t, clock) {
+				t.Run(name, func(t *testing.T) {
+					ctx := context.Background()
+					key := testKey(t, "k")
+					for i := 0; i < 3; i++ {
+						ok, _, _, _, err := b.Consume(ctx, key, tt.rate, 1)
+						if err != nil || !ok {
+							t.Fatalf("request %d: allowed=%v err=%v, want allowed", i+1, ok, err)
+						}
+					}
+					ok, _, retry, _, err := b.Consume(ctx, key, tt.rate, 1)
+					if err != nil || ok {
+						t.Fatalf("request 4: allowed=%v err=%v, want denied", ok, err)
+					}
+					if retry <= 0 || retry > tt.refill {
+						t.Fatalf("retry after %v, want within (0, %v]", retry, tt.refill)
+					}
+
+					if tt.partial > 0 {
+						clock.Advance(tt.partial)
+						if ok, _, _, _, _ := b.Consume(ctx, key, tt.rate, 1); !ok {
+							t.Fatalf("denied after %v, want one token refilled", tt.partial)
+						}
+						if ok, _, _, _, _ := b.Consume(ctx, key, tt.rate, 1); ok {
+							t.Fatalf("allowed twice after %v, want one token only", tt.partial)
+						}
+					}
+
+					clock.Advance(tt.refill)
+					ok, rem, _, _, err := b.Consume(ctx, key, tt.rate, 3)
+					if err != nil || !ok {
+						t.Fatalf("after %v: allowed=%v err=%v, want a full burst", tt.refill, ok, err)
+					}
+					if rem != 0 {
+						t.Fatalf("remaining %v after spending a full burst, want 0", rem)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestInvalidBucket(t *testing.T) {
+	tests := []struct {
+		name string
+		b    Bucket
+	}{
+		{"unknown algorithm", Bucket{Key: "k", Rate: Rate{Algorithm: "leaky", RPS: 1, Burst: 1}, Cost: 1}},
+		{"zero burst", Bucket{Key: "k", Rate: TokenBucketRate(1, 0), Cost: 1}},
+		{"zero rps", Bucket{Key: "k", Rate: TokenBucketRate(0, 1), Cost: 1}},
+		{"zero cost", Bucket{Key: "k", Rate: TokenBucketRate(1, 1), Cost: 0}},
+		{"window without duration", Bucket{Key: "k", Rate: Rate{Algorithm: FixedWindow, Burst: 1}, Cost: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := NewMemory().ConsumeAll(context.Background(), []Bucket{tt.b}); err == nil {
+				t.Fatal("want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package rl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Backend is a rate-limit store. Limiter (Redis, shared by all replicas) and
+// Memory (per instance) implement every Algorithm with the same results.
+type Backend interface {
+	// Consume tries to consume `cost` tokens from key under rate.
+	// Returns (allowed, remainingTokens, retryAfter, resetAfter, err)
+	Consume(ctx context.Context, key string, rate Rate, cost int64) (bool, float64, time.Duration, time.Duration, error)
+
+	// ConsumeAll charges every bucket or none: the request is allowed only if
+	// each bucket admits it. Results are in bucket order; buckets that had room
+	// but were not charged report their current state. On Redis Cluster all
+	// keys must share a hash tag (RouteKey/GlobalKey use the client's).
+	ConsumeAll(ctx context.Context, buckets []Bucket) (bool, []Result, error)
+
+	// Adjust corrects buckets after the fact, never denying: each Cost is a
+	// signed token delta (positive charges extra and may overdraw the bucket,
+	// negative refunds). Buckets with a zero Cost are skipped.
+	Adjust(ctx context.Context, buckets []Bucket) error
+
+	// Acquire, Renew and Release manage in-flight leases (see lease.go).
+	Acquire(ctx context.Context, key string, limit int64, lease time.Duration, id string) (bool, int64, error)
+	Renew(ctx context.Context, key, id string, lease time.Duration) error
+	Release(ctx context.Context, key, id string) error
+}
+
+var (
+	_ Backend = (*Limiter)(nil)
+	_ Backend = (*Memory)(nil)
+)
+
+// Bucket is one limit charged as part of a decision.
+type Bucket struct {
+	Key  string
+	Rate Rate
+	Cost int64
+}
+
+// Result is one bucket's outcome.
+type Result struct {
+	Allowed    bool
+	Remaining  float64
+	RetryAfter time.Duration
+	ResetAfter time.Duration
+}
+
+func (b Bucket) check() error {
+	switch b.Rate.algorithm() {
+	case TokenBucket, GCRA, FixedWindow, SlidingWindow, SlidingLog:
+	default:
+		return fmt.Errorf("unknown algorithm %q", b.Rate.Algorithm)
+	}
+	if !b.Rate.valid(b.Cost) {
+		return errors.New("invalid limiter parameters")
+	}
+	return nil
+}
+
+func (b Bucket) checkAdjust() error {
+	b.Cost = 1
+	return b.check()
+}
+
+func consumeOne(ctx context.Context, b Backend, key string, rate Rate, cost int64) (bool, float64, time.Duration, time.Duration, error) {
+	_, res, err := b.ConsumeAll(ctx, []Bucket{{Key: key, Rate: rate, Cost: cost}})
+	if err != nil {
+		return false, 0, 0, 0, err
+	}
+	return res[0].Allowed, res[0].Remaining, res[0].RetryAfter, res[0].ResetAfter, nil
+}
+
+// MostRestrictive picks the result to report: among denying buckets the one
+// with the longest wait, otherwise the one with the fewest tokens left.
+func MostRestrictive(res []Result) int {
+	best := 0
+	for i := 1; i < len(res); i++ {
+		a, b := res[i], res[best]
+		switch {
+		case a.Allowed != b.Allowed:
+			if !a.Allowed {
+				best = i
+			}
+		case !a.Allowed:
+			if a.RetryAfter > b.RetryAfter {
+				best = i
+			}
+		case a.Remaining < b.Remaining || (a.Remaining == b.Remaining && a.ResetAfter > b.ResetAfter):
+			best = i
+		}
+	}
+	return best
+}
+
+// Scaled divides every limit by the expected replica count so N instances
+// with per-instance state together approximate the configured (global) limit.
+// Used for the Redis-down fallback and for the standalone memory backend.
+// replicas is read per call so a reload can change it.
+func Scaled(b Backend, replicas func() int) Backend {
+	return scaled{b: b, replicas: replicas}
+}
+
+type scaled struct {
+	b        Backend
+	replicas func() int
+}
+
+func (s scaled) Consume(ctx context.Context, key string, rate Rate, cost int64) (bool, float64, time.Duration, time.Duration, error) {
+	return consumeOne(ctx, s, key, rate, cost)
+}
+
+func (s scaled) ConsumeAll(ctx context.Context, buckets []Bucket) (bool, []Result, error) {
+	n := s.replicas()
+	scaled := make([]Bucket, len(buckets))
+	for i, b := range buckets {
+		b.Rate.RPS, b.Rate.Burst = PerReplica(b.Rate.RPS, b.Rate.Burst, b.Cost, n)
+		scaled[i] = b
+	}
+	return s.b.ConsumeAll(ctx, scaled)
+}
+
+func (s scaled) Adjust(ctx context.Context, buckets []Bucket) error {
+	n := s.replicas()
+	scaled := make([]Bucket, len(buckets))
+	for i, b := range buckets {
+		b.Rate.RPS, b.Rate.Burst = PerReplica(b.Rate.RPS, b.Rate.Burst, 1, n)
+		scaled[i] = b
+	}
+	return s.b.Adjust(ctx, scaled)
+}
+
+// PerReplica returns this instance's share of rps/burst. Burst never drops
+// below cost, otherwise a single request could never be admitted.
+func PerReplica(rps float64, burst, cost int64, replicas int) (float64, int64) {
+	if replicas <= 1 {
+		return rps, burst
+	}
+	rps /= float64(replicas)
+	burst = int64(math.Ceil(float64(burst) / float64(replicas)))
+	if burst < cost {
+		burst = cost
+	}
+	return rps, burst
+}
@@ -0,0 +1,127 @@
+package rl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cfg "github.com/skywalker-88/stormgate/pkg/config"
+)
+
+// A request is charged to every window or none: when one window is full the
+// others keep their tokens.
+func TestConsumeAllAllOrNothing(t *testing.T) {
+	clock := &fakeClock{now: time.UnixMilli(1_700_000_000_000)}
+	for name, b := range testBackends(t, clock) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			l := cfg.Limit{RPS: 10, Burst: 10, Cost: 1, Windows: []cfg.Window{
+				{Limit: 2, WindowSeconds: 60},
+				{Limit: 100, WindowSeconds: 86400, Algorithm: "fixed_window"},
+			}}
+			buckets := Buckets(testKey(t, "route"), RateOf(l), l)
+			if len(buckets) != 3 {
+				t.Fatalf("got %d buckets, want rate + 2 windows", len(buckets))
+			}
+
+			for i := 0; i < 2; i++ {
+				if ok, _, err := b.ConsumeAll(ctx, buckets); err != nil || !ok {
+					t.Fatalf("request %d: allowed=%v err=%v", i+1, ok, err)
+				}
+			}
+			ok, res, err := b.ConsumeAll(ctx, buckets)
+			if err != nil || ok {
+				t.Fatalf("request 3: allowed=%v err=%v, want denied by the minute window", ok, err)
+			}
+			want := []struct {
+				allowed   bool
+				remaining float64
+			}{{true, 8}, {false, 0}, {true, 98}}
+			for i, w := range want {
+				if res[i].Allowed != w.allowed || res[i].Remaining != w.remaining {
+					t.Errorf("bucket %d: allowed=%v remaining=%v, want %v/%v (uncharged)",
+						i, res[i].Allowed, res[i].Remaining, w.allowed, w.remaining)
+				}
+			}
+			if res[1].RetryAfter <= 0 {
+				t.Errorf("denying window has no retry after")
+			}
+		})
+	}
+}
+
+func TestConsumeAllEmpty(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	for name, b := range testBackends(t, clock) {
+		ok, res, err := b.ConsumeAll(context.Background(), nil)
+		if !ok || len(res) != 0 || err != nil {
+			t.Errorf("%s: got %v %v %v, want allowed with no results", name, ok, res, err)
+		}
+	}
+}
+
+func TestMostRestrictive(t *testing.T) {
+	tests := []struct {
+		name string
+		res  []Result
+		want int
+	}{
+		{"fewest remaining", []Result{{Allowed: true, Remaining: 5}, {Allowed: true, Remaining: 2}}, 1},
+		{"tie: later reset", []Result{{Allowed: true, Remaining: 2, ResetAfter: time.Second},
+			{Allowed: true, Remaining: 2, ResetAfter: time.Minute}}, 1},
+		{"denial beats allowed", []Result{{Allowed: false, RetryAfter: time.Second}, {Allowed: true, Remaining: 0}}, 0},
+		{"longest wait among denials", []Result{{RetryAfter: time.Second}, {RetryAfter: time.Hour}, {RetryAfter: time.Minute}}, 1},
+	}
+	for _, tt := range tests {
+		if got := MostRestrictive(tt.res); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Adjust charges extra (overdrawing by up to one burst) and refunds, never
+// past a full bucket, without denying anything itself.
+func TestAdjust(t *testing.T) {
+	clock := &fakeClock{now: time.UnixMilli(1_700_000_000_000)}
+	for name, b := range testBackends(t, clock) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			rate := Rate{RPS: 1, Burst: 10}
+			bucket := Bucket{Key: testKey(t, "route"), Rate: rate, Cost: 1}
+			consume := func() Result {
+				t.Helper()
+				_, res, err := b.ConsumeAll(ctx, []Bucket{bucket})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return res[0]
+			}
+			adjust := func(delta int64) {
+				t.Helper()
+				if err := b.Adjust(ctx, []Bucket{{Key: bucket.Key, Rate: rate, Cost: delta}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if res := consume(); !res.Allowed || res.Remaining != 9 {
+				t.Fatalf("first charge: %+v", res)
+			}
+			adjust(5)
+			if res := consume(); !res.Allowed || res.Remaining != 3 {
+				t.Fatalf("after +5: %+v, want 3 left", res)
+			}
+			adjust(-4)
+			if res := consume(); !res.Allowed || res.Remaining != 6 {
+				t.Fatalf("after -4: %+v, want 6 left", res)
+			}
+			adjust(-100)
+			if res := consume(); !res.Allowed || res.Remaining != 9 {
+				t.Fatalf("after a big refund: %+v, want a full bucket less one", res)
+			}
+			adjust(20) // 9 - 20, floored at -burst
+			if res := consume(); res.Allowed || res.RetryAfter != 11*time.Second {
+				t.Fatalf("after overdrawing: %+v, want denied for 11s", res)
+			}
+		})
+	}
+}
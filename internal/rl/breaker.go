@@ -0,0 +1,110 @@
+package rl
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/skywalker-88/stormgate/pkg/metrics"
+)
+
+// ErrStoreUnavailable is returned without touching Redis while the breaker is open.
+var ErrStoreUnavailable = errors.New("rl: store unavailable (circuit open)")
+
+// Breaker is a consecutive-failure circuit breaker shared by the limiter and
+// the mitigator, so a dead Redis costs one fast error per call instead of a
+// dial/read timeout on every request.
+//
+//	closed    -> open       after `threshold` consecutive failures
+//	open      -> half-open  after `openFor`; exactly one probe call is let through
+//	half-open -> closed     if the probe succeeds, back to open otherwise
+//
+// A nil *Breaker is valid and never trips.
+type Breaker struct {
+	threshold int
+	openFor   time.Duration
+	clock     func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time // zero while closed
+	probing  bool
+}
+
+func NewBreaker(threshold int, openFor time.Duration) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Breaker{threshold: threshold, openFor: openFor, clock: time.Now}
+}
+
+// Do runs fn unless the circuit is open and records the outcome.
+func (b *Breaker) Do(fn func() error) error {
+	if b == nil {
+		return fn()
+	}
+	ok, probe := b.allow()
+	if !ok {
+		return ErrStoreUnavailable
+	}
+	err := fn()
+	b.record(err, probe)
+	return err
+}
+
+// allow reports whether a call may run and whether it is the half-open probe.
+func (b *Breaker) allow() (ok, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return true, false
+	}
+	if b.probing || b.clock().Sub(b.openedAt) < b.openFor {
+		return false, false
+	}
+	b.probing = true // half-open: single probe
+	return true, true
+}
+
+// record applies the outcome of one call. While the circuit is open only
+// the probe's outcome counts: calls that were in flight when it tripped
+// neither close nor re-open it.
+func (b *Breaker) record(err error, probe bool) {
+	if errors.Is(err, redis.Nil) {
+		err = nil // a miss is a healthy answer
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+	}
+	// The caller gave up: Redis never answered, so nothing was learned
+	// (a cancelled probe just lets the next call probe again).
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if !b.openedAt.IsZero() && !probe {
+		return
+	}
+
+	if err == nil {
+		if !b.openedAt.IsZero() {
+			metrics.StoreBreakerOpen.Set(0)
+		}
+		b.failures = 0
+		b.openedAt = time.Time{}
+		return
+	}
+
+	b.failures++
+	if probe || b.failures >= b.threshold {
+		if b.openedAt.IsZero() {
+			metrics.StoreBreakerOpen.Set(1)
+		}
+		b.openedAt = b.clock()
+	}
+}
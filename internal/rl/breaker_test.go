@@ -0,0 +1,110 @@
+package rl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errDown = errors.New("dial tcp: connection refused")
+
+func newTestBreaker(clock *fakeClock) *Breaker {
+	b := NewBreaker(2, time.Second)
+	b.clock = clock.Now
+	return b
+}
+
+func call(b *Breaker, err error) error { return b.Do(func() error { return err }) }
+
+func TestBreakerTripsAndRecovers(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	b := newTestBreaker(clock)
+
+	_ = call(b, errDown)
+	if err := call(b, nil); err != nil {
+		t.Fatalf("closed breaker: %v", err)
+	}
+	_ = call(b, errDown)
+	_ = call(b, errDown) // second consecutive failure trips it
+	if err := call(b, nil); !errors.Is(err, ErrStoreUnavailable) {
+		t.Fatalf("open breaker returned %v, want ErrStoreUnavailable", err)
+	}
+
+	clock.Advance(time.Second)
+	if err := call(b, errDown); !errors.Is(err, errDown) {
+		t.Fatalf("probe returned %v, want it to reach Redis", err)
+	}
+	if err := call(b, nil); !errors.Is(err, ErrStoreUnavailable) {
+		t.Fatalf("after a failed probe: %v, want open again", err)
+	}
+
+	clock.Advance(time.Second)
+	if err := call(b, nil); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if err := call(b, nil); err != nil {
+		t.Fatalf("after a good probe: %v, want closed", err)
+	}
+}
+
+// A probe whose caller went away tells nothing about Redis: the breaker
+// stays open and the next call probes again.
+func TestBreakerCancelledProbe(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	b := newTestBreaker(clock)
+	_ = call(b, errDown)
+	_ = call(b, errDown)
+
+	clock.Advance(time.Second)
+	_ = call(b, context.Canceled)
+	ok, probe := b.allow()
+	if !ok || !probe {
+		t.Fatalf("after a cancelled probe: allow = %v, %v; want a new probe", ok, probe)
+	}
+	b.record(errDown, probe)
+	if ok, _ := b.allow(); ok {
+		t.Fatal("breaker closed although no probe succeeded")
+	}
+}
+
+// Calls in flight when the breaker trips cannot close it; only the probe can.
+func TestBreakerIgnoresInFlightCalls(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	b := newTestBreaker(clock)
+
+	_, slowProbe := b.allow() // started while closed
+	_ = call(b, errDown)
+	_ = call(b, errDown) // trips
+
+	b.record(nil, slowProbe)
+	if ok, _ := b.allow(); ok {
+		t.Fatal("a call started before the trip closed the breaker")
+	}
+
+	clock.Advance(time.Second)
+	_, probe := b.allow()
+	_, other := b.allow() // denied while the probe runs
+	if other {
+		t.Fatal("two probes at once")
+	}
+	b.record(nil, other) // a late non-probe success
+	if ok, _ := b.allow(); ok {
+		t.Fatal("non-probe result ended the half-open state")
+	}
+	b.record(nil, probe)
+	if ok, _ := b.allow(); !ok {
+		t.Fatal("successful probe did not close the breaker")
+	}
+}
+
+func TestBreakerCancellationDoesNotReset(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	b := newTestBreaker(clock)
+	_ = call(b, errDown)
+	_ = call(b, context.Canceled) // not a success: the failure count stays
+	_ = call(b, errDown)
+	if err := call(b, nil); !errors.Is(err, ErrStoreUnavailable) {
+		t.Fatalf("got %v, want the breaker open after two real failures", err)
+	}
+}
@@ -0,0 +1,76 @@
+package rl
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	cfg "github.com/skywalker-88/stormgate/pkg/config"
+)
+
+// RequestCost evaluates l's cost rules for req: the highest matching rule
+// cost (never below l.Cost) plus the body surcharge, capped at the smallest
+// bucket of l so the request can still be admitted by a full bucket.
+func RequestCost(l cfg.Limit, req *http.Request) int64 {
+	cost := l.Cost
+	for _, r := range l.CostRules {
+		if r.Cost > cost && costRuleMatches(r, req) {
+			cost = r.Cost
+		}
+	}
+	if per := l.BodyCostPerBytes; per > 0 && req.ContentLength > 0 {
+		cost += (req.ContentLength + per - 1) / per
+	}
+	return CapCost(l, cost)
+}
+
+// CapCost limits cost to the smallest bucket (burst or window) of l.
+func CapCost(l cfg.Limit, cost int64) int64 {
+	limit := l.Burst
+	for _, w := range l.Windows {
+		if w.Limit < limit {
+			limit = w.Limit
+		}
+	}
+	if limit > 0 && cost > limit {
+		cost = limit
+	}
+	return cost
+}
+
+func costRuleMatches(r cfg.CostRule, req *http.Request) bool {
+	switch {
+	case r.ContentLength:
+		return req.ContentLength >= 0 && float64(req.ContentLength) >= r.GTE
+	case r.QueryRange != [2]string{}:
+		q := req.URL.Query()
+		from, ok1 := parseDay(q.Get(r.QueryRange[0]))
+		to, ok2 := parseDay(q.Get(r.QueryRange[1]))
+		return ok1 && ok2 && to.Sub(from).Hours()/24 >= r.GTE
+	case r.Query != "":
+		return valueMatches(r, req.URL.Query().Get(r.Query))
+	case r.Header != "":
+		return valueMatches(r, req.Header.Get(r.Header))
+	}
+	return false
+}
+
+func valueMatches(r cfg.CostRule, v string) bool {
+	if v == "" {
+		return false
+	}
+	if r.Equals != "" {
+		return v == r.Equals
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	return err == nil && n >= r.GTE
+}
+
+func parseDay(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,59 @@
+package rl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cfg "github.com/skywalker-88/stormgate/pkg/config"
+)
+
+func TestRequestCost(t *testing.T) {
+	rules := []cfg.CostRule{
+		{Query: "limit", GTE: 100, Cost: 5},
+		{Query: "format", Equals: "csv", Cost: 8},
+		{QueryRange: [2]string{"from", "to"}, GTE: 30, Cost: 10},
+		{Header: "X-Expensive", Equals: "1", Cost: 3},
+		{ContentLength: true, GTE: 1000, Cost: 4},
+	}
+	base := cfg.Limit{Burst: 50, Cost: 1, CostRules: rules}
+	tests := []struct {
+		name   string
+		limit  cfg.Limit
+		target string
+		header string
+		body   int // Content-Length
+		want   int64
+	}{
+		{name: "no rule", limit: base, target: "/q", want: 1},
+		{name: "numeric gte", limit: base, target: "/q?limit=100", want: 5},
+		{name: "numeric below", limit: base, target: "/q?limit=99", want: 1},
+		{name: "not a number", limit: base, target: "/q?limit=lots", want: 1},
+		{name: "equals", limit: base, target: "/q?format=csv", want: 8},
+		{name: "equals mismatch", limit: base, target: "/q?format=json", want: 1},
+		{name: "highest rule wins", limit: base, target: "/q?limit=500&format=csv", want: 8},
+		{name: "range in days", limit: base, target: "/q?from=2024-01-01&to=2024-02-15", want: 10},
+		{name: "short range", limit: base, target: "/q?from=2024-01-01&to=2024-01-10", want: 1},
+		{name: "rfc3339 range", limit: base, target: "/q?from=2024-01-01T00:00:00Z&to=2024-03-01T00:00:00Z", want: 10},
+		{name: "range missing end", limit: base, target: "/q?from=2024-01-01", want: 1},
+		{name: "header", limit: base, target: "/q", header: "1", want: 3},
+		{name: "content length", limit: base, target: "/q", body: 1000, want: 4},
+		{name: "rule below base cost", limit: cfg.Limit{Burst: 50, Cost: 6, CostRules: rules}, target: "/q?limit=100", want: 6},
+		{name: "body surcharge per started chunk", limit: cfg.Limit{Burst: 50, Cost: 1, BodyCostPerBytes: 100}, target: "/q", body: 250, want: 4},
+		{name: "capped at burst", limit: cfg.Limit{Burst: 3, Cost: 1, CostRules: rules}, target: "/q?format=csv", want: 3},
+		{name: "capped at smallest window", limit: cfg.Limit{Burst: 50, Cost: 1, CostRules: rules,
+			Windows: []cfg.Window{{Limit: 2, WindowSeconds: 60}}}, target: "/q?format=csv", want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(strings.Repeat("x", tt.body)))
+			if tt.header != "" {
+				req.Header.Set("X-Expensive", tt.header)
+			}
+			if got := RequestCost(tt.limit, req); got != tt.want {
+				t.Errorf("RequestCost = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+-- Fixed (aligned) window counter with variable cost: `burst` tokens per window.
+-- State: hash {n, start}. Adjustments may overdraw the window up to 2x limit.
+-- Returns: allowed(0/1), tokens_remaining, retry_after_ms, reset_ms
+
+alg.fixed_window = function(key, now_ms, _, limit, window_ms, cost, id, write)
+  local start = now_ms - (now_ms % window_ms)
+  local data  = redis.call('HMGET', key, 'n', 'start')
+  local n     = tonumber(data[1]) or 0
+  if tonumber(data[2]) ~= start then
+    n = 0 -- a new window began
+  end
+
+  local reset_ms = start + window_ms - now_ms
+  local allowed  = 0
+  local retry_ms = 0
+  if n + cost <= limit then
+    n = n + cost
+    allowed = 1
+  else
+    retry_ms = reset_ms
+  end
+
+  if write then
+    redis.call('HSET', key, 'n', n, 'start', start)
+    redis.call('PEXPIRE', key, reset_ms + 1000)
+  end
+  return allowed, math.max(0, limit - n), retry_ms, reset_ms
+end
+
+adj.fixed_window = function(key, now_ms, _, limit, window_ms, delta, id)
+  local start = now_ms - (now_ms % window_ms)
+  local data  = redis.call('HMGET', key, 'n', 'start')
+  local n     = tonumber(data[1]) or 0
+  if tonumber(data[2]) ~= start then
+    n = 0
+  end
+  n = math.max(0, math.min(2 * limit, n + delta))
+
+  redis.call('HSET', key, 'n', n, 'start', start)
+  redis.call('PEXPIRE', key, start + window_ms - now_ms + 1000)
+end
@@ -0,0 +1,49 @@
+-- GCRA (generic cell rate algorithm) with variable cost.
+-- State is a single theoretical arrival time (TAT); `burst` cells may arrive at once.
+-- Adjustments may push the TAT up to one extra burst into the future.
+-- Returns: allowed(0/1), tokens_remaining, retry_after_ms, reset_ms
+
+alg.gcra = function(key, now_ms, rate, burst, window_ms, cost, id, write)
+  local interval  = 1000.0 / rate   -- emission interval (ms per cell)
+  local tolerance = interval * burst
+
+  local tat = tonumber(redis.call('GET', key))
+  if tat == nil or tat < now_ms then
+    tat = now_ms
+  end
+
+  local new_tat  = tat + interval * cost
+  local allow_at = new_tat - tolerance
+
+  local allowed  = 0
+  local retry_ms = 0
+  if now_ms >= allow_at then
+    allowed = 1
+    tat = new_tat
+    if write then
+      redis.call('SET', key, tostring(tat), 'PX', math.ceil(tat - now_ms) + 1)
+    end
+  else
+    retry_ms = math.ceil(allow_at - now_ms)
+  end
+
+  local remaining = math.floor((tolerance - (tat - now_ms)) / interval)
+  if remaining < 0 then remaining = 0 end
+  return allowed, remaining, retry_ms, math.ceil(tat - now_ms)
+end
+
+adj.gcra = function(key, now_ms, rate, burst, window_ms, delta, id)
+  local interval = 1000.0 / rate
+
+  local tat = tonumber(redis.call('GET', key))
+  if tat == nil or tat < now_ms then
+    tat = now_ms
+  end
+  tat = math.min(tat + interval * delta, now_ms + 2 * interval * burst)
+
+  if tat <= now_ms then
+    redis.call('DEL', key)
+  else
+    redis.call('SET', key, tostring(tat), 'PX', math.ceil(tat - now_ms) + 1)
+  end
+end
@@ -0,0 +1,49 @@
+package rl
+
+import "strconv"
+
+// Bucket keys put the client ID in a Redis Cluster hash tag ("{client}") so
+// every bucket of one client lives in the same slot and a Lua script touching
+// several of them (global + route) stays single-slot.
+//
+//	rl:{<client>}:<route>    per-route bucket
+//	rl:{<client>}:global     per-client global bucket
+//	<bucket key>:w<N>s       extra window of N seconds on that bucket
+//	cc:{<client>}:<route>    in-flight leases of a client on a route
+//	cc:{<client>}:global     in-flight leases of a client on all routes
+//	cc:{<route>}             in-flight leases on a route (all clients)
+//
+// Clients known by IP under limits.ip_aggregates are tagged with their
+// coarsest aggregate network instead, which their aggregate buckets share;
+// clients of a tenant org are tagged with the org the same way:
+//
+//	rl:{<net>}:<client>:<route>
+//	rl:{<net>}:<client>:global
+//	rl:{<net>}:agg:<net'>    aggregate bucket of network net' (within net)
+//	rl:{org:<org>}:<client>:<route>
+//	rl:{org:<org>}:<client>:global
+//	rl:{org:<org>}:org       org-wide bucket
+//	rl:{org:<org>}:<client>:share   the client's share of the org (fairness: split)
+//
+// The tag comes first so braces in route templates can't capture it.
+
+func RouteKey(route, client string) string { return "rl:{" + client + "}:" + route }
+func GlobalKey(client string) string       { return "rl:{" + client + "}:global" }
+
+func TaggedRouteKey(tag, route, client string) string {
+	return "rl:{" + tag + "}:" + client + ":" + route
+}
+func TaggedGlobalKey(tag, client string) string { return "rl:{" + tag + "}:" + client + ":global" }
+func AggregateKey(tag, network string) string   { return "rl:{" + tag + "}:agg:" + network }
+
+func OrgTag(org string) string              { return "org:" + org }
+func OrgKey(tag string) string              { return "rl:{" + tag + "}:org" }
+func OrgShareKey(tag, client string) string { return "rl:{" + tag + "}:" + client + ":share" }
+
+func ConcurrencyKey(route, client string) string { return "cc:{" + client + "}:" + route }
+func GlobalConcurrencyKey(client string) string  { return "cc:{" + client + "}:global" }
+func RouteConcurrencyKey(route string) string    { return "cc:{" + route + "}" }
+
+func windowKey(key string, seconds int) string {
+	return key + ":w" + strconv.Itoa(seconds) + "s"
+}
@@ -0,0 +1,151 @@
+package rl
+
+import (
+	"context"
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"math"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// In-flight (concurrency) limits: each running request holds a lease in a
+// per-key set. Leases expire unless renewed, so a crashed replica's leases
+// free themselves after one lease period.
+
+//go:embed lease.lua
+var leaseLua string
+
+//go:embed renew.lua
+var renewLua string
+
+var (
+	leaseScript = redis.NewScript(leaseLua)
+	renewScript = redis.NewScript(renewLua)
+)
+
+// NewLeaseID returns a random id for one request's leases.
+func NewLeaseID() string {
+	var b [12]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func checkLease(limit int64, lease time.Duration) error {
+	if limit <= 0 || lease < time.Millisecond {
+		return errors.New("invalid lease parameters")
+	}
+	return nil
+}
+
+// ---------- Redis ----------
+
+// Acquire takes a lease on key if fewer than limit are live.
+// Returns (acquired, inFlight, err).
+func (l *Limiter) Acquire(ctx context.Context, key string, limit int64, lease time.Duration, id string) (bool, int64, error) {
+	if err := checkLease(limit, lease); err != nil {
+		return false, 0, err
+	}
+	var res interface{}
+	err := l.br.Do(func() (err error) {
+		res, err = leaseScript.Run(ctx, l.rdb, []string{key}, l.clock().UnixMilli(), limit, lease.Milliseconds(), id).Result()
+		return err
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	arr, ok := res.([]interface{})
+	if !ok || len(arr) != 2 {
+		return false, 0, errors.New("unexpected script return")
+	}
+	acquired, _ := arr[0].(int64)
+	n, _ := arr[1].(int64)
+	return acquired == 1, n, nil
+}
+
+// Renew extends a live lease by another lease period.
+func (l *Limiter) Renew(ctx context.Context, key, id string, lease time.Duration) error {
+	return l.br.Do(func() error {
+		return renewScript.Run(ctx, l.rdb, []string{key}, l.clock().UnixMilli(), lease.Milliseconds(), id).Err()
+	})
+}
+
+// Release drops a lease (no-op if it already expired).
+func (l *Limiter) Release(ctx context.Context, key, id string) error {
+	return l.br.Do(func() error {
+		return l.rdb.ZRem(ctx, key, id).Err()
+	})
+}
+
+// ---------- memory ----------
+
+func (m *Memory) Acquire(_ context.Context, key string, limit int64, lease time.Duration, id string) (bool, int64, error) {
+	if err := checkLease(limit, lease); err != nil {
+		return false, 0, err
+	}
+	now := m.clock()
+	nowMs := now.UnixMilli()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(now)
+
+	set := m.leases[key]
+	for lid, exp := range set {
+		if exp <= nowMs {
+			delete(set, lid)
+		}
+	}
+	n := int64(len(set))
+	if n >= limit {
+		return false, n, nil
+	}
+	if set == nil {
+		set = make(map[string]int64)
+		m.leases[key] = set
+	}
+	set[id] = nowMs + lease.Milliseconds()
+	return true, n + 1, nil
+}
+
+func (m *Memory) Renew(_ context.Context, key, id string, lease time.Duration) error {
+	nowMs := m.clock().UnixMilli()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if exp, ok := m.leases[key][id]; ok && exp > nowMs {
+		m.leases[key][id] = nowMs + lease.Milliseconds()
+	}
+	return nil
+}
+
+func (m *Memory) Release(_ context.Context, key, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if set, ok := m.leases[key]; ok {
+		delete(set, id)
+		if len(set) == 0 {
+			delete(m.leases, key)
+		}
+	}
+	return nil
+}
+
+// ---------- scaled ----------
+
+func (s scaled) Acquire(ctx context.Context, key string, limit int64, lease time.Duration, id string) (bool, int64, error) {
+	if n := s.replicas(); n > 1 {
+		limit = int64(math.Ceil(float64(limit) / float64(n)))
+	}
+	return s.b.Acquire(ctx, key, limit, lease, id)
+}
+
+func (s scaled) Renew(ctx context.Context, key, id string, lease time.Duration) error {
+	return s.b.Renew(ctx, key, id, lease)
+}
+
+func (s scaled) Release(ctx context.Context, key, id string) error {
+	return s.b.Release(ctx, key, id)
+}
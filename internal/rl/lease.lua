@@ -0,0 +1,28 @@
+-- Redis Lua script taking an in-flight lease from a concurrency sorted set.
+-- Members are lease ids scored by their expiry, so leases of a crashed
+-- replica drop out on their own once they are no longer renewed.
+-- KEYS[1] = lease zset key
+-- ARGV[1] = now_ms
+-- ARGV[2] = limit (max live leases)
+-- ARGV[3] = lease_ms
+-- ARGV[4] = lease id
+-- Returns: {acquired(0/1), in_flight(int)}
+
+local key      = KEYS[1]
+local now_ms   = tonumber(ARGV[1])
+local limit    = tonumber(ARGV[2])
+local lease_ms = tonumber(ARGV[3])
+local id       = ARGV[4]
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now_ms)
+local n = redis.call('ZCARD', key)
+if n >= limit then
+  return {0, n}
+end
+
+redis.call('ZADD', key, now_ms + lease_ms, id)
+-- the set outlives its latest-expiring lease (which may be another
+-- request's, with a longer lease_ms) by one lease period
+local last = redis.call('ZRANGE', key, -1, -1, 'WITHSCORES')
+redis.call('PEXPIRE', key, tonumber(last[2]) - now_ms + lease_ms)
+return {1, n + 1}
@@ -0,0 +1,77 @@
+package rl
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLeases(t *testing.T) {
+	clock := &fakeClock{now: time.UnixMilli(1_700_000_000_000)}
+	for name, b := range testBackends(t, clock) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			key := testKey(t, "inflight")
+			acquire := func(id string, lease time.Duration) (bool, int64) {
+				t.Helper()
+				ok, n, err := b.Acquire(ctx, key, 2, lease, id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return ok, n
+			}
+
+			if ok, n := acquire("a", 10*time.Second); !ok || n != 1 {
+				t.Fatalf("a: acquired=%v in flight=%d", ok, n)
+			}
+			if ok, n := acquire("b", 10*time.Second); !ok || n != 2 {
+				t.Fatalf("b: acquired=%v in flight=%d", ok, n)
+			}
+			if ok, n := acquire("c", 10*time.Second); ok || n != 2 {
+				t.Fatalf("c over the limit: acquired=%v in flight=%d", ok, n)
+			}
+			if err := b.Release(ctx, key, "a"); err != nil {
+				t.Fatal(err)
+			}
+			if ok, _ := acquire("c", 10*time.Second); !ok {
+				t.Fatal("c not acquired after a's release")
+			}
+
+			// b is renewed, c is not: only c's lease expires.
+			clock.Advance(8 * time.Second)
+			if err := b.Renew(ctx, key, "b", 10*time.Second); err != nil {
+				t.Fatal(err)
+			}
+			clock.Advance(4 * time.Second)
+			if ok, n := acquire("d", 10*time.Second); !ok || n != 2 {
+				t.Fatalf("d after c expired: acquired=%v in flight=%d, want b and d", ok, n)
+			}
+		})
+	}
+}
+
+// A short lease must not expire the set under a longer one (Redis only:
+// the memory backend expires leases one by one).
+func TestLeaseSetTTL(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	l, ok := testBackends(t, clock)["redis"].(*Limiter)
+	if !ok {
+		t.Skip("STORMGATE_TEST_REDIS not set")
+	}
+	ctx := context.Background()
+	key := testKey(t, "inflight")
+	for _, lease := range []struct {
+		id  string
+		ttl time.Duration
+	}{{"long", time.Minute}, {"short", time.Second}} {
+		if _, _, err := l.Acquire(ctx, key, 10, lease.ttl, lease.id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Renew(ctx, key, "short", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := l.rdb.PTTL(ctx, key).Val(); ttl < time.Minute {
+		t.Fatalf("set TTL %v, shorter than the long lease", ttl)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	_ "embed"
 	"errors"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	redis "github.com/redis/go-redis/v9"
@@ -12,36 +14,138 @@ import (
 //go:embed limiter.lua
 var limiterLua string
 
-var script = redis.NewScript(limiterLua)
+//go:embed adjust.lua
+var adjustLua string
 
-// Limiter wraps a Redis-backed token bucket with variable cost.
+//go:embed token_bucket.lua
+var tokenBucketLua string
+
+//go:embed gcra.lua
+var gcraLua string
+
+//go:embed fixed_window.lua
+var fixedWindowLua string
+
+//go:embed sliding_window.lua
+var slidingWindowLua string
+
+//go:embed sliding_log.lua
+var slidingLogLua string
+
+// algorithmsLua defines alg.<name> (consume) and adj.<name> (adjust) for
+// every algorithm. Each script is one of the drivers appended to it, so Redis
+// runs the whole thing atomically.
+var algorithmsLua = "local alg, adj = {}, {}\n" +
+	tokenBucketLua + gcraLua + fixedWindowLua + slidingWindowLua + slidingLogLua
+
+var (
+	script       = redis.NewScript(algorithmsLua + limiterLua)
+	adjustScript = redis.NewScript(algorithmsLua + adjustLua)
+)
+
+// Limiter runs the rate-limit algorithms as atomic Lua scripts in Redis.
+// rdb may be a single node, a Sentinel failover client or a Cluster client.
 type Limiter struct {
-	rdb   *redis.Client
+	rdb   redis.UniversalClient
+	br    *Breaker
 	clock func() time.Time
+	seq   atomic.Uint64 // sliding-log member ids
 }
 
-func New(rdb *redis.Client) *Limiter {
+func New(rdb redis.UniversalClient) *Limiter {
 	return &Limiter{rdb: rdb, clock: time.Now}
 }
 
-// Consume tries to consume `cost` tokens from key at `rps` with `burst`.
+// WithBreaker routes every Redis call through b (shared with the mitigator).
+func (l *Limiter) WithBreaker(b *Breaker) *Limiter {
+	l.br = b
+	return l
+}
+
+// Consume tries to consume `cost` tokens from key under rate.
 // Returns (allowed, remainingTokens, retryAfter, resetAfter, err)
-func (l *Limiter) Consume(ctx context.Context, key string, rps float64, burst int64, cost int64) (bool, float64, time.Duration, time.Duration, error) {
-	if rps <= 0 || burst <= 0 || cost <= 0 {
-		return false, 0, 0, 0, errors.New("invalid limiter parameters")
+func (l *Limiter) Consume(ctx context.Context, key string, rate Rate, cost int64) (bool, float64, time.Duration, time.Duration, error) {
+	return consumeOne(ctx, l, key, rate, cost)
+}
+
+// ConsumeAll charges every bucket in one script call, or none of them.
+func (l *Limiter) ConsumeAll(ctx context.Context, buckets []Bucket) (bool, []Result, error) {
+	if len(buckets) == 0 {
+		return true, nil, nil
 	}
-	nowMs := l.clock().UnixMilli()
-	res, err := script.Run(ctx, l.rdb, []string{key}, nowMs, rps, burst, cost).Result()
+	now := l.clock()
+	id := strconv.FormatInt(now.UnixNano(), 36) + "-" + strconv.FormatUint(l.seq.Add(1), 36)
+
+	keys := make([]string, len(buckets))
+	args := make([]interface{}, 0, 2+5*len(buckets))
+	args = append(args, now.UnixMilli(), id)
+	for i, b := range buckets {
+		if err := b.check(); err != nil {
+			return false, nil, err
+		}
+		keys[i] = b.Rate.storageKey(b.Key)
+		args = append(args, string(b.Rate.algorithm()), b.Rate.RPS, b.Rate.Burst, b.Rate.Window.Milliseconds(), b.Cost)
+	}
+
+	var res interface{}
+	err := l.br.Do(func() (err error) {
+		res, err = script.Run(ctx, l.rdb, keys, args...).Result()
+		return err
+	})
 	if err != nil {
-		return false, 0, 0, 0, err
+		return false, nil, err
 	}
+	return parseResults(res, len(buckets))
+}
+
+// Adjust applies each bucket's Cost as a signed correction in one script call.
+func (l *Limiter) Adjust(ctx context.Context, buckets []Bucket) error {
+	now := l.clock()
+	id := strconv.FormatInt(now.UnixNano(), 36) + "-" + strconv.FormatUint(l.seq.Add(1), 36)
+
+	keys := make([]string, 0, len(buckets))
+	args := make([]interface{}, 0, 2+5*len(buckets))
+	args = append(args, now.UnixMilli(), id)
+	for _, b := range buckets {
+		if b.Cost == 0 {
+			continue
+		}
+		if err := b.checkAdjust(); err != nil {
+			return err
+		}
+		keys = append(keys, b.Rate.storageKey(b.Key))
+		args = append(args, string(b.Rate.algorithm()), b.Rate.RPS, b.Rate.Burst, b.Rate.Window.Milliseconds(), b.Cost)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return l.br.Do(func() error {
+		return adjustScript.Run(ctx, l.rdb, keys, args...).Err()
+	})
+}
+
+// parseResults decodes {allowed, then allowed, remaining, retry_ms, reset_ms
+// per bucket}. Redis turns Lua numbers into integers, so every field arrives
+// as int64.
+func parseResults(res interface{}, n int) (bool, []Result, error) {
 	arr, ok := res.([]interface{})
-	if !ok || len(arr) < 4 {
-		return false, 0, 0, 0, errors.New("unexpected script return")
+	if !ok || len(arr) != 1+4*n {
+		return false, nil, errors.New("unexpected script return")
+	}
+	all, _ := arr[0].(int64)
+	out := make([]Result, n)
+	for i := range out {
+		f := arr[1+4*i:]
+		allowed, _ := f[0].(int64)
+		remaining, _ := f[1].(int64)
+		retryMs, _ := f[2].(int64)
+		resetMs, _ := f[3].(int64)
+		out[i] = Result{
+			Allowed:    allowed == 1,
+			Remaining:  float64(remaining),
+			RetryAfter: time.Duration(retryMs) * time.Millisecond,
+			ResetAfter: time.Duration(resetMs) * time.Millisecond,
+		}
 	}
-	allowed := arr[0].(int64) == 1
-	remaining, _ := arr[1].(float64)
-	retryMs, _ := arr[2].(int64)
-	resetMs, _ := arr[3].(int64)
-	return allowed, remaining, time.Duration(retryMs) * time.Millisecond, time.Duration(resetMs) * time.Millisecond, nil
+	return all == 1, out, nil
 }
@@ -1,54 +1,45 @@
--- Redis Lua script implementing a distributed token bucket with variable cost.
--- KEYS[1] = bucket key
+-- Redis Lua script charging one or more rate-limit buckets atomically.
+-- Every bucket is checked first; state is written only when all of them admit
+-- the request, so a denial in one bucket never burns tokens in the others.
+-- The per-algorithm functions (alg.*) are prepended by limiter.go.
+-- KEYS[i] = bucket key i (all keys share the client's hash tag)
 -- ARGV[1] = now_ms
--- ARGV[2] = refill_rate tokens per second (float allowed)
--- ARGV[3] = burst_capacity (max tokens)
--- ARGV[4] = cost (tokens to consume)
--- Returns: {allowed(0/1), tokens_remaining(float), retry_after_ms(int), reset_ms(int)}
+-- ARGV[2] = unique request id (sliding_log members)
+-- ARGV[3+5(i-1) .. 7+5(i-1)] = algorithm, rate (per second), burst, window_ms, cost of bucket i
+-- Returns: {allowed(0/1), then per bucket: allowed, tokens_remaining, retry_after_ms, reset_ms}
 
-local key       = KEYS[1]
-local now_ms    = tonumber(ARGV[1])
-local rate      = tonumber(ARGV[2])
-local burst     = tonumber(ARGV[3])
-local cost      = tonumber(ARGV[4])
+local now_ms = tonumber(ARGV[1])
+local id     = ARGV[2]
 
-local data = redis.call('HMGET', key, 'tokens', 'ts')
-local tokens = tonumber(data[1])
-local ts     = tonumber(data[2])
-
-if tokens == nil then
-  tokens = burst
-  ts = now_ms
+local function run(i, cost, write)
+  local a = 3 + 5 * (i - 1)
+  return alg[ARGV[a]](KEYS[i], now_ms, tonumber(ARGV[a + 1]), tonumber(ARGV[a + 2]),
+    tonumber(ARGV[a + 3]), cost, id .. ':' .. i, write)
 end
 
--- Refill based on elapsed time
-local elapsed = (now_ms - ts) / 1000.0
-if elapsed < 0 then elapsed = 0 end
-local refill = elapsed * rate
-
-tokens = math.min(burst, tokens + refill)
-local allowed = 0
-local retry_ms = 0
-
-if tokens >= cost then
-  tokens = tokens - cost
-  allowed = 1
-  ts = now_ms
-else
-  allowed = 0
-  local deficit = cost - tokens
-  retry_ms = math.floor((deficit / rate) * 1000 + 0.5)
-  -- move time forward so next caller sees the same refill baseline
-  ts = now_ms
+local function cost_of(i)
+  return tonumber(ARGV[7 + 5 * (i - 1)])
 end
 
--- Persist state and set a TTL ~ 2x full-refill time (never 0)
-redis.call('HSET', key, 'tokens', tokens, 'ts', ts)
-local ttl = math.floor((burst / math.max(rate, 0.0001)) * 2 + 0.5)
-if ttl < 1 then ttl = 1 end
-redis.call('EXPIRE', key, ttl)
-
--- time to full reset (when bucket would be full again)
-local reset_ms = math.floor(((burst - tokens) / math.max(rate, 0.0001)) * 1000 + 0.5)
+-- 1) check every bucket without writing
+local res = {}
+local all = 1
+for i = 1, #KEYS do
+  res[i] = {run(i, cost_of(i), false)}
+  if res[i][1] == 0 then all = 0 end
+end
 
-return {allowed, tokens, retry_ms, reset_ms}
\ No newline at end of file
+-- 2) commit all, or report the untouched state of the buckets that had room
+local out = {all}
+for i = 1, #KEYS do
+  local r = res[i]
+  if all == 1 then
+    r = {run(i, cost_of(i), true)}
+  elseif r[1] == 1 then
+    r = {run(i, 0, false)}
+  end
+  for j = 1, 4 do
+    out[#out + 1] = r[j]
+  end
+end
+return out
@@ -0,0 +1,367 @@
+package rl
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory is an in-process implementation of the same algorithms as the Lua
+// scripts. State is local to this instance; it backs on_store_error=local
+// when Redis is unreachable and the standalone memory backend.
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*memBucket
+	leases    map[string]map[string]int64 // key -> lease id -> expiry (ms)
+	clock     func() time.Time
+	nextSweep time.Time
+}
+
+// memBucket holds the state of whichever algorithm owns the key.
+type memBucket struct {
+	tokens float64 // token bucket
+	tsMs   int64
+	n      int64 // fixed/sliding window: current window count
+	prev   int64 // sliding window: previous window count
+	start  int64 // fixed/sliding window: current window start
+	log    []int64
+	tat    float64 // gcra
+	expMs  int64   // mirrors the EXPIRE set by the Lua scripts
+}
+
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]*memBucket), leases: make(map[string]map[string]int64), clock: time.Now}
+}
+
+// Consume tries to consume `cost` tokens from key under rate.
+// Returns (allowed, remainingTokens, retryAfter, resetAfter, err)
+func (m *Memory) Consume(ctx context.Context, key string, rate Rate, cost int64) (bool, float64, time.Duration, time.Duration, error) {
+	return consumeOne(ctx, m, key, rate, cost)
+}
+
+// ConsumeAll charges every bucket or none, like limiter.lua: each bucket is
+// evaluated on a copy of its state and the copies are stored only if all allow.
+func (m *Memory) ConsumeAll(_ context.Context, buckets []Bucket) (bool, []Result, error) {
+	for _, b := range buckets {
+		if err := b.check(); err != nil {
+			return false, nil, err
+		}
+	}
+	now := m.clock()
+	nowMs := now.UnixMilli()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(now)
+
+	state := make([]memBucket, len(buckets))
+	res := make([]Result, len(buckets))
+	all := true
+	for i, b := range buckets {
+		state[i] = m.load(b, nowMs)
+		res[i] = state[i].run(nowMs, b.Rate, b.Cost)
+		all = all && res[i].Allowed
+	}
+	for i, b := range buckets {
+		switch {
+		case all:
+			m.buckets[b.Rate.storageKey(b.Key)] = &state[i]
+		case res[i].Allowed:
+			// not charged: report the current state
+			cur := m.load(b, nowMs)
+			res[i] = cur.run(nowMs, b.Rate, 0)
+		}
+	}
+	return all, res, nil
+}
+
+// Adjust applies each bucket's Cost as a signed correction (see adjust.lua).
+func (m *Memory) Adjust(_ context.Context, buckets []Bucket) error {
+	for _, b := range buckets {
+		if b.Cost == 0 {
+			continue
+		}
+		if err := b.checkAdjust(); err != nil {
+			return err
+		}
+	}
+	now := m.clock()
+	nowMs := now.UnixMilli()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, b := range buckets {
+		if b.Cost == 0 {
+			continue
+		}
+		st := m.load(b, nowMs)
+		st.adjust(nowMs, b.Rate, b.Cost)
+		m.buckets[b.Rate.storageKey(b.Key)] = &st
+	}
+	return nil
+}
+
+// load returns a copy of the live state under b (caller holds mu).
+func (m *Memory) load(b Bucket, nowMs int64) memBucket {
+	cur, ok := m.buckets[b.Rate.storageKey(b.Key)]
+	if !ok || nowMs >= cur.expMs {
+		return memBucket{tokens: float64(b.Rate.Burst), tsMs: nowMs}
+	}
+	cp := *cur
+	cp.log = append([]int64(nil), cur.log...)
+	return cp
+}
+
+func (b *memBucket) run(nowMs int64, rate Rate, cost int64) Result {
+	var allowed bool
+	var remaining float64
+	var retryMs, resetMs int64
+	switch rate.algorithm() {
+	case TokenBucket:
+		allowed, remaining, retryMs, resetMs = b.tokenBucket(nowMs, rate.RPS, rate.Burst, cost)
+	case GCRA:
+		allowed, remaining, retryMs, resetMs = b.gcra(nowMs, rate.RPS, rate.Burst, cost)
+	case FixedWindow:
+		allowed, remaining, retryMs, resetMs = b.fixedWindow(nowMs, rate.Burst, rate.Window.Milliseconds(), cost)
+	case SlidingWindow:
+		allowed, remaining, retryMs, resetMs = b.slidingWindow(nowMs, rate.Burst, rate.Window.Milliseconds(), cost)
+	case SlidingLog:
+		allowed, remaining, retryMs, resetMs = b.slidingLog(nowMs, rate.Burst, rate.Window.Milliseconds(), cost)
+	}
+	return Result{
+		Allowed:    allowed,
+		Remaining:  remaining,
+		RetryAfter: time.Duration(retryMs) * time.Millisecond,
+		ResetAfter: time.Duration(resetMs) * time.Millisecond,
+	}
+}
+
+// ---------- algorithms (same math as the .lua files) ----------
+
+func (b *memBucket) tokenBucket(nowMs int64, rps float64, burst, cost int64) (bool, float64, int64, int64) {
+	elapsed := float64(nowMs-b.tsMs) / 1000.0
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	tokens := math.Min(float64(burst), b.tokens+elapsed*rps)
+
+	allowed := false
+	var retryMs int64
+	if tokens >= float64(cost) {
+		tokens -= float64(cost)
+		allowed = true
+	} else {
+		retryMs = int64(math.Floor((float64(cost)-tokens)/rps*1000 + 0.5))
+	}
+	b.tokens = tokens
+	b.tsMs = nowMs
+
+	ttlSec := int64(math.Floor(float64(burst)/math.Max(rps, 0.0001)*2 + 0.5))
+	if ttlSec < 1 {
+		ttlSec = 1
+	}
+	b.expMs = nowMs + ttlSec*1000
+
+	resetMs := int64(math.Floor((float64(burst)-tokens)/math.Max(rps, 0.0001)*1000 + 0.5))
+	return allowed, math.Max(0, math.Floor(tokens)), retryMs, resetMs
+}
+
+func (b *memBucket) gcra(nowMs int64, rps float64, burst, cost int64) (bool, float64, int64, int64) {
+	interval := 1000.0 / rps
+	tolerance := interval * float64(burst)
+	now := float64(nowMs)
+
+	tat := math.Max(b.tat, now)
+	newTat := tat + interval*float64(cost)
+	allowAt := newTat - tolerance
+
+	allowed := false
+	var retryMs int64
+	if now >= allowAt {
+		allowed = true
+		tat = newTat
+		b.tat = tat
+		b.expMs = nowMs + int64(math.Ceil(tat-now)) + 1
+	} else {
+		retryMs = int64(math.Ceil(allowAt - now))
+	}
+	remaining := math.Max(0, math.Floor((tolerance-(tat-now))/interval))
+	return allowed, remaining, retryMs, int64(math.Ceil(tat - now))
+}
+
+func (b *memBucket) fixedWindow(nowMs, limit, windowMs, cost int64) (bool, float64, int64, int64) {
+	start := nowMs - nowMs%windowMs
+	if b.start != start {
+		b.n, b.start = 0, start
+	}
+	resetMs := start + windowMs - nowMs
+
+	allowed := false
+	var retryMs int64
+	if b.n+cost <= limit {
+		b.n += cost
+		allowed = true
+	} else {
+		retryMs = resetMs
+	}
+	b.expMs = nowMs + resetMs + 1000
+	return allowed, float64(max(0, limit-b.n)), retryMs, resetMs
+}
+
+func (b *memBucket) slidingWindow(nowMs, limit, windowMs, cost int64) (bool, float64, int64, int64) {
+	start := nowMs - nowMs%windowMs
+	if b.start != start {
+		if b.start == start-windowMs {
+			b.prev = b.n
+		} else {
+			b.prev = 0
+		}
+		b.n, b.start = 0, start
+	}
+	elapsed := nowMs - start
+	weight := float64(windowMs-elapsed) / float64(windowMs)
+	est := float64(b.prev)*weight + float64(b.n)
+
+	allowed := false
+	var retryMs int64
+	if est+float64(cost) <= float64(limit) {
+		b.n += cost
+		est += float64(cost)
+		allowed = true
+	} else {
+		room := limit - b.n - cost
+		if b.prev > 0 && room >= 0 {
+			needWeight := float64(room) / float64(b.prev)
+			retryMs = int64(math.Ceil((1-needWeight)*float64(windowMs) - float64(elapsed)))
+			if retryMs > windowMs-elapsed {
+				retryMs = windowMs - elapsed
+			}
+		} else {
+			retryMs = windowMs - elapsed
+		}
+		if retryMs < 1 {
+			retryMs = 1
+		}
+	}
+	b.expMs = nowMs + 2*windowMs
+
+	resetMs := windowMs - elapsed
+	if b.n > 0 {
+		resetMs += windowMs
+	}
+	return allowed, math.Max(0, math.Floor(float64(limit)-est)), retryMs, resetMs
+}
+
+func (b *memBucket) slidingLog(nowMs, limit, windowMs, cost int64) (bool, float64, int64, int64) {
+	// drop entries at or before now-window (ZREMRANGEBYSCORE -inf now-window)
+	cut := sort.Search(len(b.log), func(i int) bool { return b.log[i] > nowMs-windowMs })
+	b.log = b.log[cut:]
+	count := int64(len(b.log))
+
+	allowed := false
+	var retryMs int64
+	if count+cost <= limit {
+		for i := int64(0); i < cost; i++ {
+			b.log = append(b.log, nowMs)
+		}
+		count += cost
+		allowed = true
+	} else {
+		retryMs = b.log[count+cost-limit-1] + windowMs - nowMs
+		if retryMs < 1 {
+			retryMs = 1
+		}
+	}
+
+	var resetMs int64
+	if count > 0 {
+		resetMs = b.log[count-1] + windowMs - nowMs
+		b.expMs = nowMs + resetMs + 1000
+	}
+	return allowed, float64(max(0, limit-count)), retryMs, resetMs
+}
+
+// ---------- adjustments (same math as the adj.* functions) ----------
+
+func (b *memBucket) adjust(nowMs int64, rate Rate, delta int64) {
+	burst := float64(rate.Burst)
+	windowMs := rate.Window.Milliseconds()
+	switch rate.algorithm() {
+	case TokenBucket:
+		elapsed := math.Max(0, float64(nowMs-b.tsMs)/1000.0)
+		tokens := math.Min(burst, b.tokens+elapsed*rate.RPS) - float64(delta)
+		b.tokens = math.Max(-burst, math.Min(burst, tokens))
+		b.tsMs = nowMs
+		ttlSec := int64(math.Floor(burst/math.Max(rate.RPS, 0.0001)*2 + 0.5))
+		b.expMs = nowMs + max(ttlSec, 1)*1000
+	case GCRA:
+		interval := 1000.0 / rate.RPS
+		now := float64(nowMs)
+		b.tat = math.Min(math.Max(b.tat, now)+interval*float64(delta), now+2*interval*burst)
+		b.expMs = nowMs + int64(math.Ceil(b.tat-now)) + 1
+		if b.tat <= now {
+			b.expMs = nowMs // DEL
+		}
+	case FixedWindow:
+		start := nowMs - nowMs%windowMs
+		if b.start != start {
+			b.n, b.start = 0, start
+		}
+		b.n = max(0, min(2*rate.Burst, b.n+delta))
+		b.expMs = start + windowMs + 1000
+	case SlidingWindow:
+		start := nowMs - nowMs%windowMs
+		if b.start != start {
+			if b.start == start-windowMs {
+				b.prev = b.n
+			} else {
+				b.prev = 0
+			}
+			b.n, b.start = 0, start
+		}
+		b.n = max(0, min(2*rate.Burst, b.n+delta))
+		b.expMs = nowMs + 2*windowMs
+	case SlidingLog:
+		cut := sort.Search(len(b.log), func(i int) bool { return b.log[i] > nowMs-windowMs })
+		b.log = b.log[cut:]
+		count := int64(len(b.log))
+		if delta > 0 {
+			for i := int64(0); i < min(delta, 2*rate.Burst-count); i++ {
+				b.log = append(b.log, nowMs)
+			}
+		} else if delta < 0 {
+			b.log = b.log[:count-min(-delta, count)]
+		}
+		if n := len(b.log); n > 0 {
+			b.expMs = b.log[n-1] + windowMs + 1000
+		} else {
+			b.expMs = nowMs
+		}
+	}
+}
+
+// sweep drops expired buckets at most once a minute (caller holds mu).
+func (m *Memory) sweep(now time.Time) {
+	if now.Before(m.nextSweep) {
+		return
+	}
+	m.nextSweep = now.Add(time.Minute)
+	nowMs := now.UnixMilli()
+	for k, b := range m.buckets {
+		if nowMs >= b.expMs {
+			delete(m.buckets, k)
+		}
+	}
+	for k, set := range m.leases {
+		for id, exp := range set {
+			if exp <= nowMs {
+				delete(set, id)
+			}
+		}
+		if len(set) == 0 {
+			delete(m.leases, k)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package rl
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/skywalker-88/stormgate/pkg/metrics"
+)
+
+// MemoryMitigator keeps overrides, blocks and streaks in process memory.
+// It pairs with the memory backend for single-instance deployments that run
+// without Redis; state is lost on restart and not shared between replicas.
+type MemoryMitigator struct {
+	mu    sync.Mutex
+	items map[memMitKey]*memMitItem
+}
+
+type memMitKey struct{ kind, route, client string }
+
+type memMitItem struct {
+	ov  Override
+	bl  Block
+	n   int64
+	exp time.Time
+}
+
+var _ Mitigator = (*MemoryMitigator)(nil)
+
+func NewMemoryMitigator() *MemoryMitigator {
+	return &MemoryMitigator{items: make(map[memMitKey]*memMitItem)}
+}
+
+// get returns a live item (expired ones are dropped); caller holds mu.
+func (m *MemoryMitigator) get(k memMitKey, now time.Time) *memMitItem {
+	it, ok := m.items[k]
+	if !ok {
+		return nil
+	}
+	if !now.Before(it.exp) {
+		delete(m.items, k)
+		return nil
+	}
+	return it
+}
+
+func (m *MemoryMitigator) del(k memMitKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.items, k)
+	return nil
+}
+
+// ------- Overrides -------
+
+func (m *MemoryMitigator) GetOverride(_ context.Context, route, client string) (*Override, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if it := m.get(memMitKey{"override", route, client}, time.Now()); it != nil {
+		ov := it.ov
+		return &ov, nil
+	}
+	return nil, nil
+}
+
+func (m *MemoryMitigator) SetOverride(_ context.Context, route, client string, ov Override, ttl time.Duration) error {
+	exp := time.Now().Add(ttl)
+	ov.Exp = exp.Unix()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items[memMitKey{"override", route, client}] = &memMitItem{ov: ov, exp: exp}
+	return nil
+}
+
+func (m *MemoryMitigator) ClearOverride(_ context.Context, route, client string) error {
+	return m.del(memMitKey{"override", route, client})
+}
+
+func (m *MemoryMitigator) ListOverrides(_ context.Context) ([]OverrideEntry, error) {
+	out := []OverrideEntry{}
+	m.each("override", func(k memMitKey, it *memMitItem) {
+		out = append(out, OverrideEntry{Route: k.route, Client: k.client, Override: it.ov})
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Route+out[i].Client < out[j].Route+out[j].Client })
+	return out, nil
+}
+
+// -------- Blocks --------
+
+func (m *MemoryMitigator) GetBlock(_ context.Context, route, client string) (*Block, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if it := m.get(memMitKey{"block", route, client}, time.Now()); it != nil {
+		bl := it.bl
+		return &bl, nil
+	}
+	return nil, nil
+}
+
+func (m *MemoryMitigator) SetBlock(_ context.Context, route, client string, bl Block, ttl time.Duration) error {
+	exp := time.Now().Add(ttl)
+	bl.Exp = exp.Unix()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items[memMitKey{"block", route, client}] = &memMitItem{bl: bl, exp: exp}
+	return nil
+}
+
+func (m *MemoryMitigator) ClearBlock(_ context.Context, route, client string) error {
+	return m.del(memMitKey{"block", route, client})
+}
+
+func (m *MemoryMitigator) ListBlocks(_ context.Context) ([]BlockEntry, error) {
+	out := []BlockEntry{}
+	m.each("block", func(k memMitKey, it *memMitItem) {
+		out = append(out, BlockEntry{Route: k.route, Client: k.client, Block: it.bl})
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Route+out[i].Client < out[j].Route+out[j].Client })
+	return out, nil
+}
+
+// ---- Repeat-offender streak ----
+
+func (m *MemoryMitigator) IncrStreak(_ context.Context, route, client string, window time.Duration) (int64, error) {
+	k := memMitKey{"streak", route, client}
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it := m.get(k, now)
+	if it == nil {
+		it = &memMitItem{}
+		m.items[k] = it
+	}
+	it.n++
+	it.exp = now.Add(window) // like INCR + EXPIRE: every hit extends the window
+	return it.n, nil
+}
+
+func (m *MemoryMitigator) ResetStreak(_ context.Context, route, client string) error {
+	return m.del(memMitKey{"streak", route, client})
+}
+
+// ---- Metrics ----
+
+// RefreshActiveGauges also serves as the janitor for expired entries.
+func (m *MemoryMitigator) RefreshActiveGauges(_ context.Context) error {
+	ov := map[string]int{}
+	bl := map[string]int{}
+	m.each("", func(k memMitKey, _ *memMitItem) {
+		switch k.kind {
+		case "override":
+			ov[k.route]++
+		case "block":
+			bl[k.route]++
+		}
+	})
+
+	metrics.ActiveOverrides.Reset()
+	metrics.ActiveBlocks.Reset()
+	for route, n := range ov {
+		metrics.ActiveOverrides.WithLabelValues(route).Set(float64(n))
+	}
+	for route, n := range bl {
+		metrics.ActiveBlocks.WithLabelValues(route).Set(float64(n))
+	}
+	return nil
+}
+
+// each visits live items of kind ("" = all), dropping expired ones.
+func (m *MemoryMitigator) each(kind string, fn func(memMitKey, *memMitItem)) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k := range m.items {
+		it := m.get(k, now)
+		if it != nil && (kind == "" || k.kind == kind) {
+			fn(k, it)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -12,11 +13,15 @@ import (
 	"github.com/skywalker-88/stormgate/pkg/metrics"
 )
 
+// AllClients is the client of a route-wide override (adaptive protection).
+const AllClients = "*"
+
 type Override struct {
-	RPS   int   `json:"rps"`
-	Burst int   `json:"burst"`
-	Step  int   `json:"step,omitempty"` // ramp step index (0-based)
-	Exp   int64 `json:"exp,omitempty"`
+	RPS    int     `json:"rps"`
+	Burst  int     `json:"burst"`
+	Factor float64 `json:"factor,omitempty"` // share of each client's own limit (adaptive); 0 = unused
+	Step   int     `json:"step,omitempty"`   // ramp step index (0-based)
+	Exp    int64   `json:"exp,omitempty"`
 }
 
 type Block struct {
@@ -24,16 +29,32 @@ type Block struct {
 	Exp    int64  `json:"exp,omitempty"`
 }
 
+// OverrideEntry is an active override together with the {route,client} it applies to.
+type OverrideEntry struct {
+	Route  string `json:"route"`
+	Client string `json:"client"`
+	Override
+}
+
+// BlockEntry is an active block together with the {route,client} it applies to.
+type BlockEntry struct {
+	Route  string `json:"route"`
+	Client string `json:"client"`
+	Block
+}
+
 type Mitigator interface {
 	// Overrides
 	GetOverride(ctx context.Context, route, client string) (*Override, error)
 	SetOverride(ctx context.Context, route, client string, ov Override, ttl time.Duration) error
 	ClearOverride(ctx context.Context, route, client string) error
+	ListOverrides(ctx context.Context) ([]OverrideEntry, error)
 
 	// Blocks
 	GetBlock(ctx context.Context, route, client string) (*Block, error)
 	SetBlock(ctx context.Context, route, client string, b Block, ttl time.Duration) error
 	ClearBlock(ctx context.Context, route, client string) error
+	ListBlocks(ctx context.Context) ([]BlockEntry, error)
 
 	// Repeat-offender streak
 	IncrStreak(ctx context.Context, route, client string, window time.Duration) (int64, error)
@@ -43,9 +64,50 @@ type Mitigator interface {
 	RefreshActiveGauges(ctx context.Context) error
 }
 
-type RedisMitigator struct{ rdb *redis.Client }
+type RedisMitigator struct {
+	rdb redis.UniversalClient
+	br  *Breaker
+}
+
+func NewRedisMitigator(rdb redis.UniversalClient) *RedisMitigator {
+	return &RedisMitigator{rdb: rdb}
+}
+
+// WithBreaker routes every Redis call through b (shared with the limiter).
+func (m *RedisMitigator) WithBreaker(b *Breaker) *RedisMitigator {
+	m.br = b
+	return m
+}
+
+// getJSON loads a JSON value; (nil, nil) when the key is missing. Corrupt
+// values are dropped, matching the lenient behavior for overrides and blocks.
+func (m *RedisMitigator) getJSON(ctx context.Context, key string, v any) (bool, error) {
+	var b []byte
+	err := m.br.Do(func() (err error) {
+		b, err = m.rdb.Get(ctx, key).Bytes()
+		return err
+	})
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		_ = m.rdb.Del(ctx, key).Err()
+		return false, nil
+	}
+	return true, nil
+}
+
+func (m *RedisMitigator) setJSON(ctx context.Context, key string, v any, ttl time.Duration) error {
+	j, _ := json.Marshal(v)
+	return m.br.Do(func() error { return m.rdb.Set(ctx, key, j, ttl).Err() })
+}
 
-func NewRedisMitigator(rdb *redis.Client) *RedisMitigator { return &RedisMitigator{rdb: rdb} }
+func (m *RedisMitigator) del(ctx context.Context, key string) error {
+	return m.br.Do(func() error { return m.rdb.Del(ctx, key).Err() })
+}
 
 func keyOverride(route, client string) string { return fmt.Sprintf("sg:override:%s:%s", route, client) }
 func keyBlock(route, client string) string    { return fmt.Sprintf("sg:block:%s:%s", route, client) }
@@ -56,71 +118,97 @@ func keyStreak(route, client string) string {
 // ------- Overrides -------
 
 func (m *RedisMitigator) GetOverride(ctx context.Context, route, client string) (*Override, error) {
-	b, err := m.rdb.Get(ctx, keyOverride(route, client)).Bytes()
-	if err == redis.Nil {
-		return nil, nil
-	}
-	if err != nil {
-		return nil, err
-	}
 	var ov Override
-	if err := json.Unmarshal(b, &ov); err != nil {
-		// Be lenient: if corrupt, drop it
-		_ = m.rdb.Del(ctx, keyOverride(route, client)).Err()
-		return nil, nil
+	// Be lenient: if corrupt, drop it
+	if ok, err := m.getJSON(ctx, keyOverride(route, client), &ov); !ok {
+		return nil, err
 	}
 	return &ov, nil
 }
 
 func (m *RedisMitigator) SetOverride(ctx context.Context, route, client string, ov Override, ttl time.Duration) error {
 	ov.Exp = time.Now().Add(ttl).Unix()
-	j, _ := json.Marshal(ov)
 	// NOTE: we intentionally DON'T increment Prometheus counters here to avoid
 	// double counting across code paths (detector/admin). Increment at call site.
-	return m.rdb.Set(ctx, keyOverride(route, client), j, ttl).Err()
+	return m.setJSON(ctx, keyOverride(route, client), ov, ttl)
 }
 
 func (m *RedisMitigator) ClearOverride(ctx context.Context, route, client string) error {
-	return m.rdb.Del(ctx, keyOverride(route, client)).Err()
+	return m.del(ctx, keyOverride(route, client))
+}
+
+// ListOverrides returns every active override (SCAN; intended for admin use, not the hot path).
+func (m *RedisMitigator) ListOverrides(ctx context.Context) ([]OverrideEntry, error) {
+	out := []OverrideEntry{}
+	err := m.scanKeys(ctx, "sg:override:*", func(k string) error {
+		route, client, ok := splitMitigationKey(k)
+		if !ok {
+			return nil
+		}
+		ov, err := m.GetOverride(ctx, route, client)
+		if err != nil {
+			return err
+		}
+		if ov != nil { // may have expired between SCAN and GET
+			out = append(out, OverrideEntry{Route: route, Client: client, Override: *ov})
+		}
+		return nil
+	})
+	return out, err
 }
 
 // -------- Blocks --------
 
 func (m *RedisMitigator) GetBlock(ctx context.Context, route, client string) (*Block, error) {
-	b, err := m.rdb.Get(ctx, keyBlock(route, client)).Bytes()
-	if err == redis.Nil {
-		return nil, nil
-	}
-	if err != nil {
-		return nil, err
-	}
 	var bl Block
-	if err := json.Unmarshal(b, &bl); err != nil {
-		_ = m.rdb.Del(ctx, keyBlock(route, client)).Err()
-		return nil, nil
+	if ok, err := m.getJSON(ctx, keyBlock(route, client), &bl); !ok {
+		return nil, err
 	}
 	return &bl, nil
 }
 
 func (m *RedisMitigator) SetBlock(ctx context.Context, route, client string, bl Block, ttl time.Duration) error {
 	bl.Exp = time.Now().Add(ttl).Unix()
-	j, _ := json.Marshal(bl)
 	// NOTE: counters should be incremented by the caller (e.g., detector) to avoid duplicates.
-	return m.rdb.Set(ctx, keyBlock(route, client), j, ttl).Err()
+	return m.setJSON(ctx, keyBlock(route, client), bl, ttl)
 }
 
 func (m *RedisMitigator) ClearBlock(ctx context.Context, route, client string) error {
-	return m.rdb.Del(ctx, keyBlock(route, client)).Err()
+	return m.del(ctx, keyBlock(route, client))
+}
+
+// ListBlocks returns every active block (SCAN; intended for admin use, not the hot path).
+func (m *RedisMitigator) ListBlocks(ctx context.Context) ([]BlockEntry, error) {
+	out := []BlockEntry{}
+	err := m.scanKeys(ctx, "sg:block:*", func(k string) error {
+		route, client, ok := splitMitigationKey(k)
+		if !ok {
+			return nil
+		}
+		bl, err := m.GetBlock(ctx, route, client)
+		if err != nil {
+			return err
+		}
+		if bl != nil {
+			out = append(out, BlockEntry{Route: route, Client: client, Block: *bl})
+		}
+		return nil
+	})
+	return out, err
 }
 
 // ---- Repeat-offender streak ----
 // Increment counter and keep it alive for the window.
 func (m *RedisMitigator) IncrStreak(ctx context.Context, route, client string, window time.Duration) (int64, error) {
 	k := keyStreak(route, client)
-	pipe := m.rdb.Pipeline()
-	inc := pipe.Incr(ctx, k)
-	pipe.Expire(ctx, k, window)
-	_, err := pipe.Exec(ctx)
+	var inc *redis.IntCmd
+	err := m.br.Do(func() error {
+		pipe := m.rdb.Pipeline()
+		inc = pipe.Incr(ctx, k)
+		pipe.Expire(ctx, k, window)
+		_, err := pipe.Exec(ctx)
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -128,7 +216,7 @@ func (m *RedisMitigator) IncrStreak(ctx context.Context, route, client string, w
 }
 
 func (m *RedisMitigator) ResetStreak(ctx context.Context, route, client string) error {
-	return m.rdb.Del(ctx, keyStreak(route, client)).Err()
+	return m.del(ctx, keyStreak(route, client))
 }
 
 // ---- Metrics scan helpers ----
@@ -168,25 +256,60 @@ func (m *RedisMitigator) RefreshActiveGauges(ctx context.Context) error {
 // Keys are of the form: "sg:override:<route>:<client>" or "sg:block:<route>:<client>"
 func (m *RedisMitigator) countByRoute(ctx context.Context, match string) (map[string]int, error) {
 	out := make(map[string]int)
+	err := m.scanKeys(ctx, match, func(k string) error {
+		if route, _, ok := splitMitigationKey(k); ok {
+			out[route]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// scanKeys walks every key matching the pattern and calls fn for each one.
+// On Cluster, SCAN is per node, so every master is scanned (fn is serialized).
+func (m *RedisMitigator) scanKeys(ctx context.Context, match string, fn func(key string) error) error {
+	cc, ok := m.rdb.(*redis.ClusterClient)
+	if !ok {
+		return scanNode(ctx, m.rdb, match, fn)
+	}
+	var mu sync.Mutex
+	return cc.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		return scanNode(ctx, node, match, func(k string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return fn(k)
+		})
+	})
+}
+
+func scanNode(ctx context.Context, rdb redis.Cmdable, match string, fn func(key string) error) error {
 	var cursor uint64
 	for {
-		keys, next, err := m.rdb.Scan(ctx, cursor, match, 1000).Result()
+		keys, next, err := rdb.Scan(ctx, cursor, match, 1000).Result()
 		if err != nil {
-			return nil, err
+			return err
 		}
 		for _, k := range keys {
-			parts := strings.SplitN(k, ":", 4) // ["sg","override","<route>","<client>"]
-			if len(parts) >= 3 {
-				route := parts[2]
-				if route != "" {
-					out[route]++
-				}
+			if err := fn(k); err != nil {
+				return err
 			}
 		}
 		cursor = next
 		if cursor == 0 {
-			break
+			return nil
 		}
 	}
-	return out, nil
+}
+
+// splitMitigationKey parses "sg:<kind>:<route>:<client>" into route and client.
+// Client IDs may contain ':' (IPv6), so everything after the route belongs to the client.
+func splitMitigationKey(k string) (route, client string, ok bool) {
+	parts := strings.SplitN(k, ":", 4) // ["sg","override","<route>","<client>"]
+	if len(parts) < 4 || parts[2] == "" {
+		return "", "", false
+	}
+	return parts[2], parts[3], true
 }
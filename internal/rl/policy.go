@@ -1,74 +1,109 @@
 package rl
 
 import (
-	"strings"
-
 	cfg "github.com/skywalker-88/stormgate/pkg/config"
 )
 
-// EffectiveLimit returns the per-route limit with fallback to the default.
-func EffectiveLimit(c *cfg.Config, route string) cfg.Limit {
+// EffectiveLimit returns the per-route limit of a client on plan ("" for
+// none) with fallback to the default. Unset per-route options
+// (on_store_error, priority, headers) inherit the default's.
+//
+// A plan resolves its own routes and default first; a plan without a
+// default keeps the top-level limit on routes it does not list, and what
+// the plan's limit leaves unset inherits from the top-level one. On a
+// route of limits.routes the plan does not list, the plan default only
+// sets rps and burst: the route keeps its concurrency, cost, windows and
+// every other option, so a bigger plan never escapes them.
+func EffectiveLimit(c *cfg.Config, route, plan string) cfg.Limit {
 	if c == nil {
 		return cfg.Limit{}
 	}
-	if l, ok := c.Limits.Routes[route]; ok {
+	l := effective(c.Limits, route)
+	p, ok := c.Plans.Tiers[plan]
+	if !ok {
+		return l
+	}
+	pl, ok := p.Routes[route]
+	switch {
+	case ok:
+		inherit(&pl, p.Default)
+	case p.Default.RPS == 0 && p.Default.Burst == 0:
+		return l
+	case isRoute(c.Limits, route):
+		if p.Default.RPS != 0 {
+			l.RPS = p.Default.RPS
+		}
+		if p.Default.Burst != 0 {
+			l.Burst = p.Default.Burst
+		}
 		return l
+	default:
+		pl = p.Default
 	}
-	return c.Limits.Default
+	inherit(&pl, l)
+	return pl
 }
 
-func EffectiveGlobalClientLimit(c *cfg.Config) (cfg.Limit, bool) {
-	if c == nil {
-		return cfg.Limit{}, false
+func isRoute(ls cfg.Limits, route string) bool {
+	_, ok := ls.Routes[route]
+	return ok
+}
+
+func effective(ls cfg.Limits, route string) cfg.Limit {
+	l, ok := ls.Routes[route]
+	if !ok {
+		return ls.Default
 	}
-	l := c.Limits.GlobalClient
-	enabled := l.RPS > 0 || l.Burst > 0
-	return l, enabled
+	inherit(&l, ls.Default)
+	return l
 }
 
-// IsAllowlisted returns true if the clientID is allowlisted for mitigation.
-// Supported patterns in config:
-//   - exact match: "1.2.3.4" or "partner-key-abc"
-//   - wildcard all: "*"
-//   - prefix match: "partner-*" (matches any clientID starting with "partner-")
-func IsAllowlisted(c *cfg.Config, clientID string) bool {
-	if c == nil {
-		return false
+func inherit(l *cfg.Limit, from cfg.Limit) {
+	if l.OnStoreError == "" {
+		l.OnStoreError = from.OnStoreError
 	}
-	alist := c.Mitigation.Allowlist.Clients
-	for _, pat := range alist {
-		switch {
-		case pat == clientID:
-			return true
-		case pat == "*":
-			return true
-		case strings.HasSuffix(pat, "*") && strings.HasPrefix(clientID, strings.TrimSuffix(pat, "*")):
-			return true
-		}
+	if l.Priority == "" {
+		l.Priority = from.Priority
+	}
+	if len(l.Headers) == 0 {
+		l.Headers = from.Headers
 	}
-	return false
 }
 
-func NormalizeRoute(c *cfg.Config, path string) string {
-	if c == nil {
-		return path
+// Store-error modes (config.Limit.OnStoreError).
+const (
+	OnStoreErrorAllow = "allow"
+	OnStoreErrorDeny  = "deny"
+	OnStoreErrorLocal = "local"
+)
+
+// StoreErrorMode normalizes l.OnStoreError (empty means fail open).
+func StoreErrorMode(l cfg.Limit) string {
+	if l.OnStoreError == "" {
+		return OnStoreErrorAllow
 	}
-	// exact match fast path
-	if _, ok := c.Limits.Routes[path]; ok {
-		return path
+	return l.OnStoreError
+}
+
+// EffectiveGlobalClientLimit returns the global_client limit of plan (the
+// top-level one unless the plan enables its own).
+func EffectiveGlobalClientLimit(c *cfg.Config, plan string) (cfg.Limit, bool) {
+	if c == nil {
+		return cfg.Limit{}, false
 	}
-	// longest prefix among configured routes
-	longest := ""
-	for r := range c.Limits.Routes {
-		if r == "" || r[0] != '/' {
-			continue
-		}
-		if strings.HasPrefix(path, r) && len(r) > len(longest) {
-			longest = r
-		}
+	l := c.Limits.GlobalClient
+	if p := c.Plans.Tiers[plan].GlobalClient; p.RPS > 0 || p.Burst > 0 {
+		l = p
 	}
-	if longest != "" {
-		return longest
+	enabled := l.RPS > 0 || l.Burst > 0
+	return l, enabled
+}
+
+// NormalizeRoute maps a request to its route key (see MatchRoute), or the
+// raw path when no configured route covers it.
+func NormalizeRoute(c *cfg.Config, method, path string) string {
+	if route := MatchRoute(c, method, path); route != "" {
+		return route
 	}
 	return path
 }
@@ -0,0 +1,62 @@
+package rl
+
+import (
+	"reflect"
+	"testing"
+
+	cfg "github.com/skywalker-88/stormgate/pkg/config"
+)
+
+func TestEffectiveLimit(t *testing.T) {
+	search := cfg.Limit{
+		RPS: 5, Burst: 10, Cost: 1, Algorithm: "sliding_window", MaxDelayMs: 200,
+		Concurrency:      cfg.Concurrency{PerClient: 2},
+		CostRules:        []cfg.CostRule{{Query: "limit", GTE: 100, Cost: 5}},
+		BodyCostPerBytes: 1024,
+		Windows:          []cfg.Window{{Limit: 1000, WindowSeconds: 3600}},
+	}
+	c := &cfg.Config{}
+	c.Limits = cfg.Limits{
+		Default: cfg.Limit{RPS: 20, Burst: 40, Cost: 1, OnStoreError: "deny", Headers: []string{"ietf"}},
+		Routes:  map[string]cfg.Limit{"/search": search, "/export": {RPS: 1, Burst: 1, Cost: 3}},
+	}
+	c.Plans.Tiers = map[string]cfg.Limits{
+		"pro": {
+			Default: cfg.Limit{RPS: 100, Burst: 200, Cost: 1},
+			Routes:  map[string]cfg.Limit{"/export": {RPS: 10, Burst: 10}},
+		},
+		"partner": {Routes: map[string]cfg.Limit{"/export": {RPS: 5, Burst: 5, Cost: 2}}},
+	}
+
+	withRate := func(l cfg.Limit, rps float64, burst int64) cfg.Limit {
+		l.RPS, l.Burst = rps, burst
+		return l
+	}
+	inherited := func(l cfg.Limit) cfg.Limit {
+		l.OnStoreError, l.Headers = "deny", []string{"ietf"}
+		return l
+	}
+	tests := []struct {
+		name        string
+		route, plan string
+		want        cfg.Limit
+	}{
+		{"no plan: route", "/search", "", inherited(search)},
+		{"no plan: default", "/other", "", c.Limits.Default},
+		{"unknown plan", "/search", "gold", inherited(search)},
+		// plan default on a configured route: only rps/burst change
+		{"plan default keeps route options", "/search", "pro", withRate(inherited(search), 100, 200)},
+		// plan route: the plan's own limit, unset options inherited
+		{"plan route", "/export", "pro", inherited(cfg.Limit{RPS: 10, Burst: 10})},
+		{"plan default on an unlisted path", "/other", "pro", inherited(cfg.Limit{RPS: 100, Burst: 200, Cost: 1})},
+		{"plan without default keeps the route", "/search", "partner", inherited(search)},
+		{"plan without default, plan route", "/export", "partner", inherited(cfg.Limit{RPS: 5, Burst: 5, Cost: 2})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EffectiveLimit(c, tt.route, tt.plan); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EffectiveLimit(%s, %q) =\n  %+v\nwant\n  %+v", tt.route, tt.plan, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+-- Redis Lua script extending a live lease (no-op once it expired or was released).
+-- KEYS[1] = lease zset key
+-- ARGV[1] = now_ms
+-- ARGV[2] = lease_ms
+-- ARGV[3] = lease id
+-- Returns: 1 if renewed, else 0
+
+local key      = KEYS[1]
+local now_ms   = tonumber(ARGV[1])
+local lease_ms = tonumber(ARGV[2])
+local id       = ARGV[3]
+
+local exp = tonumber(redis.call('ZSCORE', key, id))
+if exp == nil or exp <= now_ms then
+  return 0
+end
+redis.call('ZADD', key, now_ms + lease_ms, id)
+-- as in lease.lua: never cut short a longer lease of another request
+local last = redis.call('ZRANGE', key, -1, -1, 'WITHSCORES')
+redis.call('PEXPIRE', key, tonumber(last[2]) - now_ms + lease_ms)
+return 1
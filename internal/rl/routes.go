@@ -0,0 +1,115 @@
+package rl
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	cfg "github.com/skywalker-88/stormgate/pkg/config"
+)
+
+// routeRule is one compiled limits.routes key.
+type routeRule struct {
+	key string
+	cfg.RoutePattern
+}
+
+// routeTable is the compiled form of one config's routes, ordered from most
+// to least specific so the first match wins.
+type routeTable struct {
+	c     *cfg.Config
+	rules []routeRule
+}
+
+// routes caches the table of the live config; a reload swaps the *Config,
+// which triggers a recompile on the next lookup.
+var routes atomic.Pointer[routeTable]
+
+func routeTableFor(c *cfg.Config) *routeTable {
+	if t := routes.Load(); t != nil && t.c == c {
+		return t
+	}
+	t := &routeTable{c: c}
+	for key := range c.Limits.Routes {
+		p, err := cfg.ParseRoute(key)
+		if err != nil {
+			continue // rejected by Validate
+		}
+		t.rules = append(t.rules, routeRule{key: key, RoutePattern: p})
+	}
+	sort.Slice(t.rules, func(i, j int) bool { return moreSpecific(t.rules[i], t.rules[j]) })
+	routes.Store(t)
+	return t
+}
+
+// moreSpecific orders rules: more segments first, then segment by segment
+// literal > {param} > *, then method-specific before any-method.
+func moreSpecific(a, b routeRule) bool {
+	if na, nb := fixedLen(a.Segments), fixedLen(b.Segments); na != nb {
+		return na > nb
+	}
+	for i := 0; i < len(a.Segments) && i < len(b.Segments); i++ {
+		if ra, rb := segRank(a.Segments[i]), segRank(b.Segments[i]); ra != rb {
+			return ra > rb
+		}
+	}
+	if len(a.Segments) != len(b.Segments) {
+		return len(a.Segments) < len(b.Segments) // trailing * is less specific
+	}
+	if (a.Method != "") != (b.Method != "") {
+		return a.Method != ""
+	}
+	return a.key < b.key
+}
+
+func fixedLen(segs []string) int {
+	if n := len(segs); n > 0 && segs[n-1] == "*" {
+		return n - 1
+	}
+	return len(segs)
+}
+
+func segRank(s string) int {
+	switch {
+	case s == "*":
+		return 0
+	case strings.HasPrefix(s, "{"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// matches reports whether r covers the request: same method (if set) and
+// the path's leading segments match the template.
+func (r routeRule) matches(method string, segs []string) bool {
+	if r.Method != "" && r.Method != method && !(r.Method == "GET" && method == "HEAD") {
+		return false
+	}
+	n := fixedLen(r.Segments)
+	if len(segs) < n {
+		return false
+	}
+	for i := 0; i < n; i++ {
+		p := r.Segments[i]
+		if !strings.HasPrefix(p, "{") && p != segs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchRoute returns the limits.routes key that best matches the request,
+// or "" when none does.
+func MatchRoute(c *cfg.Config, method, path string) string {
+	if c == nil || len(c.Limits.Routes) == 0 {
+		return ""
+	}
+	segs := cfg.SplitPath(path)
+	for _, r := range routeTableFor(c).rules {
+		if r.matches(method, segs) {
+			return r.key
+		}
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+package rl
+
+import (
+	"testing"
+
+	cfg "github.com/skywalker-88/stormgate/pkg/config"
+)
+
+func TestMatchRoute(t *testing.T) {
+	c := &cfg.Config{}
+	c.Limits.Routes = map[string]cfg.Limit{}
+	for _, k := range []string{
+		"/api",
+		"/api/search",
+		"/api/users/{id}",
+		"/api/users/me",
+		"POST /api/users/{id}",
+		"/api/users/{id}/posts",
+		"GET /files/*",
+		"/files/public/*",
+		"/{tenant}/reports",
+	} {
+		c.Limits.Routes[k] = cfg.Limit{}
+	}
+	tests := []struct {
+		method, path, want string
+	}{
+		{"GET", "/api/search", "/api/search"},
+		{"GET", "/api/search/deep/x", "/api/search"},      // sub-paths are covered
+		{"GET", "/api/searchable", "/api"},                // but only on segment boundaries
+		{"GET", "/api/users/me", "/api/users/me"},         // literal beats {param}
+		{"GET", "/api/users/42", "/api/users/{id}"},       // param
+		{"POST", "/api/users/42", "POST /api/users/{id}"}, // method-specific beats any-method
+		{"POST", "/api/users/me", "/api/users/me"},        // a literal segment outranks the method
+		{"GET", "/api/users/42/posts", "/api/users/{id}/posts"},
+		{"GET", "/files/public/a.txt", "/files/public/*"}, // longer prefix beats a shorter wildcard
+		{"GET", "/files/private/a.txt", "GET /files/*"},
+		{"HEAD", "/files/private/a.txt", "GET /files/*"}, // GET routes cover HEAD
+		{"DELETE", "/files/private/a.txt", ""},
+		{"GET", "/acme/reports", "/{tenant}/reports"},
+		{"GET", "/api/reports", "/{tenant}/reports"}, // two fixed segments beat /api
+		{"GET", "//api///search//", "/api/search"},   // empty segments are ignored
+		{"GET", "/", ""},
+		{"GET", "/other", ""},
+	}
+	for _, tt := range tests {
+		if got := MatchRoute(c, tt.method, tt.path); got != tt.want {
+			t.Errorf("MatchRoute(%s %s) = %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
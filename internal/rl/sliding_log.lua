@@ -0,0 +1,66 @@
+-- Exact sliding log: one sorted-set entry per token, `burst` tokens per rolling window.
+-- Members are "<id>:<i>" so concurrent requests never collide. Adjustments may
+-- overdraw the log up to 2x limit entries.
+-- Returns: allowed(0/1), tokens_remaining, retry_after_ms, reset_ms
+
+alg.sliding_log = function(key, now_ms, _, limit, window_ms, cost, id, write)
+  -- entries at or before now-window have slid out
+  local live  = '(' .. (now_ms - window_ms)
+  local count = redis.call('ZCOUNT', key, live, '+inf')
+
+  local allowed  = 0
+  local retry_ms = 0
+  if count + cost <= limit then
+    if write then
+      redis.call('ZREMRANGEBYSCORE', key, '-inf', now_ms - window_ms)
+      for i = 1, cost do
+        redis.call('ZADD', key, now_ms, id .. ':' .. i)
+      end
+    end
+    count = count + cost
+    allowed = 1
+  else
+    -- the entry whose expiry frees enough room for `cost`
+    local idx = count + cost - limit - 1
+    local e = redis.call('ZRANGEBYSCORE', key, live, '+inf', 'WITHSCORES', 'LIMIT', idx, 1)
+    if e[2] then
+      retry_ms = tonumber(e[2]) + window_ms - now_ms
+    else
+      retry_ms = window_ms
+    end
+    if retry_ms < 1 then retry_ms = 1 end
+  end
+
+  local reset_ms = 0
+  if count > 0 then
+    local newest = now_ms
+    if not (allowed == 1 and cost > 0) then
+      newest = tonumber(redis.call('ZRANGE', key, -1, -1, 'WITHSCORES')[2])
+    end
+    reset_ms = newest + window_ms - now_ms
+    if write then
+      redis.call('PEXPIRE', key, reset_ms + 1000)
+    end
+  end
+  return allowed, math.max(0, limit - count), retry_ms, reset_ms
+end
+
+adj.sliding_log = function(key, now_ms, _, limit, window_ms, delta, id)
+  redis.call('ZREMRANGEBYSCORE', key, '-inf', now_ms - window_ms)
+  local count = redis.call('ZCARD', key)
+
+  if delta > 0 then
+    -- extra tokens are logged now
+    for i = 1, math.min(delta, 2 * limit - count) do
+      redis.call('ZADD', key, now_ms, id .. ':' .. i)
+    end
+  elseif delta < 0 and count > 0 then
+    -- refunds give back the newest tokens
+    redis.call('ZPOPMAX', key, math.min(-delta, count))
+  end
+
+  local newest = redis.call('ZRANGE', key, -1, -1, 'WITHSCORES')
+  if newest[2] then
+    redis.call('PEXPIRE', key, tonumber(newest[2]) + window_ms - now_ms + 1000)
+  end
+end
@@ -0,0 +1,79 @@
+-- Sliding-window counter with variable cost: `burst` tokens per rolling window.
+-- The estimate weights the previous window by how much of it still overlaps
+-- the rolling window: est = prev * (1 - elapsed/window) + cur.
+-- State: hash {cur, prev, start}. Adjustments may overdraw up to 2x limit.
+-- Returns: allowed(0/1), tokens_remaining, retry_after_ms, reset_ms
+
+alg.sliding_window = function(key, now_ms, _, limit, window_ms, cost, id, write)
+  local start = now_ms - (now_ms % window_ms)
+  local data  = redis.call('HMGET', key, 'cur', 'prev', 'start')
+  local cur   = tonumber(data[1]) or 0
+  local prev  = tonumber(data[2]) or 0
+  local saved = tonumber(data[3])
+
+  if saved ~= start then
+    if saved == start - window_ms then
+      prev = cur -- roll forward by one window
+    else
+      prev = 0   -- idle for more than a window
+    end
+    cur = 0
+  end
+
+  local elapsed = now_ms - start
+  local weight  = (window_ms - elapsed) / window_ms
+  local est     = prev * weight + cur
+
+  local allowed  = 0
+  local retry_ms = 0
+  if est + cost <= limit then
+    cur = cur + cost
+    est = est + cost
+    allowed = 1
+  else
+    local room = limit - cur - cost
+    if prev > 0 and room >= 0 then
+      -- wait until the previous window's weight has decayed enough
+      local need_weight = room / prev
+      retry_ms = math.ceil((1 - need_weight) * window_ms - elapsed)
+      if retry_ms > window_ms - elapsed then retry_ms = window_ms - elapsed end
+    else
+      retry_ms = window_ms - elapsed -- at least until the next window
+    end
+    if retry_ms < 1 then retry_ms = 1 end
+  end
+
+  if write then
+    redis.call('HSET', key, 'cur', cur, 'prev', prev, 'start', start)
+    redis.call('PEXPIRE', key, 2 * window_ms)
+  end
+
+  -- fully reset once both windows holding our counts have slid out
+  local reset_ms = window_ms - elapsed
+  if cur > 0 then reset_ms = reset_ms + window_ms end
+
+  local remaining = math.floor(limit - est)
+  if remaining < 0 then remaining = 0 end
+  return allowed, remaining, retry_ms, reset_ms
+end
+
+adj.sliding_window = function(key, now_ms, _, limit, window_ms, delta, id)
+  local start = now_ms - (now_ms % window_ms)
+  local data  = redis.call('HMGET', key, 'cur', 'prev', 'start')
+  local cur   = tonumber(data[1]) or 0
+  local prev  = tonumber(data[2]) or 0
+  local saved = tonumber(data[3])
+
+  if saved ~= start then
+    if saved == start - window_ms then
+      prev = cur
+    else
+      prev = 0
+    end
+    cur = 0
+  end
+  cur = math.max(0, math.min(2 * limit, cur + delta))
+
+  redis.call('HSET', key, 'cur', cur, 'prev', prev, 'start', start)
+  redis.call('PEXPIRE', key, 2 * window_ms)
+end
@@ -0,0 +1,58 @@
+-- Token bucket with variable cost: refill at `rate` tokens/s up to `burst`.
+-- State: hash {tokens, ts}; TTL ~ 2x full-refill time (never 0). Adjustments
+-- may overdraw the bucket down to -burst.
+-- Returns: allowed(0/1), tokens_remaining, retry_after_ms, reset_ms
+
+alg.token_bucket = function(key, now_ms, rate, burst, window_ms, cost, id, write)
+  local data = redis.call('HMGET', key, 'tokens', 'ts')
+  local tokens = tonumber(data[1])
+  local ts     = tonumber(data[2])
+
+  if tokens == nil then
+    tokens = burst
+    ts = now_ms
+  end
+
+  -- Refill based on elapsed time
+  local elapsed = (now_ms - ts) / 1000.0
+  if elapsed < 0 then elapsed = 0 end
+  tokens = math.min(burst, tokens + elapsed * rate)
+
+  local allowed  = 0
+  local retry_ms = 0
+  if tokens >= cost then
+    tokens = tokens - cost
+    allowed = 1
+  else
+    retry_ms = math.floor(((cost - tokens) / rate) * 1000 + 0.5)
+  end
+
+  if write then
+    redis.call('HSET', key, 'tokens', tokens, 'ts', now_ms)
+    local ttl = math.floor((burst / math.max(rate, 0.0001)) * 2 + 0.5)
+    if ttl < 1 then ttl = 1 end
+    redis.call('EXPIRE', key, ttl)
+  end
+
+  -- time to full reset (when bucket would be full again)
+  local reset_ms = math.floor(((burst - tokens) / math.max(rate, 0.0001)) * 1000 + 0.5)
+  local remaining = math.floor(tokens)
+  if remaining < 0 then remaining = 0 end
+  return allowed, remaining, retry_ms, reset_ms
+end
+
+adj.token_bucket = function(key, now_ms, rate, burst, window_ms, delta, id)
+  local data = redis.call('HMGET', key, 'tokens', 'ts')
+  local tokens = tonumber(data[1]) or burst
+  local ts     = tonumber(data[2]) or now_ms
+
+  local elapsed = (now_ms - ts) / 1000.0
+  if elapsed < 0 then elapsed = 0 end
+  tokens = math.min(burst, tokens + elapsed * rate) - delta
+  tokens = math.max(-burst, math.min(burst, tokens))
+
+  redis.call('HSET', key, 'tokens', tokens, 'ts', now_ms)
+  local ttl = math.floor((burst / math.max(rate, 0.0001)) * 2 + 0.5)
+  if ttl < 1 then ttl = 1 end
+  redis.call('EXPIRE', key, ttl)
+end
@@ -1,7 +1,10 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/file"
@@ -11,20 +14,138 @@ import (
 // ---- Server configuration ----
 
 type Server struct {
-	Addr string `yaml:"addr"`
+	Addr string    `yaml:"addr"`
+	TLS  ServerTLS `yaml:"tls"`
+
+	// Expect a PROXY protocol v1/v2 header on every connection (behind a
+	// TCP load balancer); only peers in trusted_proxies may send one.
+	ProxyProtocol bool `yaml:"proxy_protocol"`
+}
+
+// ServerTLS serves HTTPS; with client_ca_file, client certificates are
+// requested and verified (identity source mtls).
+type ServerTLS struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
 }
 
 type Identity struct {
-	// "header:X-API-Key" or "ip"
+	// Shorthand for a one-source chain: "header:X-API-Key" or "ip".
 	Source string `yaml:"source"`
+
+	// Ordered chain; the first source yielding a value identifies the
+	// client. The IP is the implicit last resort (the client is then
+	// anonymous).
+	Sources []IdentitySource `yaml:"sources"`
+
+	// Proxies (CIDRs or IPs) whose forwarded_header entries are
+	// believed. The client IP is the first hop, walking right to left from
+	// the peer, that is not a trusted proxy; empty trusts no headers.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	// The one header trusted proxies write the client into:
+	// "x-forwarded-for" (default) or "forwarded" (RFC 7239). The other is
+	// ignored, since a proxy that only sets one passes the client's own
+	// copy of the other straight through.
+	ForwardedHeader string `yaml:"forwarded_header"`
+
+	// Clients known by their IP are keyed by this prefix of it, so one
+	// host with a whole IPv6 /64 is still one client.
+	IPPrefix IPPrefix `yaml:"ip_prefix"`
+
+	// Shedding classes of callers (see shedding.classes).
+	Priority IdentityPriority `yaml:"priority"`
+}
+
+type IPPrefix struct {
+	V4 int `yaml:"v4"` // default 32
+	V6 int `yaml:"v6"` // default 64
+}
+
+type IdentitySource struct {
+	Type string `yaml:"type"` // header | cookie | query | jwt | mtls | ip | composite
+
+	// header / cookie / query: the name to read. jwt: the header carrying
+	// the token (default Authorization, "Bearer " is stripped).
+	Name string `yaml:"name"`
+
+	// jwt: claim path (e.g. sub, org.id). Without hmac_secret(_env) or
+	// public_key_file the token is decoded unverified: use that only behind
+	// a gateway that already verified it.
+	Claim         string `yaml:"claim"`
+	HMACSecret    string `yaml:"hmac_secret"`     // HS256/384/512
+	HMACSecretEnv string `yaml:"hmac_secret_env"` // env var holding the HMAC secret
+	PublicKeyFile string `yaml:"public_key_file"` // PEM; RS*, PS*, ES*
+
+	// mtls: cn (default) | subject | fingerprint (SHA-256 of the certificate)
+	Field string `yaml:"field"`
+
+	// Replace the value by its SHA-256 (hex, 32 chars) so raw keys never
+	// reach Redis, logs or metrics.
+	Hash bool `yaml:"hash"`
+
+	// composite: every part must yield a value; they are joined with "+"
+	// (e.g. tenant header + JWT sub).
+	Parts []IdentitySource `yaml:"parts"`
+}
+
+type IdentityPriority struct {
+	Anonymous string            `yaml:"anonymous"` // no identity header; fell back to the IP
+	Clients   map[string]string `yaml:"clients"`   // client ID -> class
 }
 
 // ---- Redis configuration ----
 
+type RedisTLS struct {
+	Enabled            bool   `yaml:"enabled"`
+	ServerName         string `yaml:"server_name"` // SNI / verification name; defaults to addr (single mode only)
+	CAFile             string `yaml:"ca_file"`     // PEM bundle; system roots when empty
+	CertFile           string `yaml:"cert_file"`   // client cert for mTLS (with key_file)
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // dev only
+}
+
+// Breaker trips after FailureThreshold consecutive Redis errors and skips
+// Redis for OpenMs before letting a single probe through.
+type Breaker struct {
+	FailureThreshold int `yaml:"failure_threshold"`
+	OpenMs           int `yaml:"open_ms"`
+}
+
 type Redis struct {
-	Addr     string `yaml:"addr"`
-	DB       int    `yaml:"db"`
-	Password string `yaml:"password"`
+	// "single" (default), "sentinel" or "cluster".
+	Mode string `yaml:"mode"`
+	Addr string `yaml:"addr"` // single mode
+	// Sentinel addresses (sentinel mode) or seed nodes (cluster mode).
+	Addrs            []string `yaml:"addrs"`
+	MasterName       string   `yaml:"master_name"` // sentinel mode
+	SentinelUsername string   `yaml:"sentinel_username"`
+	SentinelPassword string   `yaml:"sentinel_password"`
+
+	Username string   `yaml:"username"` // Redis 6+ ACL user; empty = legacy AUTH
+	Password string   `yaml:"password"`
+	DB       int      `yaml:"db"`
+	TLS      RedisTLS `yaml:"tls"`
+
+	Breaker Breaker `yaml:"breaker"`
+
+	// Pool sizing and timeouts; 0 keeps the go-redis default.
+	PoolSize       int `yaml:"pool_size"`
+	MinIdleConns   int `yaml:"min_idle_conns"`
+	DialTimeoutMs  int `yaml:"dial_timeout_ms"`
+	ReadTimeoutMs  int `yaml:"read_timeout_ms"`
+	WriteTimeoutMs int `yaml:"write_timeout_ms"`
+}
+
+// ---- Limiter backend ----
+
+type Limiter struct {
+	// "redis" (default; shared across replicas) or "memory" (single instance, no Redis).
+	Backend string `yaml:"backend"`
+	// Expected number of protector replicas. Per-instance buckets (the memory
+	// backend and the on_store_error=local fallback) get 1/replicas of each limit.
+	Replicas int `yaml:"replicas"`
 }
 
 // ---- Rate limiting policy ----
@@ -33,12 +154,117 @@ type Limit struct {
 	RPS   float64 `yaml:"rps"`
 	Burst int64   `yaml:"burst"`
 	Cost  int64   `yaml:"cost"`
+
+	// token_bucket (default) | gcra | fixed_window | sliding_window | sliding_log.
+	// Window algorithms admit `burst` tokens per `window_seconds`
+	// (default burst/rps; rps may then be omitted).
+	Algorithm     string `yaml:"algorithm"`
+	WindowSeconds int    `yaml:"window_seconds"`
+
+	// Extra quotas enforced together with rps/burst (e.g. 500/min and
+	// 50000/day); a request is admitted only if every window has room.
+	Windows []Window `yaml:"windows"`
+
+	// Per-request cost: the highest `cost` among matching rules (at least
+	// `cost`), plus one per started `body_cost_per_bytes` of Content-Length.
+	// Capped at the smallest bucket so a request always fits a full bucket.
+	CostRules        []CostRule `yaml:"cost_rules"`
+	BodyCostPerBytes int64      `yaml:"body_cost_per_bytes"`
+
+	// Post-response correction of the up-front charge (zero = off).
+	Reconcile Reconcile `yaml:"reconcile"`
+
+	// In-flight request caps (zero = off).
+	Concurrency Concurrency `yaml:"concurrency"`
+
+	// Wait up to max_delay_ms for tokens instead of denying at once (nginx
+	// limit_req without nodelay). Waiters are served in arrival order; at most
+	// max_queue requests of the route wait per instance (default 100), a
+	// quarter of them from one client. The rest are denied immediately.
+	MaxDelayMs int `yaml:"max_delay_ms"`
+	MaxQueue   int `yaml:"max_queue"`
+
+	// Rate-limit response headers: "legacy" (X-RateLimit-*, X-ClientRateLimit-*),
+	// "ietf" (RateLimit-Policy / RateLimit structured fields) or "none".
+	// Routes without a value inherit limits.default; default is legacy.
+	Headers []string `yaml:"headers"`
+
+	// Denial responses by reason (denied, blocked, rate_limited,
+	// rate_limited_global, rate_limited_aggregate, rate_limited_tenant,
+	// concurrency_limited, store_unavailable, overloaded). Routes fall back
+	// to limits.default's entry per reason; without one the built-in RFC 9457
+	// problem details (or HTML / plain text, per Accept) are sent.
+	Responses map[string]Response `yaml:"responses"`
+
+	// Shedding class of the route (see shedding.classes); routes without a
+	// value inherit limits.default.
+	Priority string `yaml:"priority"`
+
+	// What to do when Redis is unavailable: "allow" (fail open, default),
+	// "deny" (fail closed, 503) or "local" (per-instance in-memory bucket).
+	// Routes without a value inherit limits.default.
+	OnStoreError string `yaml:"on_store_error"`
+}
+
+// Window is an additional quota of `limit` tokens per `window_seconds`.
+// Algorithm defaults to sliding_window.
+type Window struct {
+	Limit         int64  `yaml:"limit"`
+	WindowSeconds int    `yaml:"window_seconds"`
+	Algorithm     string `yaml:"algorithm"`
+}
+
+// CostRule charges `cost` when one request attribute matches. Set exactly one
+// source (query, query_range, header or content_length) and one condition:
+// gte (numeric; for query_range the span in days) or equals (query/header).
+type CostRule struct {
+	Query         string    `yaml:"query"`
+	QueryRange    [2]string `yaml:"query_range"` // [from, to] params; RFC 3339 or YYYY-MM-DD
+	Header        string    `yaml:"header"`
+	ContentLength bool      `yaml:"content_length"`
+
+	GTE    float64 `yaml:"gte"`
+	Equals string  `yaml:"equals"`
+
+	Cost int64 `yaml:"cost"`
+}
+
+// Reconcile charges extra tokens for every full slow_ms the handler (upstream)
+// took and every full large_bytes it returned, and refunds up to refund_cached
+// tokens of the charge when the response is a 304 or a cache hit (X-Cache: HIT).
+type Reconcile struct {
+	SlowMs       int   `yaml:"slow_ms"`
+	SlowCost     int64 `yaml:"slow_cost"`
+	LargeBytes   int64 `yaml:"large_bytes"`
+	LargeCost    int64 `yaml:"large_cost"`
+	RefundCached int64 `yaml:"refund_cached"`
+}
+
+// Concurrency caps requests in flight: per client on the route and across
+// all clients on the route (on global_client: per client on all routes).
+// Each request holds a lease of lease_seconds (default 30), renewed while it
+// runs. Denials answer status (429 default, or 503).
+type Concurrency struct {
+	PerClient    int64 `yaml:"per_client"`
+	Route        int64 `yaml:"route"`
+	LeaseSeconds int   `yaml:"lease_seconds"`
+	Status       int   `yaml:"status"`
 }
 
 type Limits struct {
 	Default      Limit            `yaml:"default"`
 	Routes       map[string]Limit `yaml:"routes"`
 	GlobalClient Limit            `yaml:"global_client"`
+
+	// Shared buckets of whole networks (e.g. a v4 /24 or v6 /48), charged
+	// atomically with the client's own buckets for clients known by IP.
+	IPAggregates []IPAggregate `yaml:"ip_aggregates"`
+}
+
+type IPAggregate struct {
+	V4    int   `yaml:"v4"` // prefix length for IPv4 clients; 0 skips them
+	V6    int   `yaml:"v6"` // prefix length for IPv6 clients; 0 skips them
+	Limit Limit `yaml:"limit"`
 }
 
 // ---- Anomaly detection policy ----
@@ -54,6 +280,18 @@ type Anomaly struct {
 	KeepSuspiciousSeconds int     `yaml:"keep_suspicious_seconds"`
 }
 
+// Response customizes one denial. Templates map a media type to a Go
+// template body (data: .Reason .Status .Title .Detail .Route .Path
+// .RetryAfter .RequestID); the best match for Accept is sent. Values are
+// HTML-escaped for text/html and JSON-string-escaped for JSON types (quote
+// them in the template). Redirect answers with a redirect (302 unless
+// status is 3xx) instead.
+type Response struct {
+	Status    int               `yaml:"status"`
+	Redirect  string            `yaml:"redirect"`
+	Templates map[string]string `yaml:"templates"`
+}
+
 // ---- Mitigation policy ----
 
 type StepRamp struct {
@@ -68,7 +306,7 @@ type RepeatOffender struct {
 }
 
 type Allowlist struct {
-	Clients []string `yaml:"clients"` // client IDs (IP or API key) that skip mitigation
+	Clients []string `yaml:"clients"` // client IDs (IP or API key) that skip mitigation; see also access.allow
 }
 
 type Mitigation struct {
@@ -81,24 +319,145 @@ type Mitigation struct {
 	Allowlist          Allowlist      `yaml:"allowlist"`
 }
 
+// ---- Adaptive upstream protection ----
+
+// Adaptive tightens every client of a proxied route while the backend is
+// unhealthy: the route limit is scaled by a per-route factor that drops
+// multiplicatively on a bad interval and recovers additively (AIMD).
+type Adaptive struct {
+	Enabled         bool    `yaml:"enabled"`
+	IntervalSeconds int     `yaml:"interval_seconds"` // evaluation period (default 1)
+	WindowSeconds   int     `yaml:"window_seconds"`   // samples considered (default 10)
+	MinSamples      int     `yaml:"min_samples"`      // fewer samples count as healthy (default 20)
+	LatencyP95Ms    int     `yaml:"latency_p95_ms"`   // unhealthy above this p95; 0 ignores latency
+	ErrorRate       float64 `yaml:"error_rate"`       // unhealthy above this 5xx share; 0 ignores errors
+	Decrease        float64 `yaml:"decrease"`         // factor multiplier on a bad interval (default 0.7)
+	Increase        float64 `yaml:"increase"`         // factor added per healthy interval (default 0.05)
+	MinFactor       float64 `yaml:"min_factor"`       // the factor never drops below this (default 0.1)
+}
+
+// ---- Load shedding ----
+
+// Shedding drops low-priority requests first once this instance is busy.
+// Utilization is requests in flight over max_in_flight; a request is shed
+// when utilization exceeds the shed_at of its class.
+type Shedding struct {
+	Enabled      bool        `yaml:"enabled"`
+	MaxInFlight  int64       `yaml:"max_in_flight"`
+	Classes      []ShedClass `yaml:"classes"`
+	DefaultClass string      `yaml:"default_class"` // class when nothing else applies
+	Header       string      `yaml:"header"`        // optional header naming the class (set it at a trusted edge)
+	Status       int         `yaml:"status"`        // default 503
+}
+
+type ShedClass struct {
+	Name   string  `yaml:"name"`
+	ShedAt float64 `yaml:"shed_at"` // e.g. 0.7 sheds above 70% utilization
+}
+
+// ---- Access lists ----
+
+// Access lists clients by IP, CIDR, client ID or glob ("partner-*").
+// Allowed clients skip blocks, overrides and detection (but not their
+// limits) and are never denied; denied ones are rejected before any limit.
+type Access struct {
+	Allow          AccessList `yaml:"allow"` // mitigation.allowlist.clients are merged in
+	Deny           AccessList `yaml:"deny"`
+	RefreshSeconds int        `yaml:"refresh_seconds"` // files and Redis sets are re-read this often (default 10)
+}
+
+type AccessList struct {
+	Entries  []string `yaml:"entries"`
+	Files    []string `yaml:"files"`     // one entry per line; '#' starts a comment
+	RedisSet string   `yaml:"redis_set"` // set shared by all replicas (redis backend only)
+}
+
+// ---- Plans ----
+
+// Plans give clients their own limit profiles (free, pro, ...). A client's
+// plan comes from its client ID in keys, file or redis_hash (later sources
+// win), else anonymous. A plan's limits resolve like the top-level ones;
+// what a plan leaves unset falls back to the top-level limits. On a route
+// of limits.routes the plan does not list, its default sets only rps and
+// burst; the route's other options stay.
+type Plans struct {
+	Anonymous      string            `yaml:"anonymous"`  // plan of unmapped clients (default "anonymous")
+	Keys           map[string]string `yaml:"keys"`       // client ID -> plan
+	File           string            `yaml:"file"`       // "<client ID> <plan>" per line; '#' starts a comment
+	RedisHash      string            `yaml:"redis_hash"` // field: client ID, value: plan (redis backend only)
+	RefreshSeconds int               `yaml:"refresh_seconds"`
+	Tiers          map[string]Limits `yaml:"tiers"` // default, routes and global_client per plan
+}
+
+// ---- Tenants ----
+
+// Tenants group clients (API keys) into organizations with an org-wide
+// bucket, charged atomically with the client's global_client bucket (per
+// key) and route bucket (per route). A client's org is its entry in keys,
+// else what source yields; clients without an org, and clients known by IP,
+// have no org bucket.
+type Tenants struct {
+	Enabled bool                   `yaml:"enabled"`
+	Source  IdentitySource         `yaml:"source"` // e.g. { type: header, name: X-Org } or { type: jwt, claim: org }
+	Keys    map[string]string      `yaml:"keys"`   // client ID -> org
+	Default TenantQuota            `yaml:"default"`
+	Orgs    map[string]TenantQuota `yaml:"orgs"` // per-org quotas, replacing default
+	// Tenant labels on metrics: orgs in tenants.orgs, plus the first
+	// metrics_max_tenants others seen by this instance; the rest are "other".
+	MetricsMaxTenants int `yaml:"metrics_max_tenants"` // default 50
+}
+
+type TenantQuota struct {
+	Limit Limit `yaml:"limit"`
+	// shared (default): the org's keys draw from one pool, first come
+	// first served. split: each key is also held to its share of the org
+	// limit (split_ways equal parts), so one key cannot starve the others.
+	Fairness string `yaml:"fairness"`
+	// Default: keys mapped to the org in tenants.keys (at least 1); required
+	// with tenants.source, whose orgs have no known key count.
+	SplitWays int `yaml:"split_ways"`
+}
+
+// ---- Admin API ----
+
+type Admin struct {
+	// Bearer token required on /admin/*; while empty every admin request is
+	// refused. Read per request, so a reload can set or rotate it.
+	Token string `yaml:"token"`
+}
+
 // ---------------------------
 
 type Config struct {
 	Server     Server     `yaml:"server"`
 	Redis      Redis      `yaml:"redis"`
+	Limiter    Limiter    `yaml:"limiter"`
 	Identity   Identity   `yaml:"identity"`
 	Limits     Limits     `yaml:"limits"`
 	Anomaly    Anomaly    `yaml:"anomaly"`
 	Mitigation Mitigation `yaml:"mitigation"`
+	Adaptive   Adaptive   `yaml:"adaptive"`
+	Shedding   Shedding   `yaml:"shedding"`
+	Access     Access     `yaml:"access"`
+	Plans      Plans      `yaml:"plans"`
+	Tenants    Tenants    `yaml:"tenants"`
+	Admin      Admin      `yaml:"admin"`
+}
+
+// Path returns the policy file location (STORMGATE_CONFIG or the default).
+func Path() string {
+	return MustEnv("STORMGATE_CONFIG", "configs/policies.yaml")
 }
 
 func Load() (*Config, error) {
-	// Allow env override if caller passes empty path.
-	path := os.Getenv("STORMGATE_CONFIG")
-	if path == "" {
-		path = "configs/policies.yaml"
-	}
+	return LoadFile(Path())
+}
 
+// LoadFile parses the policy file at path and applies env overrides.
+// It is used both at startup and on every reload.
+//
+// Precedence: env vars > policies.yaml > built-in defaults.
+func LoadFile(path string) (*Config, error) {
 	k := koanf.New(".")
 	if err := k.Load(file.Provider(path), yaml.Parser()); err != nil {
 		return nil, err
@@ -109,9 +468,75 @@ func Load() (*Config, error) {
 	}); err != nil {
 		return nil, err
 	}
+	if err := applyEnv(&cfg); err != nil {
+		return nil, err
+	}
+	applyDefaults(&cfg)
 	return &cfg, nil
 }
 
+// applyEnv overlays deployment-specific settings from the environment.
+func applyEnv(c *Config) error {
+	c.Server.Addr = MustEnv("STORMGATE_HTTP_ADDR", c.Server.Addr)
+	c.Limiter.Backend = MustEnv("STORMGATE_LIMITER_BACKEND", c.Limiter.Backend)
+	c.Redis.Mode = MustEnv("REDIS_MODE", c.Redis.Mode)
+	c.Redis.Addr = MustEnv("REDIS_ADDR", c.Redis.Addr)
+	if v := os.Getenv("REDIS_ADDRS"); v != "" {
+		c.Redis.Addrs = nil
+		for _, a := range strings.Split(v, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				c.Redis.Addrs = append(c.Redis.Addrs, a)
+			}
+		}
+	}
+	c.Redis.MasterName = MustEnv("REDIS_MASTER_NAME", c.Redis.MasterName)
+	c.Redis.SentinelUsername = MustEnv("REDIS_SENTINEL_USERNAME", c.Redis.SentinelUsername)
+	c.Redis.SentinelPassword = MustEnv("REDIS_SENTINEL_PASSWORD", c.Redis.SentinelPassword)
+	c.Redis.Username = MustEnv("REDIS_USERNAME", c.Redis.Username)
+	c.Redis.Password = MustEnv("REDIS_PASSWORD", c.Redis.Password)
+	c.Admin.Token = MustEnv("STORMGATE_ADMIN_TOKEN", c.Admin.Token)
+	if v := os.Getenv("REDIS_DB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("REDIS_DB: %w", err)
+		}
+		c.Redis.DB = n
+	}
+	if v := os.Getenv("REDIS_TLS"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("REDIS_TLS: %w", err)
+		}
+		c.Redis.TLS.Enabled = b
+	}
+	return nil
+}
+
+// applyDefaults fills settings left empty by both the YAML and the environment.
+func applyDefaults(c *Config) {
+	if c.Server.Addr == "" {
+		c.Server.Addr = ":8080"
+	}
+	if c.Redis.Mode == "" {
+		c.Redis.Mode = "single"
+	}
+	if c.Redis.Addr == "" && c.Redis.Mode == "single" {
+		c.Redis.Addr = "redis:6379"
+	}
+	if c.Limiter.Backend == "" {
+		c.Limiter.Backend = "redis"
+	}
+	if c.Limiter.Replicas == 0 {
+		c.Limiter.Replicas = 1
+	}
+	if c.Redis.Breaker.FailureThreshold == 0 {
+		c.Redis.Breaker.FailureThreshold = 5
+	}
+	if c.Redis.Breaker.OpenMs == 0 {
+		c.Redis.Breaker.OpenMs = 2000
+	}
+}
+
 func MustEnv(key, def string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/knadh/koanf/providers/file"
+)
+
+// Holder is the live policy shared by the limiter, detector and router.
+// Readers call Get() per request; Swap() replaces it atomically on reload.
+type Holder struct {
+	cur  atomic.Pointer[Config]
+	mu   sync.Mutex
+	subs []func(*Config)
+}
+
+func NewHolder(c *Config) *Holder {
+	h := &Holder{}
+	h.cur.Store(c)
+	return h
+}
+
+func (h *Holder) Get() *Config { return h.cur.Load() }
+
+// Swap installs c and notifies subscribers (in registration order).
+func (h *Holder) Swap(c *Config) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cur.Store(c)
+	for _, fn := range h.subs {
+		fn(c)
+	}
+}
+
+// OnChange registers fn to run after every Swap. Components that derive state
+// from the config (e.g. detector windows) use this instead of polling.
+func (h *Holder) OnChange(fn func(*Config)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs = append(h.subs, fn)
+}
+
+// Watch calls onChange whenever the file at path is written or replaced
+// (including the symlink swap used by Kubernetes ConfigMaps).
+// The returned stop func ends the watch. Removing the file ends the watch too
+// (reported through onError); SIGHUP remains available as a manual trigger.
+func Watch(path string, onChange func(), onError func(error)) (func(), error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	f := file.Provider(abs)
+	err = f.Watch(func(_ interface{}, err error) {
+		if err != nil {
+			if onError != nil {
+				onError(err)
+			}
+			return
+		}
+		onChange()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() { _ = f.Unwatch() }, nil
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RoutePattern is a parsed limits.routes key: an optional HTTP method and a
+// chi-style path template, e.g. "POST /api/orders", "/api/users/{id}" or
+// "GET /files/*". A pattern also covers everything below it on a segment
+// boundary ("/api/search" matches "/api/search/x" but not "/api/searchable").
+type RoutePattern struct {
+	Method   string   // "" = any method
+	Segments []string // literal, "{name}" (one segment) or "*" (the rest; last only)
+}
+
+var routeMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true,
+	"DELETE": true, "OPTIONS": true, "CONNECT": true, "TRACE": true,
+}
+
+// ParseRoute parses a limits.routes key.
+func ParseRoute(key string) (RoutePattern, error) {
+	var p RoutePattern
+	path := strings.TrimSpace(key)
+	if m, rest, ok := strings.Cut(path, " "); ok {
+		if !routeMethods[m] {
+			return p, fmt.Errorf("unknown method %q (want an upper-case HTTP method)", m)
+		}
+		p.Method, path = m, strings.TrimSpace(rest)
+	}
+	if !strings.HasPrefix(path, "/") {
+		return p, fmt.Errorf("path must start with '/'")
+	}
+	if strings.Contains(path, ":") {
+		// ':' separates route and client in mitigation keys
+		return p, fmt.Errorf("':' is not allowed (regexp params are not supported; use {name})")
+	}
+	p.Segments = SplitPath(path)
+	for i, s := range p.Segments {
+		switch {
+		case s == "*":
+			if i != len(p.Segments)-1 {
+				return p, fmt.Errorf("'*' must be the last segment")
+			}
+		case strings.HasPrefix(s, "{") || strings.HasSuffix(s, "}"):
+			if len(s) < 3 || !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") || strings.ContainsAny(s[1:len(s)-1], "{}") {
+				return p, fmt.Errorf("malformed param %q (want {name})", s)
+			}
+		case strings.ContainsAny(s, "{}*"):
+			return p, fmt.Errorf("segment %q mixes literal text with a param or '*'", s)
+		}
+	}
+	return p, nil
+}
+
+// String renders p in canonical form (param names dropped), so two keys that
+// match the same requests compare equal.
+func (p RoutePattern) String() string {
+	segs := make([]string, len(p.Segments))
+	for i, s := range p.Segments {
+		if strings.HasPrefix(s, "{") {
+			s = "{}"
+		}
+		segs[i] = s
+	}
+	out := "/" + strings.Join(segs, "/")
+	if p.Method != "" {
+		out = p.Method + " " + out
+	}
+	return out
+}
+
+// SplitPath splits a URL path into its non-empty segments.
+func SplitPath(path string) []string {
+	var segs []string
+	for _, s := range strings.Split(path, "/") {
+		if s != "" {
+			segs = append(segs, s)
+		}
+	}
+	return segs
+}
@@ -0,0 +1,40 @@
+package config
+
+import "testing"
+
+func TestParseRoute(t *testing.T) {
+	tests := []struct {
+		key     string
+		want    string // canonical form
+		wantErr string
+	}{
+		{key: "/api/search", want: "/api/search"},
+		{key: "  /api//search/ ", want: "/api/search"},
+		{key: "POST /api/orders", want: "POST /api/orders"},
+		{key: "/api/users/{id}", want: "/api/users/{}"},
+		{key: "GET /files/*", want: "GET /files/*"},
+		{key: "/", want: "/"},
+		{key: "post /api", wantErr: `unknown method "post" (want an upper-case HTTP method)`},
+		{key: "api/search", wantErr: "path must start with '/'"},
+		{key: "/api/{id:[0-9]+}", wantErr: "':' is not allowed (regexp params are not supported; use {name})"},
+		{key: "/files/*/x", wantErr: "'*' must be the last segment"},
+		{key: "/users/{}", wantErr: `malformed param "{}" (want {name})`},
+		{key: "/users/{id", wantErr: `malformed param "{id" (want {name})`},
+		{key: "/users/id}", wantErr: `malformed param "id}" (want {name})`},
+		{key: "/users/v{id}", wantErr: `malformed param "v{id}" (want {name})`},
+		{key: "/files/a*", wantErr: `segment "a*" mixes literal text with a param or '*'`},
+	}
+	for _, tt := range tests {
+		p, err := ParseRoute(tt.key)
+		switch {
+		case tt.wantErr != "":
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("ParseRoute(%q) error = %v, want %q", tt.key, err, tt.wantErr)
+			}
+		case err != nil:
+			t.Errorf("ParseRoute(%q): %v", tt.key, err)
+		case p.String() != tt.want:
+			t.Errorf("ParseRoute(%q) = %q, want %q", tt.key, p.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,693 @@
+package config
+
+import (
+	"encoding/pem"
+	"fmt"
+	"maps"
+	"mime"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// FieldError is a single policy problem, located by its YAML path
+// (e.g. `limits.routes["/search"].rps`).
+type FieldError struct {
+	Path string
+	Msg  string
+}
+
+func (e FieldError) Error() string { return e.Path + ": " + e.Msg }
+
+// ValidationError lists every problem found by Validate, section by section
+// (map entries such as routes sorted by key), not in file order.
+type ValidationError []FieldError
+
+func (v ValidationError) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = e.Error()
+	}
+	return fmt.Sprintf("%d config problem(s): %s", len(v), strings.Join(msgs, "; "))
+}
+
+type validator struct{ errs ValidationError }
+
+func (v *validator) add(path, format string, args ...any) {
+	v.errs = append(v.errs, FieldError{Path: path, Msg: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks the whole policy and returns a ValidationError describing
+// every problem, or nil. Startup refuses to run on an invalid policy and a
+// reload that fails validation is dropped (the previous policy stays active).
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	// ---- server / redis ----
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		v.add("server.addr", "must be host:port (got %q)", c.Server.Addr)
+	}
+	switch c.Redis.Mode {
+	case "single":
+		if _, _, err := net.SplitHostPort(c.Redis.Addr); err != nil {
+			v.add("redis.addr", "must be host:port (got %q)", c.Redis.Addr)
+		}
+	case "sentinel", "cluster":
+		if len(c.Redis.Addrs) == 0 {
+			v.add("redis.addrs", "must list at least one host:port in %s mode", c.Redis.Mode)
+		}
+		for i, a := range c.Redis.Addrs {
+			if _, _, err := net.SplitHostPort(a); err != nil {
+				v.add(fmt.Sprintf("redis.addrs[%d]", i), "must be host:port (got %q)", a)
+			}
+		}
+		if c.Redis.Mode == "sentinel" && c.Redis.MasterName == "" {
+			v.add("redis.master_name", "is required in sentinel mode")
+		}
+		if c.Redis.Mode == "cluster" && c.Redis.DB != 0 {
+			v.add("redis.db", "must be 0 in cluster mode (got %d)", c.Redis.DB)
+		}
+	default:
+		v.add("redis.mode", "unknown mode %q (want single, sentinel or cluster)", c.Redis.Mode)
+	}
+	if c.Redis.DB < 0 {
+		v.add("redis.db", "must be >= 0 (got %d)", c.Redis.DB)
+	}
+	if (c.Redis.TLS.CertFile == "") != (c.Redis.TLS.KeyFile == "") {
+		v.add("redis.tls", "cert_file and key_file must be set together")
+	}
+	if t := c.Redis.TLS; t.Enabled && c.Redis.Mode != "single" && t.ServerName == "" && !t.InsecureSkipVerify {
+		// Nodes are discovered at runtime; no seed host names them all.
+		v.add("redis.tls.server_name", "is required with TLS in %s mode (or set insecure_skip_verify)", c.Redis.Mode)
+	}
+	if (c.Redis.TLS.CAFile != "" || c.Redis.TLS.CertFile != "") && !c.Redis.TLS.Enabled {
+		v.add("redis.tls.enabled", "must be true when ca_file or cert_file is set")
+	}
+	v.nonNegative("redis.pool_size", c.Redis.PoolSize)
+	v.nonNegative("redis.min_idle_conns", c.Redis.MinIdleConns)
+	v.nonNegative("redis.dial_timeout_ms", c.Redis.DialTimeoutMs)
+	v.nonNegative("redis.read_timeout_ms", c.Redis.ReadTimeoutMs)
+	v.nonNegative("redis.write_timeout_ms", c.Redis.WriteTimeoutMs)
+	if c.Redis.Breaker.FailureThreshold < 1 {
+		v.add("redis.breaker.failure_threshold", "must be >= 1 (got %d)", c.Redis.Breaker.FailureThreshold)
+	}
+	if c.Redis.Breaker.OpenMs < 1 {
+		v.add("redis.breaker.open_ms", "must be >= 1 (got %d)", c.Redis.Breaker.OpenMs)
+	}
+
+	// ---- limiter ----
+	switch c.Limiter.Backend {
+	case "redis", "memory":
+	default:
+		v.add("limiter.backend", "unknown backend %q (want redis or memory)", c.Limiter.Backend)
+	}
+	if c.Limiter.Replicas < 1 {
+		v.add("limiter.replicas", "must be >= 1 (got %d)", c.Limiter.Replicas)
+	}
+
+	// ---- identity ----
+	src := strings.TrimSpace(c.Identity.Source)
+	switch {
+	case src == "", strings.EqualFold(src, "ip"):
+	case strings.HasPrefix(strings.ToLower(src), "header:"):
+		if strings.TrimSpace(src[len("header:"):]) == "" {
+			v.add("identity.source", "header name is empty (want header:<Header-Name>)")
+		}
+	default:
+		v.add("identity.source", "unknown source %q (want header:<Header-Name> or ip)", src)
+	}
+	if src != "" && len(c.Identity.Sources) > 0 {
+		v.add("identity.source", "set either source or sources, not both")
+	}
+	for i, s := range c.Identity.Sources {
+		v.identitySource(fmt.Sprintf("identity.sources[%d]", i), s, true)
+	}
+	if p := c.Identity.IPPrefix; p.V4 < 0 || p.V4 > 32 {
+		v.add("identity.ip_prefix.v4", "must be within [1, 32], or 0 for the default (got %d)", p.V4)
+	}
+	if p := c.Identity.IPPrefix; p.V6 < 0 || p.V6 > 128 {
+		v.add("identity.ip_prefix.v6", "must be within [1, 128], or 0 for the default (got %d)", p.V6)
+	}
+	for i, p := range c.Identity.TrustedProxies {
+		if _, err := ParsePrefix(p); err != nil {
+			v.add(fmt.Sprintf("identity.trusted_proxies[%d]", i), "%v", err)
+		}
+	}
+	switch strings.ToLower(c.Identity.ForwardedHeader) {
+	case "", "x-forwarded-for", "forwarded":
+	default:
+		v.add("identity.forwarded_header", "unknown header %q (want x-forwarded-for or forwarded)", c.Identity.ForwardedHeader)
+	}
+	if c.Server.ProxyProtocol && len(c.Identity.TrustedProxies) == 0 {
+		v.add("server.proxy_protocol", "needs identity.trusted_proxies (the load balancers allowed to send PROXY headers)")
+	}
+	if t := c.Server.TLS; (t.CertFile == "") != (t.KeyFile == "") {
+		v.add("server.tls", "cert_file and key_file must be set together")
+	} else if t.ClientCAFile != "" && t.CertFile == "" {
+		v.add("server.tls.client_ca_file", "needs cert_file and key_file")
+	}
+
+	// ---- limits ----
+	v.limit("limits.default", c.Limits.Default)
+	routes := make([]string, 0, len(c.Limits.Routes))
+	for r := range c.Limits.Routes {
+		routes = append(routes, r)
+	}
+	sort.Strings(routes)
+	patterns := map[string]string{}
+	for _, r := range routes {
+		path := fmt.Sprintf("limits.routes[%q]", r)
+		if p, err := ParseRoute(r); err != nil {
+			v.add(path, "invalid route: %v", err)
+		} else if other, dup := patterns[p.String()]; dup {
+			v.add(path, "matches the same requests as %q", other)
+		} else {
+			patterns[p.String()] = r
+		}
+		v.limit(path, c.Limits.Routes[r])
+	}
+	v.globalClient("limits.global_client", c.Limits.GlobalClient)
+	if g := c.Limits.GlobalClient; g.Burst > 0 {
+		if c.Limits.Default.Cost > g.Burst {
+			v.add("limits.default.cost", "exceeds limits.global_client.burst (%d); every request would be denied", g.Burst)
+		}
+		for _, r := range routes {
+			if cost := c.Limits.Routes[r].Cost; cost > g.Burst {
+				v.add(fmt.Sprintf("limits.routes[%q].cost", r), "exceeds limits.global_client.burst (%d); every request would be denied", g.Burst)
+			}
+		}
+	}
+	for i, a := range c.Limits.IPAggregates {
+		path := fmt.Sprintf("limits.ip_aggregates[%d]", i)
+		if a.V4 < 0 || a.V4 > 32 {
+			v.add(path+".v4", "must be within [1, 32], or 0 to skip IPv4 (got %d)", a.V4)
+		}
+		if a.V6 < 0 || a.V6 > 128 {
+			v.add(path+".v6", "must be within [1, 128], or 0 to skip IPv6 (got %d)", a.V6)
+		}
+		if a.V4 == 0 && a.V6 == 0 {
+			v.add(path, "needs v4 or v6")
+		}
+		v.sharedLimit(path+".limit", a.Limit)
+	}
+
+	// ---- anomaly ----
+	a := c.Anomaly
+	v.nonNegative("anomaly.window_seconds", a.WindowSeconds)
+	v.nonNegative("anomaly.buckets", a.Buckets)
+	if a.ThresholdMultiplier != 0 && a.ThresholdMultiplier <= 1 {
+		v.add("anomaly.threshold_multiplier", "must be > 1, or 0 for the default (got %g)", a.ThresholdMultiplier)
+	}
+	if a.EWMAAlpha < 0 || a.EWMAAlpha > 1 {
+		v.add("anomaly.ewma_alpha", "must be within (0, 1], or 0 for the default (got %g)", a.EWMAAlpha)
+	}
+	v.nonNegative("anomaly.ttl_seconds", a.TTLSeconds)
+	v.nonNegative("anomaly.evict_every_seconds", a.EvictEverySeconds)
+	v.nonNegative("anomaly.keep_suspicious_seconds", a.KeepSuspiciousSeconds)
+
+	// ---- mitigation ----
+	m := c.Mitigation
+	if m.MinRPS < 0 {
+		v.add("mitigation.min_rps", "must be >= 0 (got %g)", m.MinRPS)
+	}
+	v.nonNegative("mitigation.min_burst", m.MinBurst)
+	// A zero TTL would store overrides/blocks in Redis without expiry.
+	if m.OverrideTTLSeconds <= 0 {
+		v.add("mitigation.override_ttl_seconds", "must be > 0 (got %d)", m.OverrideTTLSeconds)
+	}
+	if m.BlockTTLSeconds <= 0 {
+		v.add("mitigation.block_ttl_seconds", "must be > 0 (got %d)", m.BlockTTLSeconds)
+	}
+	if m.StepRamp.Enabled {
+		if len(m.StepRamp.Steps) == 0 {
+			v.add("mitigation.step_ramp.steps", "must not be empty when step_ramp is enabled")
+		}
+		for i, f := range m.StepRamp.Steps {
+			if f <= 0 || f > 1 {
+				v.add(fmt.Sprintf("mitigation.step_ramp.steps[%d]", i), "must be within (0, 1] (got %g)", f)
+			}
+		}
+	}
+	v.nonNegative("mitigation.step_ramp.step_seconds", m.StepRamp.StepSeconds)
+	if m.RepeatOffender.WindowSeconds <= 0 {
+		v.add("mitigation.repeat_offender.window_seconds", "must be > 0 (got %d)", m.RepeatOffender.WindowSeconds)
+	}
+	if m.RepeatOffender.Threshold < 1 {
+		v.add("mitigation.repeat_offender.threshold", "must be >= 1 (got %d); 0 blocks on the first anomaly", m.RepeatOffender.Threshold)
+	}
+	for i, pat := range m.Allowlist.Clients {
+		if strings.TrimSpace(pat) == "" {
+			v.add(fmt.Sprintf("mitigation.allowlist.clients[%d]", i), "must not be empty")
+		}
+	}
+
+	// ---- adaptive ----
+	ad := c.Adaptive
+	v.nonNegative("adaptive.interval_seconds", ad.IntervalSeconds)
+	v.nonNegative("adaptive.window_seconds", ad.WindowSeconds)
+	v.nonNegative("adaptive.min_samples", ad.MinSamples)
+	v.nonNegative("adaptive.latency_p95_ms", ad.LatencyP95Ms)
+	if ad.ErrorRate < 0 || ad.ErrorRate > 1 {
+		v.add("adaptive.error_rate", "must be within [0, 1] (got %g)", ad.ErrorRate)
+	}
+	if ad.Decrease < 0 || ad.Decrease >= 1 {
+		v.add("adaptive.decrease", "must be within (0, 1), or 0 for the default (got %g)", ad.Decrease)
+	}
+	if ad.Increase < 0 || ad.Increase > 1 {
+		v.add("adaptive.increase", "must be within (0, 1], or 0 for the default (got %g)", ad.Increase)
+	}
+	if ad.MinFactor < 0 || ad.MinFactor > 1 {
+		v.add("adaptive.min_factor", "must be within (0, 1], or 0 for the default (got %g)", ad.MinFactor)
+	}
+	if ad.Enabled && ad.LatencyP95Ms == 0 && ad.ErrorRate == 0 {
+		v.add("adaptive", "needs latency_p95_ms or error_rate when enabled")
+	}
+
+	// ---- shedding ----
+	sh := c.Shedding
+	classes := map[string]bool{}
+	for i, cl := range sh.Classes {
+		path := fmt.Sprintf("shedding.classes[%d]", i)
+		switch {
+		case cl.Name == "":
+			v.add(path+".name", "must not be empty")
+		case classes[cl.Name]:
+			v.add(path+".name", "duplicate class %q", cl.Name)
+		}
+		classes[cl.Name] = true
+		if cl.ShedAt <= 0 {
+			v.add(path+".shed_at", "must be > 0 (got %g)", cl.ShedAt)
+		}
+	}
+	if sh.Enabled {
+		if sh.MaxInFlight <= 0 {
+			v.add("shedding.max_in_flight", "must be > 0 when shedding is enabled (got %d)", sh.MaxInFlight)
+		}
+		if len(sh.Classes) == 0 {
+			v.add("shedding.classes", "must not be empty when shedding is enabled")
+		}
+	}
+	if sh.Status != 0 && (sh.Status < 400 || sh.Status > 599) {
+		v.add("shedding.status", "must be a 4xx or 5xx status (got %d)", sh.Status)
+	}
+	class := func(path, name string) {
+		if name != "" && !classes[name] {
+			v.add(path, "unknown class %q (not in shedding.classes)", name)
+		}
+	}
+	class("shedding.default_class", sh.DefaultClass)
+	class("identity.priority.anonymous", c.Identity.Priority.Anonymous)
+	for _, id := range slices.Sorted(maps.Keys(c.Identity.Priority.Clients)) {
+		class(fmt.Sprintf("identity.priority.clients[%q]", id), c.Identity.Priority.Clients[id])
+	}
+	class("limits.default.priority", c.Limits.Default.Priority)
+	for _, r := range routes {
+		class(fmt.Sprintf("limits.routes[%q].priority", r), c.Limits.Routes[r].Priority)
+	}
+
+	// ---- plans ----
+	pl := c.Plans
+	for _, name := range slices.Sorted(maps.Keys(pl.Tiers)) {
+		t, path := pl.Tiers[name], fmt.Sprintf("plans.tiers[%q]", name)
+		if d := t.Default; d.RPS != 0 || d.Burst != 0 {
+			v.limit(path+".default", d)
+			class(path+".default.priority", d.Priority)
+		}
+		for _, r := range slices.Sorted(maps.Keys(t.Routes)) {
+			l, rpath := t.Routes[r], fmt.Sprintf("%s.routes[%q]", path, r)
+			if _, ok := c.Limits.Routes[r]; !ok {
+				v.add(rpath, "unknown route (plans can only retune routes in limits.routes)")
+			}
+			v.limit(rpath, l)
+			class(rpath+".priority", l.Priority)
+		}
+		v.globalClient(path+".global_client", t.GlobalClient)
+		if len(t.IPAggregates) > 0 {
+			v.add(path+".ip_aggregates", "only applies to limits (aggregates are shared by all plans)")
+		}
+	}
+	if pl.Anonymous != "" {
+		if _, ok := pl.Tiers[pl.Anonymous]; !ok {
+			v.add("plans.anonymous", "unknown plan %q (not in plans.tiers)", pl.Anonymous)
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(pl.Keys)) {
+		name := pl.Keys[id]
+		if _, ok := pl.Tiers[name]; !ok {
+			v.add(fmt.Sprintf("plans.keys[%q]", id), "unknown plan %q (not in plans.tiers)", name)
+		}
+	}
+	v.nonNegative("plans.refresh_seconds", pl.RefreshSeconds)
+
+	// ---- tenants ----
+	tn := c.Tenants
+	if tn.Enabled {
+		if tn.Source.Type != "" {
+			if tn.Source.Type == "ip" || tn.Source.Type == "composite" {
+				v.add("tenants.source.type", "%s sources cannot name an org", tn.Source.Type)
+			} else {
+				v.identitySource("tenants.source", tn.Source, false)
+			}
+		} else if len(tn.Keys) == 0 {
+			v.add("tenants", "needs source or keys to find a client's org")
+		}
+		v.tenantQuota("tenants.default", tn.Default, tn.Source.Type != "")
+	}
+	for _, org := range slices.Sorted(maps.Keys(tn.Orgs)) {
+		v.tenantQuota(fmt.Sprintf("tenants.orgs[%q]", org), tn.Orgs[org], tn.Source.Type != "")
+	}
+	v.nonNegative("tenants.metrics_max_tenants", tn.MetricsMaxTenants)
+
+	// ---- access lists ----
+	v.nonNegative("access.refresh_seconds", c.Access.RefreshSeconds)
+	v.accessList("access.allow", c.Access.Allow)
+	v.accessList("access.deny", c.Access.Deny)
+
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// globalClient checks a global_client limit; it is disabled while rps and
+// burst are both 0.
+func (v *validator) globalClient(path string, g Limit) {
+	if g.RPS != 0 || g.Burst != 0 {
+		// The global bucket is charged with each route's cost, so its own cost is unused.
+		if g.RPS <= 0 && g.WindowSeconds <= 0 {
+			v.add(path+".rps", "must be > 0 when global_client is enabled (got %g)", g.RPS)
+		}
+		if g.Burst <= 0 {
+			v.add(path+".burst", "must be > 0 when global_client is enabled (got %d)", g.Burst)
+		}
+	}
+	if g.Concurrency != (Concurrency{}) {
+		v.concurrency(path+".concurrency", g.Concurrency)
+		if g.Concurrency.Route != 0 {
+			v.add(path+".concurrency.route", "only applies to routes (use per_client)")
+		}
+	}
+	if g.MaxDelayMs != 0 {
+		v.add(path+".max_delay_ms", "only applies to routes")
+	}
+	if g.Priority != "" {
+		v.add(path+".priority", "only applies to routes")
+	}
+}
+
+// sharedLimit checks the limit of a bucket shared by many clients (IP
+// aggregates, orgs). Like global_client, such buckets are charged with the
+// route's cost, so their own cost may be left out.
+func (v *validator) sharedLimit(path string, l Limit) {
+	if l.Cost == 0 {
+		l.Cost = 1
+	}
+	v.limit(path, l)
+	if l.MaxDelayMs != 0 || l.Concurrency != (Concurrency{}) {
+		v.add(path, "max_delay_ms and concurrency only apply to routes")
+	}
+}
+
+// tenantQuota checks one org quota. With a tenants.source the keys of an org
+// are not known up front, so split fairness cannot count them.
+func (v *validator) tenantQuota(path string, q TenantQuota, fromSource bool) {
+	v.sharedLimit(path+".limit", q.Limit)
+	switch q.Fairness {
+	case "", "shared", "split":
+	default:
+		v.add(path+".fairness", "unknown fairness %q (want shared or split)", q.Fairness)
+	}
+	v.nonNegative(path+".split_ways", q.SplitWays)
+	if q.Fairness == "split" && q.SplitWays == 0 && fromSource {
+		v.add(path+".split_ways", "is required for fairness split when orgs come from tenants.source")
+	}
+}
+
+func (v *validator) accessList(path string, l AccessList) {
+	for i, e := range l.Entries {
+		if strings.TrimSpace(e) == "" {
+			v.add(fmt.Sprintf("%s.entries[%d]", path, i), "must not be empty")
+		}
+	}
+	for i, f := range l.Files {
+		if strings.TrimSpace(f) == "" {
+			v.add(fmt.Sprintf("%s.files[%d]", path, i), "must not be empty")
+		}
+	}
+}
+
+func (v *validator) nonNegative(path string, n int) {
+	if n < 0 {
+		v.add(path, "must be >= 0 (got %d)", n)
+	}
+}
+
+// limit checks one {rps, burst, cost} block.
+func (v *validator) limit(path string, l Limit) {
+	windowed := false
+	switch l.Algorithm {
+	case "", "token_bucket", "gcra":
+	case "fixed_window", "sliding_window", "sliding_log":
+		windowed = true
+	default:
+		v.add(path+".algorithm", "unknown algorithm %q (want token_bucket, gcra, fixed_window, sliding_window or sliding_log)", l.Algorithm)
+	}
+	v.nonNegative(path+".window_seconds", l.WindowSeconds)
+	switch {
+	case windowed && l.WindowSeconds > 0:
+		if l.RPS < 0 {
+			v.add(path+".rps", "must be >= 0 (got %g)", l.RPS)
+		}
+	case windowed:
+		if l.RPS <= 0 {
+			v.add(path+".rps", "must be > 0 unless window_seconds is set (got %g)", l.RPS)
+		}
+	default:
+		if l.RPS <= 0 {
+			v.add(path+".rps", "must be > 0 (got %g)", l.RPS)
+		}
+		if l.WindowSeconds > 0 {
+			v.add(path+".window_seconds", "only applies to fixed_window, sliding_window and sliding_log")
+		}
+	}
+	if l.Burst <= 0 {
+		v.add(path+".burst", "must be > 0 (got %d)", l.Burst)
+	}
+	if l.Cost <= 0 {
+		v.add(path+".cost", "must be > 0 (got %d)", l.Cost)
+	} else if l.Burst > 0 && l.Cost > l.Burst {
+		v.add(path+".cost", "exceeds burst (%d > %d); every request would be denied", l.Cost, l.Burst)
+	}
+	switch l.OnStoreError {
+	case "", "allow", "deny", "local":
+	default:
+		v.add(path+".on_store_error", "unknown mode %q (want allow, deny or local)", l.OnStoreError)
+	}
+
+	if l.BodyCostPerBytes < 0 {
+		v.add(path+".body_cost_per_bytes", "must be >= 0 (got %d)", l.BodyCostPerBytes)
+	}
+	for i, r := range l.CostRules {
+		v.costRule(fmt.Sprintf("%s.cost_rules[%d]", path, i), r)
+	}
+	for i, h := range l.Headers {
+		switch h {
+		case "legacy", "ietf":
+		case "none":
+			if len(l.Headers) > 1 {
+				v.add(fmt.Sprintf("%s.headers[%d]", path, i), "none cannot be combined with other header families")
+			}
+		default:
+			v.add(fmt.Sprintf("%s.headers[%d]", path, i), "unknown header family %q (want legacy, ietf or none)", h)
+		}
+	}
+	v.nonNegative(path+".max_delay_ms", l.MaxDelayMs)
+	v.nonNegative(path+".max_queue", l.MaxQueue)
+	if l.MaxQueue > 0 && l.MaxDelayMs == 0 {
+		v.add(path+".max_queue", "only applies with max_delay_ms")
+	}
+	for _, reason := range slices.Sorted(maps.Keys(l.Responses)) {
+		v.response(fmt.Sprintf("%s.responses[%q]", path, reason), reason, l.Responses[reason])
+	}
+	v.reconcile(path+".reconcile", l.Reconcile)
+	v.concurrency(path+".concurrency", l.Concurrency)
+
+	seen := map[int]bool{}
+	for i, w := range l.Windows {
+		wp := fmt.Sprintf("%s.windows[%d]", path, i)
+		switch w.Algorithm {
+		case "", "token_bucket", "gcra", "fixed_window", "sliding_window", "sliding_log":
+		default:
+			v.add(wp+".algorithm", "unknown algorithm %q (want token_bucket, gcra, fixed_window, sliding_window or sliding_log)", w.Algorithm)
+		}
+		if w.WindowSeconds <= 0 {
+			v.add(wp+".window_seconds", "must be > 0 (got %d)", w.WindowSeconds)
+		} else if seen[w.WindowSeconds] {
+			v.add(wp+".window_seconds", "duplicate window of %ds", w.WindowSeconds)
+		}
+		seen[w.WindowSeconds] = true
+		if w.Limit <= 0 {
+			v.add(wp+".limit", "must be > 0 (got %d)", w.Limit)
+		} else if l.Cost > w.Limit {
+			v.add(wp+".limit", "below cost (%d < %d); every request would be denied", w.Limit, l.Cost)
+		}
+	}
+}
+
+func (v *validator) costRule(path string, r CostRule) {
+	sources := 0
+	for _, set := range []bool{r.Query != "", r.QueryRange != [2]string{}, r.Header != "", r.ContentLength} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		v.add(path, "set exactly one of query, query_range, header or content_length")
+	}
+	if r.QueryRange != [2]string{} && (r.QueryRange[0] == "" || r.QueryRange[1] == "") {
+		v.add(path+".query_range", "want [from, to] parameter names")
+	}
+	switch {
+	case r.Equals != "" && r.GTE != 0:
+		v.add(path, "set only one of gte or equals")
+	case r.Equals != "" && (r.ContentLength || r.QueryRange != [2]string{}):
+		v.add(path+".equals", "only applies to query and header")
+	case r.Equals == "" && r.GTE <= 0:
+		v.add(path+".gte", "must be > 0 (got %g)", r.GTE)
+	}
+	if r.Cost <= 0 {
+		v.add(path+".cost", "must be > 0 (got %d)", r.Cost)
+	}
+}
+
+func (v *validator) reconcile(path string, r Reconcile) {
+	v.nonNegative(path+".slow_ms", r.SlowMs)
+	if r.SlowCost < 0 || r.LargeBytes < 0 || r.LargeCost < 0 || r.RefundCached < 0 {
+		v.add(path, "values must be >= 0")
+	}
+	if (r.SlowMs > 0) != (r.SlowCost > 0) {
+		v.add(path, "slow_ms and slow_cost must be set together")
+	}
+	if (r.LargeBytes > 0) != (r.LargeCost > 0) {
+		v.add(path, "large_bytes and large_cost must be set together")
+	}
+}
+
+func (v *validator) concurrency(path string, c Concurrency) {
+	if c.PerClient < 0 {
+		v.add(path+".per_client", "must be >= 0 (got %d)", c.PerClient)
+	}
+	if c.Route < 0 {
+		v.add(path+".route", "must be >= 0 (got %d)", c.Route)
+	}
+	v.nonNegative(path+".lease_seconds", c.LeaseSeconds)
+	switch c.Status {
+	case 0, 429, 503:
+	default:
+		v.add(path+".status", "must be 429 or 503 (got %d)", c.Status)
+	}
+}
+
+// DenialReasons are the keys of limits.*.responses.
+var DenialReasons = []string{
+	"denied", "blocked", "rate_limited", "rate_limited_global", "rate_limited_aggregate",
+	"rate_limited_tenant", "concurrency_limited", "store_unavailable", "overloaded",
+}
+
+func (v *validator) response(path, reason string, r Response) {
+	if !slices.Contains(DenialReasons, reason) {
+		v.add(path, "unknown reason %q (want one of %s)", reason, strings.Join(DenialReasons, ", "))
+	}
+	switch {
+	case r.Status == 0:
+	case r.Status >= 300 && r.Status < 400:
+		if r.Redirect == "" {
+			v.add(path+".status", "a 3xx status needs redirect")
+		}
+	case r.Status < 400 || r.Status > 599:
+		v.add(path+".status", "must be 3xx (with redirect), 4xx or 5xx (got %d)", r.Status)
+	}
+	if r.Redirect != "" {
+		if u, err := url.Parse(r.Redirect); err != nil || (u.Scheme == "" && !strings.HasPrefix(u.Path, "/")) {
+			v.add(path+".redirect", "must be an absolute URL or path (got %q)", r.Redirect)
+		}
+	}
+	for _, mt := range slices.Sorted(maps.Keys(r.Templates)) {
+		body := r.Templates[mt]
+		tp := fmt.Sprintf("%s.templates[%q]", path, mt)
+		if _, _, err := mime.ParseMediaType(mt); err != nil {
+			v.add(tp, "invalid media type: %v", err)
+			continue
+		}
+		if _, err := template.New(mt).Parse(body); err != nil {
+			v.add(tp, "invalid template: %v", err)
+		}
+	}
+}
+
+func (v *validator) identitySource(path string, s IdentitySource, top bool) {
+	switch s.Type {
+	case "header", "cookie", "query":
+		if strings.TrimSpace(s.Name) == "" {
+			v.add(path+".name", "is required for %s sources", s.Type)
+		}
+	case "jwt":
+		if s.Claim == "" {
+			v.add(path+".claim", "is required for jwt sources")
+		}
+		if s.PublicKeyFile != "" && (s.HMACSecret != "" || s.HMACSecretEnv != "") {
+			v.add(path, "set either an HMAC secret or public_key_file, not both")
+		}
+		if s.PublicKeyFile != "" {
+			if b, err := os.ReadFile(s.PublicKeyFile); err != nil {
+				v.add(path+".public_key_file", "%v", err)
+			} else if blk, _ := pem.Decode(b); blk == nil {
+				v.add(path+".public_key_file", "no PEM block in %s", s.PublicKeyFile)
+			}
+		}
+		if s.HMACSecretEnv != "" && os.Getenv(s.HMACSecretEnv) == "" {
+			v.add(path+".hmac_secret_env", "environment variable %s is empty", s.HMACSecretEnv)
+		}
+	case "mtls":
+		switch s.Field {
+		case "", "cn", "subject", "fingerprint":
+		default:
+			v.add(path+".field", "unknown field %q (want cn, subject or fingerprint)", s.Field)
+		}
+	case "ip":
+	case "composite":
+		if !top {
+			v.add(path, "composite sources cannot be nested")
+			return
+		}
+		if len(s.Parts) < 2 {
+			v.add(path+".parts", "a composite needs at least two parts")
+		}
+		for i, p := range s.Parts {
+			v.identitySource(fmt.Sprintf("%s.parts[%d]", path, i), p, false)
+		}
+	default:
+		v.add(path+".type", "unknown source type %q (want header, cookie, query, jwt, mtls, ip or composite)", s.Type)
+	}
+	if s.Type != "composite" && len(s.Parts) > 0 {
+		v.add(path+".parts", "only applies to composite sources")
+	}
+}
+
+// ParsePrefix reads a CIDR or a single IP (as a /32 or /128).
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen()), nil
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// TestValidate edits the shipped configs/policies.yaml.
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func(c *Config)
+		paths []string // problems reported, in order; nil = valid
+	}{
+		{"shipped policy", func(*Config) {}, nil},
+		{"bad server addr", func(c *Config) { c.Server.Addr = "8080" }, []string{"server.addr"}},
+		{"sentinel needs addrs and master", func(c *Config) { c.Redis.Mode = "sentinel" },
+			[]string{"redis.addrs", "redis.master_name"}},
+		{"cluster tls needs server_name", func(c *Config) {
+			c.Redis.Mode, c.Redis.Addrs, c.Redis.TLS.Enabled = "cluster", []string{"redis-0:6379"}, true
+		}, []string{"redis.tls.server_name"}},
+		{"cluster tls with server_name", func(c *Config) {
+			c.Redis.Mode, c.Redis.Addrs, c.Redis.TLS.Enabled = "cluster", []string{"redis-0:6379"}, true
+			c.Redis.TLS.ServerName = "redis.internal"
+		}, nil},
+		{"sentinel tls skipping verification", func(c *Config) {
+			c.Redis.Mode, c.Redis.Addrs, c.Redis.MasterName = "sentinel", []string{"s1:26379"}, "mymaster"
+			c.Redis.TLS.Enabled, c.Redis.TLS.InsecureSkipVerify = true, true
+		}, nil},
+		{"single tls defaults server_name", func(c *Config) { c.Redis.TLS.Enabled = true }, nil},
+		{"forwarded_header", func(c *Config) { c.Identity.ForwardedHeader = "X-Real-IP" },
+			[]string{"identity.forwarded_header"}},
+		{"forwarded_header is case-insensitive", func(c *Config) { c.Identity.ForwardedHeader = "Forwarded" }, nil},
+		{"bad trusted proxy", func(c *Config) { c.Identity.TrustedProxies = []string{"10.0.0.0/33"} },
+			[]string{"identity.trusted_proxies[0]"}},
+		{"routes in key order", func(c *Config) {
+			c.Limits.Routes = map[string]Limit{"/b": {RPS: -1, Burst: 1, Cost: 1}, "/a": {RPS: 1, Burst: 1, Cost: 1, Algorithm: "leaky"}}
+		}, []string{`limits.routes["/a"].algorithm`, `limits.routes["/b"].rps`}},
+		{"split fairness from keys", func(c *Config) {
+			c.Tenants.Enabled, c.Tenants.Keys = true, map[string]string{"k1": "acme", "k2": "acme"}
+			c.Tenants.Default = TenantQuota{Limit: Limit{RPS: 10, Burst: 10}, Fairness: "split"}
+		}, nil},
+		{"split fairness from a source needs split_ways", func(c *Config) {
+			c.Tenants.Enabled, c.Tenants.Source = true, IdentitySource{Type: "header", Name: "X-Org"}
+			c.Tenants.Default = TenantQuota{Limit: Limit{RPS: 10, Burst: 10}, Fairness: "split"}
+			c.Tenants.Orgs = map[string]TenantQuota{"acme": {Limit: Limit{RPS: 10, Burst: 10}, Fairness: "split", SplitWays: 4}}
+		}, []string{"tenants.default.split_ways"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := LoadFile("../../configs/policies.yaml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.edit(c)
+			err = c.Validate()
+			var ve ValidationError
+			if err != nil && !errors.As(err, &ve) {
+				t.Fatalf("error %T, want ValidationError", err)
+			}
+			var got []string
+			for _, fe := range ve {
+				got = append(got, fe.Path)
+			}
+			if !slices.Equal(got, tt.paths) {
+				t.Fatalf("problems at %q, want %q (%v)", got, tt.paths, err)
+			}
+		})
+	}
+}
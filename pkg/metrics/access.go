@@ -0,0 +1,27 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	// stormgate_access_list_entries{list}
+	AccessListEntries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "stormgate_access_list_entries",
+			Help: "Entries loaded into the allow and deny lists (all sources).",
+		},
+		[]string{"list"},
+	)
+
+	// stormgate_access_denied_total{route}
+	AccessDenied = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stormgate_access_denied_total",
+			Help: "Requests rejected because the client is on the deny list.",
+		},
+		[]string{"route"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(AccessListEntries, AccessDenied)
+}
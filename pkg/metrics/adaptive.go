@@ -0,0 +1,37 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	// stormgate_adaptive_factor{route}
+	// Share of the route limit currently granted by adaptive protection (1 = untouched).
+	AdaptiveFactor = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "stormgate_adaptive_factor",
+			Help: "Factor applied to the route limit by adaptive upstream protection (1 = untouched).",
+		},
+		[]string{"route"},
+	)
+
+	// stormgate_upstream_latency_p95_seconds{route}
+	UpstreamLatencyP95 = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "stormgate_upstream_latency_p95_seconds",
+			Help: "p95 latency of proxied requests over the adaptive window.",
+		},
+		[]string{"route"},
+	)
+
+	// stormgate_upstream_error_ratio{route}
+	UpstreamErrorRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "stormgate_upstream_error_ratio",
+			Help: "Share of proxied requests answered with a 5xx over the adaptive window.",
+		},
+		[]string{"route"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(AdaptiveFactor, UpstreamLatencyP95, UpstreamErrorRatio)
+}
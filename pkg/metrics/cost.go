@@ -0,0 +1,19 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	// stormgate_cost_adjusted_tokens_total{route,direction}
+	// Tokens charged (extra) or refunded (refund) after the response completed.
+	CostAdjusted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stormgate_cost_adjusted_tokens_total",
+			Help: "Tokens charged or refunded by post-response cost reconciliation.",
+		},
+		[]string{"route", "direction"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(CostAdjusted)
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	// stormgate_degraded_decisions_total{route,mode}
+	// Requests decided without Redis (mode: allow | deny | local).
+	DegradedDecisions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stormgate_degraded_decisions_total",
+			Help: "Requests decided by the on_store_error policy because the limiter store was unavailable.",
+		},
+		[]string{"route", "mode"},
+	)
+
+	// stormgate_store_breaker_open (1 while the Redis circuit breaker is open)
+	StoreBreakerOpen = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "stormgate_store_breaker_open",
+			Help: "1 while the limiter store circuit breaker is open, else 0.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(DegradedDecisions, StoreBreakerOpen)
+}
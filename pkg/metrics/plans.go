@@ -0,0 +1,18 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	// stormgate_plan_clients{plan}
+	PlanClients = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "stormgate_plan_clients",
+			Help: "Clients mapped to each plan (unmapped clients get the anonymous plan).",
+		},
+		[]string{"plan"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(PlanClients)
+}
@@ -0,0 +1,30 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	// stormgate_queue_depth{route}
+	// Requests currently waiting for tokens (limits.*.max_delay_ms).
+	QueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "stormgate_queue_depth",
+			Help: "Requests currently held waiting for rate-limit tokens.",
+		},
+		[]string{"route"},
+	)
+
+	// stormgate_queue_wait_seconds{route,outcome}
+	// outcome: admitted | timeout | canceled | full (queue full, not held)
+	QueueWait = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "stormgate_queue_wait_seconds",
+			Help:    "Time requests spent waiting for rate-limit tokens, by outcome.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 10), // 5ms .. ~2.5s
+		},
+		[]string{"route", "outcome"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(QueueDepth, QueueWait)
+}
@@ -0,0 +1,26 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	// stormgate_shed_total{route,class}
+	Shed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stormgate_shed_total",
+			Help: "Requests dropped by priority load shedding, per route and class.",
+		},
+		[]string{"route", "class"},
+	)
+
+	// stormgate_shed_utilization (in flight / shedding.max_in_flight)
+	ShedUtilization = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "stormgate_shed_utilization",
+			Help: "Utilization the load shedder decides on (requests in flight over max_in_flight).",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(Shed, ShedUtilization)
+}
@@ -0,0 +1,19 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	// stormgate_tenant_requests_total{tenant,outcome}; tenant is bounded by
+	// tenants.metrics_max_tenants (the rest are "other")
+	TenantRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stormgate_tenant_requests_total",
+			Help: "Rate-limit decisions per tenant org: allowed, or the denial reason.",
+		},
+		[]string{"tenant", "outcome"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(TenantRequests)
+}